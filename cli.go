@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/migrate"
+	"github.com/sotiri-geo/url-shortener/internal/snapshot"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+const usage = `usage:
+  url-shortener                        run the server
+  url-shortener export [flags]         write every link to a file or stdout
+  url-shortener import [flags] [file]  load links from a file or stdin
+  url-shortener migrate [flags]        copy every link to another backend and verify it
+  url-shortener snapshot [flags]       take a snapshot now, or list them with -list
+  url-shortener restore [flags] [file] load a snapshot, the newest one by default
+
+The storage backend is configured with the same environment variables as the server.`
+
+// runCommand runs the subcommand named by args[0] and returns the process exit code.
+func runCommand(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch args[0] {
+	case "export":
+		err = exportCommand(ctx, args[1:])
+	case "import":
+		err = importCommand(ctx, args[1:])
+	case "migrate":
+		err = migrateCommand(ctx, args[1:])
+	case "snapshot":
+		err = snapshotCommand(ctx, args[1:])
+	case "restore":
+		err = restoreCommand(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintln(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n", args[0], usage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exportCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", string(transfer.JSON), "json, ndjson or csv")
+	output := flags.String("o", "-", "file to write, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	f, err := transfer.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	store, closeStore, err := openCommandBackend()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	buffered := bufio.NewWriter(out)
+	exported, err := transfer.Export(ctx, store, buffered, f)
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write links: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d links\n", exported)
+	return nil
+}
+
+func importCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", string(transfer.JSON), "json, ndjson or csv")
+	conflict := flags.String("conflict", string(transfer.Skip), "what to do with taken short codes: skip, overwrite or rename")
+	dryRun := flags.Bool("dry-run", false, "report what would happen without saving anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	opts := transfer.Options{DryRun: *dryRun}
+	var err error
+	if opts.Format, err = transfer.ParseFormat(*format); err != nil {
+		return err
+	}
+	if opts.Strategy, err = transfer.ParseStrategy(*conflict); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	store, closeStore, err := openCommandBackend()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	ctx, closeAudit, err := auditCommand(ctx, &opts)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+
+	report, importErr := transfer.Import(ctx, store, bufio.NewReader(in), opts)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return importErr
+}
+
+func migrateCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := flags.String("from", envOr("STORAGE_BACKEND", "memory"), "backend to copy from")
+	fromURL := flags.String("from-url", "", "location of the source backend, defaults to its environment variable")
+	to := flags.String("to", "", "backend to copy to")
+	toURL := flags.String("to-url", "", "location of the target backend, defaults to its environment variable")
+	batch := flags.Int("batch", migrate.DefaultBatchSize, "links copied per batch")
+	checkpoint := flags.String("checkpoint", "migrate.checkpoint", "file recording progress so an interrupted copy resumes, empty disables it")
+	verifyOnly := flags.Bool("verify-only", false, "compare the backends without copying")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("migrate needs a -to backend")
+	}
+	if *from == "memory" || *to == "memory" {
+		return errors.New("the memory backend does not outlive the process and cannot be migrated")
+	}
+
+	source, closeSource, err := openNamedBackend(*from, *fromURL)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+	target, closeTarget, err := openNamedBackend(*to, *toURL)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+
+	if !*verifyOnly {
+		progress, err := migrate.Copy(ctx, source, target, migrate.Options{
+			BatchSize:  *batch,
+			Checkpoint: *checkpoint,
+			Source:     describeBackend(*from, *fromURL),
+			Target:     describeBackend(*to, *toURL),
+			Progress: func(p migrate.Progress) {
+				done := p.Copied + p.Unchanged
+				fmt.Fprintf(os.Stderr, "%d links, %d copied, %d unchanged, up to %q (%.0f links/s)\n",
+					done, p.Copied, p.Unchanged, p.LastCode, float64(done)/max(p.Elapsed.Seconds(), 0.001))
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("%w, rerun to resume from %q", err, progress.LastCode)
+		}
+		if progress.Resumed {
+			fmt.Fprintln(os.Stderr, "resumed from checkpoint")
+		}
+	}
+
+	verification, err := migrate.Verify(ctx, source, target, *batch)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(verification); err != nil {
+		return fmt.Errorf("failed to write verification: %w", err)
+	}
+	if !verification.OK() {
+		return errors.New("verification failed, the backends hold different links")
+	}
+	return nil
+}
+
+func snapshotCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	opts, _, err := snapshotOptions()
+	if err != nil {
+		return err
+	}
+	flags.StringVar(&opts.Dir, "dir", orDefault(opts.Dir, "snapshots"), "snapshot directory")
+	flags.IntVar(&opts.Keep, "keep", opts.Keep, "number of snapshots to keep, 0 keeps all")
+	flags.DurationVar(&opts.MaxAge, "max-age", opts.MaxAge, "remove snapshots older than this, 0 keeps them")
+	list := flags.Bool("list", false, "list and validate the snapshots instead of taking one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *list {
+		snapshots, err := snapshot.List(opts.Dir)
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			status := "ok"
+			count, err := snapshot.Validate(s.Path)
+			if err != nil {
+				status = err.Error()
+			}
+			fmt.Fprintf(os.Stdout, "%s\t%s\t%d bytes\t%d links\t%s\n", s.Taken.Format(time.RFC3339), s.Path, s.Size, count, status)
+		}
+		return nil
+	}
+
+	store, closeStore, err := openCommandBackend()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	s, err := snapshot.Take(ctx, store, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, s.Path)
+	return nil
+}
+
+func restoreCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := flags.String("dir", envOr("SNAPSHOT_DIR", "snapshots"), "snapshot directory to restore the newest snapshot from")
+	conflict := flags.String("conflict", string(transfer.Overwrite), "what to do with taken short codes: skip, overwrite or rename")
+	dryRun := flags.Bool("dry-run", false, "validate and report what would happen without saving anything")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	opts := transfer.Options{DryRun: *dryRun}
+	var err error
+	if opts.Strategy, err = transfer.ParseStrategy(*conflict); err != nil {
+		return err
+	}
+
+	path := flags.Arg(0)
+	if path == "" {
+		snapshots, err := snapshot.List(*dir)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("no snapshots in %s", *dir)
+		}
+		path = snapshots[0].Path
+	}
+	fmt.Fprintf(os.Stderr, "restoring %s\n", path)
+
+	store, closeStore, err := openCommandBackend()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	ctx, closeAudit, err := auditCommand(ctx, &opts)
+	if err != nil {
+		return err
+	}
+	defer closeAudit()
+	report, restoreErr := snapshot.Restore(ctx, store, path, opts)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return restoreErr
+}
+
+// auditCommand records the links an import writes in the audit log at
+// AUDIT_LOG, if set, under the name of the user running the command.
+func auditCommand(ctx context.Context, opts *transfer.Options) (context.Context, func(), error) {
+	sink, closeAudit, err := openAuditSink()
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	ctx = audit.WithActor(ctx, audit.Actor{Name: "cli:" + envOr("USER", "unknown")})
+	opts.Changed = func(ctx context.Context, before *link.Link, after link.Link) {
+		action := audit.Create
+		if before != nil {
+			action = audit.Update
+		}
+		if _, err := sink.Record(ctx, audit.NewEvent(ctx, action, before, &after)); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to record %s of %s in the audit log: %v\n", action, after.Code, err)
+		}
+	}
+	return ctx, closeAudit, nil
+}
+
+// openCommandBackend opens the backend selected by STORAGE_BACKEND.
+func openCommandBackend() (storage.URLStore, func(), error) {
+	name := envOr("STORAGE_BACKEND", "memory")
+	if name == "memory" {
+		fmt.Fprintln(os.Stderr, "warning: STORAGE_BACKEND is memory, nothing outlives this command")
+	}
+	return openNamedBackend(name, "")
+}
+
+// openNamedBackend opens a backend along with a function releasing its
+// connections.
+// describeBackend identifies the named backend by where it keeps its links,
+// with any password left out, e.g. "postgres postgres://app:xxxxx@db/links".
+func describeBackend(name, location string) string {
+	location = backendLocation(name, location)
+	if name == "file" {
+		if abs, err := filepath.Abs(location); err == nil {
+			location = abs
+		}
+	} else if u, err := url.Parse(location); err == nil {
+		location = u.Redacted()
+	}
+	return name + " " + location
+}
+
+func openNamedBackend(name, location string) (storage.URLStore, func(), error) {
+	store, err := openBackend(name, location)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s storage: %w", name, err)
+	}
+	closeStore := func() {
+		switch s := store.(type) {
+		case interface{ Close() error }:
+			s.Close()
+		case interface{ Close() }:
+			s.Close()
+		}
+	}
+	return store, closeStore, nil
+}
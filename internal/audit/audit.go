@@ -0,0 +1,124 @@
+// Package audit keeps an append-only trail of the changes made to links:
+// what changed, who changed it, when and from where.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+type Action string
+
+const (
+	Create  Action = "create"
+	Update  Action = "update"
+	Delete  Action = "delete"
+	Restore Action = "restore"
+)
+
+// Anonymous is the actor of changes made without an identified caller.
+const Anonymous = "anonymous"
+
+// Event is one change to a link. Before is nil when the link was created.
+type Event struct {
+	// ID is assigned by the sink and increases in the order events are recorded
+	ID       int64      `json:"id"`
+	Code     string     `json:"code"`
+	Action   Action     `json:"action"`
+	Actor    string     `json:"actor"`
+	Claimed  string     `json:"claimed_actor,omitempty"`
+	SourceIP string     `json:"source_ip,omitempty"`
+	At       time.Time  `json:"at"`
+	Before   *link.Link `json:"before,omitempty"`
+	After    *link.Link `json:"after,omitempty"`
+}
+
+// Sink stores events. Events are only ever appended, a recorded event is
+// never changed or removed.
+type Sink interface {
+	// Record stores event and returns it with its ID set
+	Record(ctx context.Context, event Event) (Event, error)
+	// History returns the events recorded for code, oldest first
+	History(ctx context.Context, code string) ([]Event, error)
+}
+
+// NewEvent describes a change made now by the actor in ctx. It keeps copies
+// of before and after with the password hash left out.
+func NewEvent(ctx context.Context, action Action, before, after *link.Link) Event {
+	actor := FromContext(ctx)
+	event := Event{
+		Action:   action,
+		Actor:    actor.Name,
+		Claimed:  actor.Claimed,
+		SourceIP: actor.IP,
+		At:       time.Now().UTC(),
+		Before:   redact(before),
+		After:    redact(after),
+	}
+	if after != nil {
+		event.Code = after.Code
+	} else if before != nil {
+		event.Code = before.Code
+	}
+	return event
+}
+
+func redact(l *link.Link) *link.Link {
+	if l == nil {
+		return nil
+	}
+	c := clone(l)
+	c.PasswordHash = ""
+	return c
+}
+
+func clone(l *link.Link) *link.Link {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.Info = l.Info.Clone()
+	return &c
+}
+
+func (e Event) clone() Event {
+	e.Before, e.After = clone(e.Before), clone(e.After)
+	return e
+}
+
+// Actor is who is making a change and the address they made it from. Name
+// is established by the server, Claimed is whatever name the caller gave for
+// themselves and is kept for reference only, never trusted.
+type Actor struct {
+	Name    string
+	Claimed string
+	IP      string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx naming the actor behind the changes made
+// with it.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// FromContext returns the actor set by WithActor, or Anonymous.
+func FromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	if actor.Name == "" {
+		actor.Name = Anonymous
+	}
+	return actor
+}
+
+// Discard is a Sink that keeps nothing.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Record(ctx context.Context, event Event) (Event, error) { return event, nil }
+
+func (discard) History(ctx context.Context, code string) ([]Event, error) { return nil, nil }
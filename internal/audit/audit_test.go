@@ -0,0 +1,173 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+func TestNewEvent(t *testing.T) {
+	ctx := audit.WithActor(context.Background(), audit.Actor{Name: "token:1a2b3c4d", Claimed: "alice", IP: "203.0.113.7"})
+	before := link.New("abc123", "https://example.com", link.Info{PasswordHash: "pbkdf2$hash", Tags: []string{"docs"}})
+	after := before
+	after.URL = "https://example.org"
+
+	event := audit.NewEvent(ctx, audit.Update, &before, &after)
+	if event.Code != "abc123" || event.Actor != "token:1a2b3c4d" || event.Claimed != "alice" || event.SourceIP != "203.0.113.7" || event.At.IsZero() {
+		t.Errorf("got event %+v", event)
+	}
+	if event.Before.PasswordHash != "" || event.After.PasswordHash != "" {
+		t.Error("the password hash should be left out")
+	}
+	before.Tags[0] = "changed"
+	if event.Before.Tags[0] != "docs" {
+		t.Error("the event should keep a copy of the link")
+	}
+
+	if got := audit.NewEvent(context.Background(), audit.Delete, &before, nil); got.Actor != audit.Anonymous || got.Code != "abc123" {
+		t.Errorf("got event %+v", got)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	sinks := map[string]func(t *testing.T) audit.Sink{
+		"memory": func(t *testing.T) audit.Sink { return audit.NewMemorySink() },
+		"file": func(t *testing.T) audit.Sink {
+			return openFileSink(t, filepath.Join(t.TempDir(), "audit.log"))
+		},
+	}
+	for name, newSink := range sinks {
+		t.Run(name, func(t *testing.T) {
+			testSink(t, newSink(t))
+		})
+	}
+}
+
+func testSink(t *testing.T, sink audit.Sink) {
+	ctx := context.Background()
+	created := link.New("abc123", "https://example.com", link.Info{Title: "Example"})
+	updated := link.New("abc123", "https://example.org", link.Info{Title: "Example"})
+
+	first, err := sink.Record(ctx, audit.NewEvent(ctx, audit.Create, nil, &created))
+	assertNoErr(t, err)
+	_, err = sink.Record(ctx, audit.NewEvent(ctx, audit.Create, nil, &link.Link{Code: "def456", URL: "https://example.net"}))
+	assertNoErr(t, err)
+	last, err := sink.Record(ctx, audit.NewEvent(ctx, audit.Update, &created, &updated))
+	assertNoErr(t, err)
+	if first.ID <= 0 || last.ID <= first.ID {
+		t.Errorf("got ids %d and %d, want them increasing", first.ID, last.ID)
+	}
+
+	history, err := sink.History(ctx, "abc123")
+	assertNoErr(t, err)
+	if len(history) != 2 || history[0].ID != first.ID || history[1].ID != last.ID {
+		t.Fatalf("got history %+v", history)
+	}
+	if history[0].Action != audit.Create || history[0].Before != nil || history[0].After.URL != "https://example.com" {
+		t.Errorf("got create event %+v", history[0])
+	}
+	if history[1].Before.URL != "https://example.com" || history[1].After.URL != "https://example.org" {
+		t.Errorf("got update event %+v", history[1])
+	}
+
+	history[0].After.URL = "https://tampered.example"
+	again, err := sink.History(ctx, "abc123")
+	assertNoErr(t, err)
+	if again[0].After.URL != "https://example.com" {
+		t.Error("changing a returned event changed the recorded one")
+	}
+
+	none, err := sink.History(ctx, "unknown")
+	assertNoErr(t, err)
+	if len(none) != 0 {
+		t.Errorf("got history %+v for an unknown code", none)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("carries on numbering after a reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		sink := openFileSink(t, path)
+		first, err := sink.Record(ctx, audit.Event{Code: "abc123", Action: audit.Create})
+		assertNoErr(t, err)
+		assertNoErr(t, sink.Close())
+
+		reopened := openFileSink(t, path)
+		second, err := reopened.Record(ctx, audit.Event{Code: "abc123", Action: audit.Update})
+		assertNoErr(t, err)
+		if second.ID != first.ID+1 {
+			t.Errorf("got id %d, want %d", second.ID, first.ID+1)
+		}
+	})
+
+	t.Run("recovers from an event cut short", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		sink := openFileSink(t, path)
+		_, err := sink.Record(ctx, audit.Event{Code: "abc123", Action: audit.Create})
+		assertNoErr(t, err)
+		assertNoErr(t, sink.Close())
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		assertNoErr(t, err)
+		_, err = f.WriteString(`{"id":2,"code":"abc`)
+		assertNoErr(t, err)
+		assertNoErr(t, f.Close())
+
+		reopened := openFileSink(t, path)
+		event, err := reopened.Record(ctx, audit.Event{Code: "abc123", Action: audit.Update})
+		assertNoErr(t, err)
+		history, err := reopened.History(ctx, "abc123")
+		assertNoErr(t, err)
+		if len(history) != 2 || history[1].ID != event.ID || event.ID != 2 {
+			t.Errorf("got history %+v", history)
+		}
+	})
+
+	t.Run("rejects events too large for a line", func(t *testing.T) {
+		sink := openFileSink(t, filepath.Join(t.TempDir(), "audit.log"))
+		huge := &link.Link{Code: "abc123", Info: link.Info{Description: strings.Repeat("x", 2<<20)}}
+
+		_, err := sink.Record(ctx, audit.Event{Code: "abc123", Action: audit.Create, After: huge})
+		if !errors.Is(err, audit.ErrEventTooLarge) {
+			t.Errorf("got error %v, want %v", err, audit.ErrEventTooLarge)
+		}
+	})
+
+	t.Run("skips lines too long to be events", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		line := fmt.Sprintf(`{"id":1,"code":"abc123","action":"create","after":{"description":%q}}`, strings.Repeat("x", 2<<20))
+		assertNoErr(t, os.WriteFile(path, []byte(line+"\n"+`{"id":2,"code":"abc123","action":"update"}`+"\n"), 0o640))
+
+		sink := openFileSink(t, path)
+		event, err := sink.Record(ctx, audit.Event{Code: "abc123", Action: audit.Delete})
+		assertNoErr(t, err)
+		history, err := sink.History(ctx, "abc123")
+		assertNoErr(t, err)
+		if len(history) != 2 || history[0].ID != 2 || event.ID != 3 {
+			t.Errorf("got history %+v and id %d", history, event.ID)
+		}
+	})
+}
+
+func openFileSink(t *testing.T, path string) *audit.FileSink {
+	t.Helper()
+	sink, err := audit.OpenFile(path)
+	assertNoErr(t, err)
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxEventSize bounds a line of the audit file, comfortably above the size
+// of an event holding two links with their metadata.
+const maxEventSize = 1 << 20
+
+// ErrEventTooLarge is returned for events that would not fit on a line of
+// the audit file.
+var ErrEventTooLarge = fmt.Errorf("audit event is larger than %d bytes", maxEventSize)
+
+// FileSink appends events to a file as JSON lines. The file is opened for
+// appending only, so recording can never change an earlier event.
+//
+// IDs are counted from the events already in the file when it is opened, so
+// a file must only be written by one process at a time.
+type FileSink struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	lastID int64
+}
+
+// OpenFile opens the audit file at path, creating it if needed.
+func OpenFile(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	sink := &FileSink{path: path, f: f}
+	if err := sink.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// recover finds the last ID in the file and, if a crash cut the last event
+// short, ends its line so the next event starts on a fresh one.
+func (s *FileSink) recover() error {
+	var lastByte byte
+	err := s.scan(func(event Event) {
+		s.lastID = max(s.lastID, event.ID)
+	}, &lastByte)
+	if err != nil {
+		return err
+	}
+	if lastByte != 0 && lastByte != '\n' {
+		if _, err := s.f.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("failed to repair audit log: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Record(ctx context.Context, event Event) (Event, error) {
+	if err := ctx.Err(); err != nil {
+		return event, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = s.lastID + 1
+	b, err := json.Marshal(event)
+	if err != nil {
+		return event, fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	if len(b) >= maxEventSize {
+		return event, ErrEventTooLarge
+	}
+	// one write per event, so a concurrent reader never sees half of one
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return event, fmt.Errorf("failed to write audit event: %w", err)
+	}
+	s.lastID = event.ID
+	return event, nil
+}
+
+// History reads the whole file, which is fine for the occasional lookup it
+// serves but not for anything hotter.
+func (s *FileSink) History(ctx context.Context, code string) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var history []Event
+	err := s.scan(func(event Event) {
+		if event.Code == code {
+			history = append(history, event)
+		}
+	}, nil)
+	return history, err
+}
+
+// scan calls fn with every event in the file, skipping lines that do not
+// decode, such as one cut short by a crash, and lines over maxEventSize,
+// which Record no longer writes. If lastByte is set it receives the final
+// byte of the file.
+func (s *FileSink) scan(fn func(Event), lastByte *byte) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+	reader := &lastByteReader{r: f}
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := buffered.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			tooLong = len(line) > maxEventSize
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if !tooLong && len(line) > 0 {
+			var event Event
+			if json.Unmarshal(line, &event) == nil {
+				fn(event)
+			}
+		}
+		line, tooLong = line[:0], false
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+	if lastByte != nil {
+		*lastByte = reader.last
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+type lastByteReader struct {
+	r    io.Reader
+	last byte
+}
+
+func (l *lastByteReader) Read(b []byte) (int, error) {
+	n, err := l.r.Read(b)
+	if n > 0 {
+		l.last = b[n-1]
+	}
+	return n, err
+}
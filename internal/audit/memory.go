@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// MemorySink keeps events in memory, so they are lost on restart.
+type MemorySink struct {
+	mu     sync.RWMutex
+	events []Event
+	byCode map[string][]int
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{byCode: make(map[string][]int)}
+}
+
+func (m *MemorySink) Record(ctx context.Context, event Event) (Event, error) {
+	if err := ctx.Err(); err != nil {
+		return event, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event.ID = int64(len(m.events)) + 1
+	m.byCode[event.Code] = append(m.byCode[event.Code], len(m.events))
+	m.events = append(m.events, event.clone())
+	return event, nil
+}
+
+func (m *MemorySink) History(ctx context.Context, code string) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var history []Event
+	for _, i := range m.byCode[code] {
+		history = append(history, m.events[i].clone())
+	}
+	return history, nil
+}
@@ -0,0 +1,106 @@
+// Package geoip looks up the country of an IP address in an offline
+// database.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DB maps ranges of addresses to countries. It reads the start,end,country
+// CSV layout of the DB-IP Lite and ip-location-db country files, covering
+// IPv4 and IPv6 in one file or several.
+type DB struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// Open loads the database at path.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer f.Close()
+	db, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GeoIP database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Load reads a database from r. A header row is skipped, and ranges are
+// expected not to overlap.
+func Load(r io.Reader) (*DB, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	db := &DB{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: want start,end,country", line)
+		}
+		start, startErr := netip.ParseAddr(strings.TrimSpace(record[0]))
+		end, endErr := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if line == 1 && startErr != nil {
+			continue
+		}
+		if startErr != nil || endErr != nil {
+			return nil, fmt.Errorf("line %d: %w", line, errors.Join(startErr, endErr))
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: %s to %s is not a range", line, start, end)
+		}
+		db.ranges = append(db.ranges, ipRange{start, end, country(record[2])})
+	}
+	slices.SortFunc(db.ranges, func(a, b ipRange) int { return a.start.Compare(b.start) })
+	return db, nil
+}
+
+// Country returns the upper-case ISO 3166-1 alpha-2 code of the country ip
+// is in, or an empty string if the database does not know.
+func (db *DB) Country(ip netip.Addr) string {
+	ip = ip.Unmap()
+	// the last range starting at or before ip
+	i, found := slices.BinarySearchFunc(db.ranges, ip, func(r ipRange, ip netip.Addr) int { return r.start.Compare(ip) })
+	if !found {
+		i--
+	}
+	if i < 0 || db.ranges[i].end.Less(ip) {
+		return ""
+	}
+	return db.ranges[i].country
+}
+
+// Len returns the number of ranges in the database.
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
+
+// country normalises a country code, dropping the placeholders databases use
+// for unknown or unassigned space.
+func country(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "ZZ" || code == "-" || code == "--" {
+		return ""
+	}
+	return code
+}
@@ -0,0 +1,70 @@
+package geoip_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/geoip"
+)
+
+const database = `start,end,country
+1.0.0.0,1.0.0.255,au
+8.8.8.0,8.8.8.255,US
+2a00:1450::,2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff,IE
+5.0.0.0,5.0.0.255,ZZ
+`
+
+func TestCountry(t *testing.T) {
+	db, err := geoip.Load(strings.NewReader(database))
+	assertNoErr(t, err)
+	if db.Len() != 4 {
+		t.Errorf("got %d ranges, want 4", db.Len())
+	}
+
+	cases := map[string]string{
+		"1.0.0.0":          "AU",
+		"1.0.0.128":        "AU",
+		"8.8.8.8":          "US",
+		"::ffff:8.8.8.255": "US",
+		"2a00:1450::1":     "IE",
+		"1.0.1.0":          "",
+		"0.0.0.1":          "",
+		"9.9.9.9":          "",
+		"5.0.0.1":          "",
+		"2001:db8::1":      "",
+	}
+	for ip, want := range cases {
+		if got := db.Country(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("Country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.csv")
+	assertNoErr(t, os.WriteFile(path, []byte("8.8.8.0,8.8.8.255,US\n"), 0o644))
+	db, err := geoip.Open(path)
+	assertNoErr(t, err)
+	if got := db.Country(netip.MustParseAddr("8.8.8.8")); got != "US" {
+		t.Errorf("got %q, want US", got)
+	}
+
+	for _, invalid := range []string{"8.8.8.0,8.8.8.255\n", "1.1.1.1,1.1.1.1,AU\n8.8.8.0,nope,US\n", "8.8.8.255,8.8.8.0,US\n", "8.8.8.0,::1,US\n"} {
+		if _, err := geoip.Load(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+	if _, err := geoip.Open(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("expected a missing database to fail")
+	}
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusReady   = "ready"
+	StatusUnready = "unready"
+	// reported as the status of the server itself while it drains for shutdown
+	StatusShuttingDown = "shutting down"
+	probeTimeout       = 2 * time.Second
+)
+
+// HealthCheck is the liveness probe: it only reports that the process is serving requests.
+func HealthCheck(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok")
+}
+
+// Check probes a single dependency and returns an error if it is unusable.
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	// Error is why the check failed. It is logged but never served, as
+	// backend errors can name hosts, users and paths.
+	Error string `json:"-"`
+}
+
+type ReadinessReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Readiness serves the readiness probe, running every registered check and
+// reporting unready if any of them fail or the server is shutting down.
+type Readiness struct {
+	mu           sync.Mutex
+	checks       map[string]Check
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{checks: make(map[string]Check), timeout: probeTimeout}
+}
+
+func (rd *Readiness) AddCheck(name string, check Check) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rd.checks[name] = check
+}
+
+// Shutdown flips the probe to unready so load balancers stop routing new traffic.
+func (rd *Readiness) Shutdown() {
+	rd.shuttingDown.Store(true)
+}
+
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := rd.Report(r.Context())
+	for name, component := range report.Components {
+		if component.Error != "" {
+			logging.FromContext(r.Context()).Warn("readiness check failed", "component", name, "error", component.Error)
+		}
+	}
+	w.Header().Set("content-type", JsonContentType)
+	if report.Status != StatusReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func (rd *Readiness) Report(ctx context.Context) ReadinessReport {
+	rd.mu.Lock()
+	names := make([]string, 0, len(rd.checks))
+	for name := range rd.checks {
+		names = append(names, name)
+	}
+	checks := make([]Check, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = rd.checks[name]
+	}
+	rd.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, rd.timeout)
+	defer cancel()
+
+	report := ReadinessReport{Status: StatusReady, Components: make(map[string]ComponentStatus, len(names))}
+	results := make([]ComponentStatus, len(names))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		report.Components[name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusUnready
+		}
+	}
+	if rd.shuttingDown.Load() {
+		report.Status = StatusUnready
+		report.Components["server"] = ComponentStatus{Status: StatusShuttingDown}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, check Check) ComponentStatus {
+	start := time.Now()
+	err := check(ctx)
+	status := ComponentStatus{Status: StatusUp, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// StoreCheck probes store with Ping, which gives up when ctx expires.
+func StoreCheck(store storage.URLStore) Check {
+	return store.Ping
+}
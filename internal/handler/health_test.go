@@ -0,0 +1,113 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+)
+
+func TestReadiness(t *testing.T) {
+	t.Run("ready when every component is up", func(t *testing.T) {
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("storage", handler.StoreCheck(NewFakeStore()))
+
+		response, report := getReadiness(t, readiness)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.JsonContentType)
+		assertReportStatus(t, report.Status, handler.StatusReady)
+		assertReportStatus(t, report.Components["storage"].Status, handler.StatusUp)
+	})
+
+	t.Run("unready when the store cannot be reached", func(t *testing.T) {
+		store := NewFakeStore()
+		store.pingErr = errors.New("database file unreadable")
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("storage", handler.StoreCheck(store))
+
+		response, report := getReadiness(t, readiness)
+		assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+		assertReportStatus(t, report.Status, handler.StatusUnready)
+		assertReportStatus(t, report.Components["storage"].Status, handler.StatusDown)
+	})
+
+	t.Run("keeps the error of a failing check out of the response", func(t *testing.T) {
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("storage", func(ctx context.Context) error {
+			return errors.New(`failed to connect to user=app database=links host=10.0.0.5: password authentication failed`)
+		})
+
+		response := httptest.NewRecorder()
+		readiness.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+		if body := response.Body.String(); strings.Contains(body, "10.0.0.5") || strings.Contains(body, "password") {
+			t.Errorf("the backend error was served: %s", body)
+		}
+		if report := readiness.Report(context.Background()); report.Components["storage"].Error == "" {
+			t.Error("the report should keep the error for logging")
+		}
+	})
+
+	t.Run("reports every component", func(t *testing.T) {
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("storage", handler.StoreCheck(NewFakeStore()))
+		readiness.AddCheck("cache", func(ctx context.Context) error { return errors.New("cache offline") })
+
+		response, report := getReadiness(t, readiness)
+		assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+		assertReportStatus(t, report.Components["storage"].Status, handler.StatusUp)
+		assertReportStatus(t, report.Components["cache"].Status, handler.StatusDown)
+	})
+
+	t.Run("unready when a check exceeds the probe timeout", func(t *testing.T) {
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("slow", func(ctx context.Context) error {
+			select {
+			case <-time.After(time.Minute):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		report := readiness.Report(ctx)
+		assertReportStatus(t, report.Status, handler.StatusUnready)
+		assertErrMessage(t, report.Components["slow"].Error, context.DeadlineExceeded.Error())
+	})
+
+	t.Run("unready once shutting down", func(t *testing.T) {
+		readiness := handler.NewReadiness()
+		readiness.AddCheck("storage", handler.StoreCheck(NewFakeStore()))
+		readiness.Shutdown()
+
+		response, report := getReadiness(t, readiness)
+		assertStatusCode(t, response.Code, http.StatusServiceUnavailable)
+		assertReportStatus(t, report.Status, handler.StatusUnready)
+		assertReportStatus(t, report.Components["server"].Status, handler.StatusShuttingDown)
+	})
+}
+
+func getReadiness(t testing.TB, readiness *handler.Readiness) (*httptest.ResponseRecorder, handler.ReadinessReport) {
+	t.Helper()
+	response := httptest.NewRecorder()
+	readiness.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report handler.ReadinessReport
+	assertNoErr(t, json.NewDecoder(response.Body).Decode(&report))
+	return response, report
+}
+
+func assertReportStatus(t testing.TB, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got status %q, want %q", got, want)
+	}
+}
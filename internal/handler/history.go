@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	HistoryPath    = "/admin/history"
+	ActorHeader    = "X-Actor"
+	rollbackAction = "rollback"
+)
+
+type HistoryResponse struct {
+	Code   string        `json:"code"`
+	Events []audit.Event `json:"events"`
+}
+
+// RollbackRequest names the event whose destination a link goes back to.
+type RollbackRequest struct {
+	EventID int64 `json:"event_id"`
+}
+
+// History serves the audit trail of a link:
+//
+//	GET  /admin/history/<code>          lists the changes made to it, oldest first
+//	POST /admin/history/<code>/rollback points it back at the destination it
+//	                                    had after the event in the body, or
+//	                                    undoes the event if it was a deletion
+type History struct {
+	store   storage.URLStore
+	sink    audit.Sink
+	baseURL string
+}
+
+func NewHistory(store storage.URLStore, sink audit.Sink) *History {
+	return &History{store, sink, DefaultBaseURL}
+}
+
+// WithBaseURL builds short URLs under baseURL, as parsed by ParseBaseURL.
+func (h *History) WithBaseURL(baseURL string) *History {
+	h.baseURL = baseURL
+	return h
+}
+
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "History.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	w.Header().Set("content-type", JsonContentType)
+	shortCode, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, HistoryPath), "/"), "/")
+	if shortCode == "" || (action != "" && action != rollbackAction) {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, "use "+HistoryPath+"/<code> or "+HistoryPath+"/<code>/rollback")
+		errResponse.WriteError(w)
+		return
+	}
+	logging.SetShortCode(ctx, shortCode)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode))
+
+	if action == rollbackAction {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		h.rollback(w, r, shortCode)
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	h.list(w, r, shortCode)
+}
+
+func (h *History) list(w http.ResponseWriter, r *http.Request, shortCode string) {
+	events, ok := h.history(w, r, shortCode)
+	if !ok {
+		return
+	}
+	if len(events) == 0 {
+		// links made before auditing was enabled have no history
+		if _, ok := lookupLink(w, r, h.store, shortCode); !ok {
+			return
+		}
+		events = []audit.Event{}
+	}
+	json.NewEncoder(w).Encode(HistoryResponse{Code: shortCode, Events: events})
+}
+
+func (h *History) rollback(w http.ResponseWriter, r *http.Request, shortCode string) {
+	var req RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.EventID <= 0 {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_JSON, ERR_INVALID_JSON_CODE, `expected {"event_id": <id>}`)
+		errResponse.WriteError(w)
+		return
+	}
+	events, ok := h.history(w, r, shortCode)
+	if !ok {
+		return
+	}
+	var target *link.Link
+	for _, event := range events {
+		if event.ID == req.EventID {
+			target = rollbackTarget(event)
+			break
+		}
+	}
+	if target == nil {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_EVENT_NOT_FOUND, ERR_EVENT_NOT_FOUND_CODE, fmt.Sprintf("%s has no event %d", shortCode, req.EventID))
+		errResponse.WriteError(w)
+		return
+	}
+
+	current, ok := lookupLink(w, r, h.store, shortCode)
+	if !ok {
+		return
+	}
+	restore := current.Deleted() && !target.Deleted()
+	if current.URL == target.URL && !restore {
+		json.NewEncoder(w).Encode(newLinkResponse(h.baseURL, current))
+		return
+	}
+	after := current
+	after.URL, after.UpdatedAt = target.URL, time.Now()
+	action := audit.Update
+	if restore {
+		after.DeletedAt, action = time.Time{}, audit.Restore
+	}
+	if err := replaceDestination(r, h.store, after); err != nil {
+		logging.FromContext(r.Context()).Error("failed to roll back link", "event_id", req.EventID, "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_STORE_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	recordChange(r, h.sink, action, &current, &after)
+	logging.FromContext(r.Context()).Info("link rolled back", "event_id", req.EventID)
+	json.NewEncoder(w).Encode(newLinkResponse(h.baseURL, after))
+}
+
+// rollbackTarget is the link event leaves behind. Rolling back to a deletion
+// brings back the link as it was just before it was deleted.
+func rollbackTarget(event audit.Event) *link.Link {
+	if event.Action == audit.Delete || event.After == nil {
+		return event.Before
+	}
+	return event.After
+}
+
+func (h *History) history(w http.ResponseWriter, r *http.Request, shortCode string) ([]audit.Event, bool) {
+	events, err := h.sink.History(r.Context(), shortCode)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to read link history", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_AUDIT_FAILURE, ERR_AUDIT_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return nil, false
+	}
+	return events, true
+}
+
+// replaceDestination points after's code at after.URL in place, so the code
+// is never free for another link to claim and keeps its clicks and expiry.
+func replaceDestination(r *http.Request, store storage.URLStore, after link.Link) error {
+	if err := store.UpdateURL(r.Context(), after.Code, after.URL); err != nil {
+		return err
+	}
+	return store.SaveInfo(r.Context(), after.Code, after.Info)
+}
+
+// recordChange records a change the request made in sink. The change has
+// already been made, so failing to record it is logged rather than failing
+// the request.
+func recordChange(r *http.Request, sink audit.Sink, action audit.Action, before, after *link.Link) {
+	ctx := r.Context()
+	if actor := audit.FromContext(ctx); actor.IP == "" {
+		actor.IP = clientIP(r)
+		ctx = audit.WithActor(ctx, actor)
+	}
+	event := audit.NewEvent(ctx, action, before, after)
+	if _, err := sink.Record(ctx, event); err != nil {
+		metrics.AuditFailures.Inc()
+		logging.FromContext(ctx).Error("failed to record link change", "action", action, "short_code", event.Code, "error", err)
+	}
+}
+
+// clientIP is the address the request came from, as worked out by
+// TrustProxies, or the address of the connection when it did not run.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lookupLink reads the link at shortCode, answering 404 or 500 itself when
+// it cannot.
+func lookupLink(w http.ResponseWriter, r *http.Request, store storage.URLStore, shortCode string) (link.Link, bool) {
+	originalURL, err := store.GetOriginalURL(r.Context(), shortCode)
+	var info link.Info
+	if err == nil {
+		info, err = store.GetInfo(r.Context(), shortCode)
+	}
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+		errResponse.WriteError(w)
+		return link.Link{}, false
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to look up link", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return link.Link{}, false
+	}
+	return link.New(shortCode, originalURL, info), true
+}
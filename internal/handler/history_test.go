@@ -0,0 +1,223 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+func TestHistory(t *testing.T) {
+	// newLink creates abc123 through the shortener and points it somewhere
+	// else through an import, leaving two events behind
+	newLink := func(t *testing.T) (*FakeStore, *audit.MemorySink) {
+		t.Helper()
+		store, sink := NewFakeStore(), audit.NewMemorySink()
+		request := newShortenRequest(`{"url": "https://example.com", "creator": "alice"}`)
+		request.RemoteAddr = "203.0.113.7:51234"
+		handler.NewShortener(store, NewStubGeneratorWithFixedResponse("abc123", 0)).WithAudit(sink).ServeHTTP(httptest.NewRecorder(), request)
+
+		request = httptest.NewRequest(http.MethodPost, handler.ImportPath+"?format=ndjson&conflict=overwrite",
+			strings.NewReader(`{"code":"abc123","url":"https://example.org"}`))
+		request.Header.Set("Authorization", "Bearer secret")
+		request.Header.Set(handler.ActorHeader, "bob")
+		request.Header.Set("X-Forwarded-For", "198.51.100.1, 10.0.0.1")
+		response := httptest.NewRecorder()
+		trusted, err := handler.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+		assertNoErr(t, err)
+		handler.TrustProxies(trusted, handler.RequireToken("secret", handler.NewImport(store).WithAudit(sink))).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		return store, sink
+	}
+
+	t.Run("GET /admin/history/abc123 lists the changes", func(t *testing.T) {
+		store, sink := newLink(t)
+		response := httptest.NewRecorder()
+
+		handler.NewHistory(store, sink).ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.HistoryPath+"/abc123", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got := decodeHistory(t, response)
+		if len(got.Events) != 2 {
+			t.Fatalf("got %d events, want 2", len(got.Events))
+		}
+		created, updated := got.Events[0], got.Events[1]
+		if created.Action != audit.Create || created.Actor != audit.Anonymous || created.Claimed != "alice" || created.SourceIP != "203.0.113.7" || created.Before != nil {
+			t.Errorf("got create event %+v", created)
+		}
+		if created.After.URL != "https://example.com" {
+			t.Errorf("got created link %+v", created.After)
+		}
+		if updated.Action != audit.Update || updated.Actor != handler.TokenActor("secret") || updated.Claimed != "bob" || updated.SourceIP != "198.51.100.1" {
+			t.Errorf("got update event %+v", updated)
+		}
+		if updated.Before.URL != "https://example.com" || updated.After.URL != "https://example.org" {
+			t.Errorf("got update from %q to %q", updated.Before.URL, updated.After.URL)
+		}
+	})
+
+	t.Run("trashing and restoring is recorded", func(t *testing.T) {
+		store, sink := newLink(t)
+		trash := handler.NewTrash(store, 0).WithAudit(sink)
+		trash.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, handler.TrashPath+"/abc123", nil))
+		trash.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, handler.TrashPath+"/abc123/restore", nil))
+
+		events, _ := sink.History(context.Background(), "abc123")
+		if len(events) != 4 || events[2].Action != audit.Delete || events[3].Action != audit.Restore {
+			t.Fatalf("got events %+v", events)
+		}
+		if events[2].Before.Deleted() || !events[2].After.Deleted() || events[3].After.Deleted() {
+			t.Errorf("got delete %+v and restore %+v", events[2], events[3])
+		}
+	})
+
+	t.Run("POST /admin/history/abc123/rollback restores an earlier destination", func(t *testing.T) {
+		store, sink := newLink(t)
+		events, _ := sink.History(context.Background(), "abc123")
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodPost, handler.HistoryPath+"/abc123/rollback", strings.NewReader(`{"event_id": 1}`))
+		handler.NewHistory(store, sink).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		url, _ := store.GetOriginalURL(context.Background(), "abc123")
+		if url != events[0].After.URL {
+			t.Errorf("got destination %q, want %q", url, events[0].After.URL)
+		}
+		info, _ := store.GetInfo(context.Background(), "abc123")
+		if info.Title != "" || info.UpdatedAt.IsZero() {
+			t.Errorf("got info %+v", info)
+		}
+		after, _ := sink.History(context.Background(), "abc123")
+		if len(after) != 3 || after[2].Action != audit.Update || after[2].After.URL != "https://example.com" {
+			t.Errorf("got events %+v", after)
+		}
+	})
+
+	t.Run("rolling back to a deletion brings the link back", func(t *testing.T) {
+		store, sink := newLink(t)
+		handler.NewTrash(store, 0).WithAudit(sink).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, handler.TrashPath+"/abc123", nil))
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodPost, handler.HistoryPath+"/abc123/rollback", strings.NewReader(`{"event_id": 3}`))
+		handler.NewHistory(store, sink).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		if info, _ := store.GetInfo(context.Background(), "abc123"); !info.DeletedAt.IsZero() {
+			t.Errorf("got deleted at %v, want the link live", info.DeletedAt)
+		}
+		events, _ := sink.History(context.Background(), "abc123")
+		if len(events) != 4 || events[3].Action != audit.Restore || events[3].After.URL != "https://example.org" {
+			t.Errorf("got events %+v", events)
+		}
+	})
+
+	t.Run("rolling back to a deletion without an after state restores the link before it", func(t *testing.T) {
+		store, sink := newLink(t)
+		before, _ := sink.History(context.Background(), "abc123")
+		deleted, err := sink.Record(context.Background(), audit.NewEvent(context.Background(), audit.Delete, before[0].After, nil))
+		assertNoErr(t, err)
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodPost, handler.HistoryPath+"/abc123/rollback", strings.NewReader(fmt.Sprintf(`{"event_id": %d}`, deleted.ID)))
+		handler.NewHistory(store, sink).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if url, _ := store.GetOriginalURL(context.Background(), "abc123"); url != "https://example.com" {
+			t.Errorf("got destination %q", url)
+		}
+	})
+
+	t.Run("rolling back never frees the short code", func(t *testing.T) {
+		store, sink := newLink(t)
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodPost, handler.HistoryPath+"/abc123/rollback", strings.NewReader(`{"event_id": 1}`))
+		handler.NewHistory(inPlaceStore{store, t}, sink).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if url, _ := store.GetOriginalURL(context.Background(), "abc123"); url != "https://example.com" {
+			t.Errorf("got destination %q", url)
+		}
+	})
+
+	t.Run("rolling back to the current destination changes nothing", func(t *testing.T) {
+		store, sink := newLink(t)
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodPost, handler.HistoryPath+"/abc123/rollback", strings.NewReader(`{"event_id": 2}`))
+		handler.NewHistory(store, sink).ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if events, _ := sink.History(context.Background(), "abc123"); len(events) != 2 {
+			t.Errorf("got %d events, want 2", len(events))
+		}
+	})
+
+	t.Run("GET /admin/history of a link without history is empty", func(t *testing.T) {
+		store := &FakeStore{urls: map[string]string{"abc123": "https://example.com"}, info: map[string]link.Info{"abc123": {}}}
+		response := httptest.NewRecorder()
+
+		handler.NewHistory(store, audit.NewMemorySink()).ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.HistoryPath+"/abc123", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertBodyContains(t, response.Body.String(), `"events":[]`)
+	})
+
+	errorCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"history of an unknown code is not found", http.MethodGet, "/nope", "", http.StatusNotFound, handler.ERR_SHORT_CODE_NOT_FOUND},
+		{"rollback to an unknown event is not found", http.MethodPost, "/abc123/rollback", `{"event_id": 99}`, http.StatusNotFound, handler.ERR_EVENT_NOT_FOUND},
+		{"rollback to an event of another link is not found", http.MethodPost, "/def456/rollback", `{"event_id": 1}`, http.StatusNotFound, handler.ERR_EVENT_NOT_FOUND},
+		{"rollback without an event is rejected", http.MethodPost, "/abc123/rollback", `{}`, http.StatusBadRequest, handler.ERR_INVALID_JSON},
+		{"GET rollback is not allowed", http.MethodGet, "/abc123/rollback", "", http.StatusMethodNotAllowed, handler.ERR_METHOD_NOT_ALLOWED},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			store, sink := newLink(t)
+			response := httptest.NewRecorder()
+
+			handler.NewHistory(store, sink).ServeHTTP(response, httptest.NewRequest(tt.method, handler.HistoryPath+tt.path, strings.NewReader(tt.body)))
+			assertStatusCode(t, response.Code, tt.wantStatus)
+
+			got, err := getErrorResponse(response.Body)
+			assertNoErr(t, err)
+			assertErrMessage(t, got.Error, tt.wantError)
+		})
+	}
+}
+
+func decodeHistory(t testing.TB, response *httptest.ResponseRecorder) handler.HistoryResponse {
+	t.Helper()
+	var got handler.HistoryResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return got
+}
+
+// inPlaceStore fails the test if a link is deleted or saved again, as a
+// change that must be made in place would.
+type inPlaceStore struct {
+	*FakeStore
+	t *testing.T
+}
+
+func (s inPlaceStore) Delete(ctx context.Context, shortCode string) error {
+	s.t.Errorf("deleted %s", shortCode)
+	return s.FakeStore.Delete(ctx, shortCode)
+}
+
+func (s inPlaceStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	s.t.Errorf("saved %s again", shortCode)
+	return s.FakeStore.Save(ctx, shortCode, originalUrl)
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	LinksPath     = "/links"
+	TagQueryParam = "tag"
+)
+
+// LinkResponse describes a link to API clients. The destinations of password
+// protected links and links with a click limit are withheld, as they are on
+// the preview page.
+type LinkResponse struct {
+	Code        string            `json:"code"`
+	Short       string            `json:"short"`
+	URL         string            `json:"url,omitempty"`
+	QR          string            `json:"qr"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags"`
+	Creator     string            `json:"creator,omitempty"`
+	CreatedAt   time.Time         `json:"created_at,omitzero"`
+	UpdatedAt   time.Time         `json:"updated_at,omitzero"`
+	Clicks      int               `json:"clicks"`
+	Suspicious  bool              `json:"suspicious"`
+	Protected   bool              `json:"protected"`
+	Metadata    map[string]string `json:"metadata"`
+	DeletedAt   time.Time         `json:"deleted_at,omitzero"`
+	Query       map[string]string `json:"query,omitempty"`
+	QueryPolicy link.QueryPolicy  `json:"query_policy,omitempty"`
+	UTM         map[string]string `json:"utm,omitempty"`
+	ForwardPath bool              `json:"forward_path,omitempty"`
+	Rules       []link.Rule       `json:"rules,omitempty"`
+	// Variants carry the clicks each has been served
+	Variants       []link.Variant `json:"variants,omitempty"`
+	StickyVariants bool           `json:"sticky_variants,omitempty"`
+	MaxClicks      int            `json:"max_clicks,omitempty"`
+	// Exhausted is set once a link has served all the clicks it allows
+	Exhausted bool `json:"exhausted,omitempty"`
+	// RestorableUntil is when a trashed link can no longer be restored
+	RestorableUntil time.Time `json:"restorable_until,omitzero"`
+}
+
+type LinkListResponse struct {
+	Links []LinkResponse `json:"links"`
+}
+
+// Links serves GET /links, optionally filtered with ?tag=, and the details
+// of a single link at GET /links/<code>. Listing hands out every code, so
+// LinksPath is expected to be mounted behind RequireToken while the details
+// of a code the caller already knows can stay public.
+type Links struct {
+	store   storage.URLStore
+	baseURL string
+}
+
+func NewLinks(store storage.URLStore) *Links {
+	return &Links{store, DefaultBaseURL}
+}
+
+// WithBaseURL builds short URLs under baseURL, as parsed by ParseBaseURL.
+func (l *Links) WithBaseURL(baseURL string) *Links {
+	l.baseURL = baseURL
+	return l
+}
+
+func (l *Links) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "Links.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	w.Header().Set("content-type", JsonContentType)
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	if r.URL.Path == LinksPath {
+		l.list(w, r)
+		return
+	}
+	shortCode := strings.Trim(strings.TrimPrefix(r.URL.Path, LinksPath+"/"), "/")
+	if shortCode == "" {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+		errResponse.WriteError(w)
+		return
+	}
+	logging.SetShortCode(ctx, shortCode)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode))
+	l.details(w, r, shortCode)
+}
+
+func (l *Links) list(w http.ResponseWriter, r *http.Request) {
+	links, err := l.store.List(r.Context(), storage.ListFilter{Tag: r.URL.Query().Get(TagQueryParam), Trash: storage.ExcludeTrash})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list links", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	response := LinkListResponse{Links: make([]LinkResponse, len(links))}
+	for i, lk := range links {
+		response.Links[i] = newLinkResponse(l.baseURL, lk)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (l *Links) details(w http.ResponseWriter, r *http.Request, shortCode string) {
+	originalURL, err := l.store.GetOriginalURL(r.Context(), shortCode)
+	var info link.Info
+	if err == nil {
+		info, err = l.store.GetInfo(r.Context(), shortCode)
+	}
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+		errResponse.WriteError(w)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to look up link", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	if info.Deleted() {
+		linkDeleted(w)
+		return
+	}
+	json.NewEncoder(w).Encode(newLinkResponse(l.baseURL, link.New(shortCode, originalURL, info)))
+}
+
+func newLinkResponse(baseURL string, l link.Link) LinkResponse {
+	response := LinkResponse{
+		Code:           l.Code,
+		Short:          shortURL(baseURL, l.Code),
+		URL:            l.URL,
+		QR:             QRCodeURL(l.Code),
+		Title:          l.Title,
+		Description:    l.Description,
+		Tags:           l.Tags,
+		Creator:        l.Creator,
+		CreatedAt:      l.CreatedAt,
+		UpdatedAt:      l.UpdatedAt,
+		Clicks:         l.Clicks,
+		Suspicious:     l.Suspicious,
+		Protected:      l.PasswordHash != "",
+		Metadata:       l.Metadata,
+		DeletedAt:      l.DeletedAt,
+		Query:          l.Query,
+		QueryPolicy:    l.QueryPolicy,
+		UTM:            l.UTM,
+		ForwardPath:    l.ForwardPath,
+		Rules:          l.Rules,
+		Variants:       l.Variants,
+		StickyVariants: l.StickyVariants,
+		MaxClicks:      l.MaxClicks,
+		Exhausted:      l.Exhausted(),
+	}
+	if withholdsDestination(l.Info) {
+		// rules and variants would give the destinations away too
+		response.URL = ""
+		response.Rules, response.Variants = slices.Clone(response.Rules), slices.Clone(response.Variants)
+		for i := range response.Rules {
+			response.Rules[i].URL = ""
+		}
+		for i := range response.Variants {
+			response.Variants[i].URL = ""
+		}
+	}
+	// always render as [] and {} so clients need not check for null
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	if response.Metadata == nil {
+		response.Metadata = map[string]string{}
+	}
+	return response
+}
@@ -0,0 +1,189 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+func TestLinks(t *testing.T) {
+	newStore := func() *FakeStore {
+		return &FakeStore{
+			urls: map[string]string{"abc123": "https://example.com", "def456": "https://example.org", "xyz789": "https://secret.example"},
+			info: map[string]link.Info{
+				"abc123": {Title: "Example", Tags: []string{"docs"}, Creator: "alice", Metadata: map[string]string{"campaign": "spring"}},
+				"def456": {Tags: []string{"docs", "news"}},
+				"xyz789": {
+					PasswordHash: "pbkdf2$hash",
+					Rules:        []link.Rule{{URL: "https://secret.example/ios", Devices: []string{link.DeviceIOS}}},
+					Variants: []link.Variant{
+						{Name: "a", URL: "https://secret.example/a", Weight: 1, Clicks: 2},
+						{Name: "b", URL: "https://secret.example/b", Weight: 1},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("GET /links lists every link", func(t *testing.T) {
+		server := handler.NewLinks(newStore()).WithBaseURL("https://sho.rt")
+		response := httptest.NewRecorder()
+
+		request := httptest.NewRequest(http.MethodGet, "/links", nil)
+		request.Header.Set("X-Forwarded-Proto", "javascript")
+		server.ServeHTTP(response, request)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.JsonContentType)
+
+		got := decodeLinkList(t, response)
+		assertLinkCodes(t, got, "abc123", "def456", "xyz789")
+		if got[0].Short != "https://sho.rt/abc123" || got[0].QR != handler.QRCodeURL("abc123") {
+			t.Errorf("got short %q and qr %q", got[0].Short, got[0].QR)
+		}
+	})
+
+	t.Run("GET /links?tag=news filters by tag", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links?tag=News", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertLinkCodes(t, decodeLinkList(t, response), "def456")
+	})
+
+	t.Run("GET /links with no links returns an empty list", func(t *testing.T) {
+		server := handler.NewLinks(NewFakeStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertBodyContains(t, response.Body.String(), `{"links":[]}`)
+	})
+
+	t.Run("GET /links/ does not list the links", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links/", nil))
+		assertStatusCode(t, response.Code, http.StatusNotFound)
+	})
+
+	t.Run("GET /links/abc123 returns the link details", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links/abc123", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		var got handler.LinkResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+		if got.Code != "abc123" || got.URL != "https://example.com" || got.Title != "Example" || got.Creator != "alice" {
+			t.Errorf("got %+v", got)
+		}
+		if len(got.Tags) != 1 || got.Tags[0] != "docs" || got.Metadata["campaign"] != "spring" {
+			t.Errorf("got tags %q and metadata %v", got.Tags, got.Metadata)
+		}
+	})
+
+	t.Run("GET /links/xyz789 withholds the destination of a protected link", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links/xyz789", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		var got handler.LinkResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		if !got.Protected || got.URL != "" {
+			t.Errorf("got protected %v and url %q", got.Protected, got.URL)
+		}
+		if len(got.Rules) != 1 || got.Rules[0].URL != "" || len(got.Variants) != 2 || got.Variants[0].URL != "" {
+			t.Fatalf("got rules %+v and variants %+v", got.Rules, got.Variants)
+		}
+		if got.Variants[0].Clicks != 2 {
+			t.Errorf("got %d clicks on variant a, want 2", got.Variants[0].Clicks)
+		}
+	})
+
+	t.Run("GET /links/abc123 withholds the destination of a one-time link", func(t *testing.T) {
+		store := &FakeStore{
+			urls: map[string]string{"abc123": "https://invite.example"},
+			info: map[string]link.Info{"abc123": {
+				MaxClicks: 1,
+				Rules:     []link.Rule{{URL: "https://invite.example/ios", Devices: []string{link.DeviceIOS}}},
+			}},
+		}
+		server := handler.NewLinks(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links/abc123", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		var got handler.LinkResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		if got.URL != "" || len(got.Rules) != 1 || got.Rules[0].URL != "" {
+			t.Errorf("got url %q and rules %+v", got.URL, got.Rules)
+		}
+	})
+
+	t.Run("GET /links/unknown is not found", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links/unknown", nil))
+		assertStatusCode(t, response.Code, http.StatusNotFound)
+
+		got, err := getErrorResponse(response.Body)
+		assertNoErr(t, err)
+		assertErrMessage(t, got.Error, handler.ERR_SHORT_CODE_NOT_FOUND)
+	})
+
+	t.Run("GET /links reports a failing store", func(t *testing.T) {
+		store := newStore()
+		store.err = errors.New("connection refused")
+		server := handler.NewLinks(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links", nil))
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+	})
+
+	t.Run("POST /links is not allowed", func(t *testing.T) {
+		server := handler.NewLinks(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/links", nil))
+		assertStatusCode(t, response.Code, http.StatusMethodNotAllowed)
+		if got := response.Header().Get("Allow"); got != http.MethodGet {
+			t.Errorf("got Allow %q, want %q", got, http.MethodGet)
+		}
+	})
+}
+
+func decodeLinkList(t testing.TB, response *httptest.ResponseRecorder) []handler.LinkResponse {
+	t.Helper()
+	var got handler.LinkListResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return got.Links
+}
+
+func assertLinkCodes(t testing.TB, links []handler.LinkResponse, want ...string) {
+	t.Helper()
+	if len(links) != len(want) {
+		t.Fatalf("got %d links, want %d", len(links), len(want))
+	}
+	for i, code := range want {
+		if links[i].Code != code {
+			t.Errorf("link %d: got %q, want %q", i, links[i].Code, code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	maxPasswordAttempts = 5
+	passwordLockout     = 15 * time.Minute
+)
+
+// lockout tracks failed password attempts per short code and locks a code
+// once too many guesses have been made against it.
+type lockout struct {
+	mu          sync.Mutex
+	maxAttempts int
+	duration    time.Duration
+	now         func() time.Time
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+}
+
+func newLockout(maxAttempts int, duration time.Duration) *lockout {
+	return &lockout{
+		maxAttempts: maxAttempts,
+		duration:    duration,
+		now:         time.Now,
+		failures:    make(map[string]int),
+		lockedUntil: make(map[string]time.Time),
+	}
+}
+
+func (l *lockout) Locked(shortCode string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, locked := l.lockedUntil[shortCode]
+	if !locked {
+		return false
+	}
+	if l.now().After(until) {
+		delete(l.lockedUntil, shortCode)
+		return false
+	}
+	return true
+}
+
+func (l *lockout) Fail(shortCode string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures[shortCode]++
+	if l.failures[shortCode] >= l.maxAttempts {
+		l.lockedUntil[shortCode] = l.now().Add(l.duration)
+		delete(l.failures, shortCode)
+	}
+}
+
+func (l *lockout) Reset(shortCode string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, shortCode)
+}
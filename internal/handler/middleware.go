@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+)
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// WithMetrics records the latency of every request served by next under the given handler name.
+func WithMetrics(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), name, r.Method, strconv.Itoa(rec.Status()))
+	})
+}
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	maxRequestIDLen = 128
+)
+
+// AccessLog assigns each request an ID, exposes a request-scoped logger to the
+// handlers via logging.FromContext and writes one record per request.
+// Only sampleRate (0 to 1) of successful requests are logged so high-volume
+// redirect traffic doesn't flood the log; failed requests are always logged.
+func AccessLog(logger *slog.Logger, sampleRate float64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = logging.NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := logging.NewContext(r.Context(), logger, requestID)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		if status < http.StatusBadRequest && rand.Float64() >= sampleRate {
+			return
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+			slog.String("short_code", logging.ShortCode(ctx)),
+		}
+		if variant := logging.Variant(ctx); variant != "" {
+			attrs = append(attrs, slog.String("variant", variant))
+		}
+		logging.FromContext(ctx).LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithTracing wraps every request served by next in a server span, joining the
+// caller's trace when a W3C traceparent header is present.
+func WithTracing(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.StartServer(r, name)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		tracing.EndServer(span, rec.Status())
+	})
+}
+
+type clientIPKey struct{}
+
+// TrustProxies works out the address each request comes from for audit
+// events and routing rules. X-Forwarded-For is only followed back through
+// hops made by trusted proxies, so a client cannot claim an address by
+// sending the header itself. With no trusted proxies it is ignored.
+func TrustProxies(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, forwardedFor(r, trusted))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedFor walks X-Forwarded-For from the right, where the nearest proxy
+// appended the address it saw, for as long as the hops are trusted.
+func forwardedFor(r *http.Request, trusted []netip.Prefix) string {
+	ip := remoteIP(r)
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		ip = addr.Unmap().String()
+	}
+	return ip
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// ParseTrustedProxies reads a comma separated list of proxy addresses and
+// CIDR ranges, like "10.0.0.0/8, 192.0.2.1".
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var trusted []netip.Prefix
+	for entry := range strings.SplitSeq(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR range", entry)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// RequireToken only lets requests carrying "Authorization: Bearer <token>"
+// through to next, answering everything else with 401. Changes made through
+// it are audited under TokenActor(token). The name the caller gives in the
+// X-Actor header cannot be checked, so it is only kept as the claimed actor.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("content-type", JsonContentType)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			errResponse := NewErrorResponse(http.StatusUnauthorized, ERR_UNAUTHORIZED, ERR_UNAUTHORIZED_CODE, "a valid bearer token is required")
+			errResponse.WriteError(w)
+			return
+		}
+		actor := audit.Actor{Name: TokenActor(token), Claimed: r.Header.Get(ActorHeader), IP: clientIP(r)}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
+
+// TokenActor names the holder of token in the audit trail by a fingerprint
+// of the token, so events can be told apart by token without recording it.
+func TokenActor(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,266 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithMetrics(t *testing.T) {
+	store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}}
+	server := handler.WithMetrics("test-redirector", handler.NewRedirector(&store))
+	redirects := metrics.RedirectsServed.Value()
+	notFound := metrics.RedirectsNotFound.Value()
+
+	server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+	server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("xyz123"))
+
+	if got := metrics.RequestDuration.Count("test-redirector", http.MethodGet, "302"); got != 1 {
+		t.Errorf("got %d observations for 302, want %d", got, 1)
+	}
+	if got := metrics.RequestDuration.Count("test-redirector", http.MethodGet, "404"); got != 1 {
+		t.Errorf("got %d observations for 404, want %d", got, 1)
+	}
+	if got := metrics.RedirectsServed.Value() - redirects; got != 1 {
+		t.Errorf("got %v redirects, want %d", got, 1)
+	}
+	if got := metrics.RedirectsNotFound.Value() - notFound; got != 1 {
+		t.Errorf("got %v not found, want %d", got, 1)
+	}
+}
+
+func TestShortenerMetrics(t *testing.T) {
+	store := NewFakeStore()
+	store.urls["xyz123"] = "https://test.com"
+	gen := NewStubGeneratorWithFixedResponse("xyz123", 10)
+	gen.RepeatResponse = "xyz123"
+	server := handler.NewShortener(store, gen)
+	collisions := metrics.GeneratorCollisions.Value()
+	exceeded := metrics.RetryAttemptsExceeded.Value()
+
+	server.ServeHTTP(httptest.NewRecorder(), newShortenRequest(`{"url": "https://example.com"}`))
+
+	if got := metrics.GeneratorCollisions.Value() - collisions; got != 4 {
+		t.Errorf("got %v collisions, want %d", got, 4)
+	}
+	if got := metrics.RetryAttemptsExceeded.Value() - exceeded; got != 1 {
+		t.Errorf("got %v exhausted retries, want %d", got, 1)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}}
+	redirector := handler.NewRedirector(&store)
+
+	t.Run("logs one JSON record per request", func(t *testing.T) {
+		var buf bytes.Buffer
+		server := handler.AccessLog(logging.New(&buf, slog.LevelInfo), 1, redirector)
+		req := newRedirectRequest("abc123")
+		req.Header.Set(handler.RequestIDHeader, "req-1")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, req)
+
+		if got := response.Header().Get(handler.RequestIDHeader); got != "req-1" {
+			t.Errorf("got request id header %q, want %q", got, "req-1")
+		}
+		var got map[string]any
+		assertNoErr(t, json.Unmarshal(buf.Bytes(), &got))
+		want := map[string]any{
+			"msg":        "request",
+			"method":     "GET",
+			"path":       "/abc123",
+			"status":     float64(http.StatusFound),
+			"short_code": "abc123",
+			"request_id": "req-1",
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("got %s %v, want %v", key, got[key], value)
+			}
+		}
+		for _, key := range []string{"latency_ms", "bytes"} {
+			if _, ok := got[key]; !ok {
+				t.Errorf("record is missing %q", key)
+			}
+		}
+	})
+
+	t.Run("generates a request id when none is given", func(t *testing.T) {
+		var buf bytes.Buffer
+		server := handler.AccessLog(logging.New(&buf, slog.LevelInfo), 1, redirector)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+
+		if response.Header().Get(handler.RequestIDHeader) == "" {
+			t.Error("expected a generated request id")
+		}
+	})
+
+	t.Run("sampling drops successful requests but keeps failures", func(t *testing.T) {
+		var buf bytes.Buffer
+		server := handler.AccessLog(logging.New(&buf, slog.LevelInfo), 0, redirector)
+
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+		if buf.Len() != 0 {
+			t.Errorf("successful request should be sampled out, got %q", buf.String())
+		}
+
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("xyz123"))
+		if !strings.Contains(buf.String(), `"status":404`) {
+			t.Errorf("failed request should always be logged, got %q", buf.String())
+		}
+	})
+
+	t.Run("records the variant a split link served", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {Variants: []link.Variant{
+				{Name: "control", URL: "https://example.com/a", Weight: 1},
+				{Name: "new", URL: "https://example.com/b"},
+			}}},
+		}
+		var buf bytes.Buffer
+		server := handler.AccessLog(logging.New(&buf, slog.LevelInfo), 1, handler.NewRedirector(&store))
+
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+		assertBodyContains(t, buf.String(), `"variant":"control"`)
+	})
+
+	t.Run("respects the configured level", func(t *testing.T) {
+		var buf bytes.Buffer
+		server := handler.AccessLog(logging.New(&buf, slog.LevelWarn), 1, redirector)
+
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+		if buf.Len() != 0 {
+			t.Errorf("info records should be filtered, got %q", buf.String())
+		}
+	})
+}
+
+func TestWithTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	_, err := tracing.Setup(context.Background(), "")
+	assertNoErr(t, err)
+
+	store := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
+	server := handler.WithTracing("redirector", handler.NewRedirector(tracing.TraceStore(store, "memory")))
+	req := newRedirectRequest("abc123")
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	names := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		names[span.Name] = true
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Errorf("span %q: got trace id %s, want %s", span.Name, got, traceID)
+		}
+	}
+	for _, want := range []string{"GET redirector", "Redirector.ServeHTTP", "storage.GetOriginalURL", "storage.IncrementClicks"} {
+		if !names[want] {
+			t.Errorf("missing span %q, got %v", want, names)
+		}
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server := handler.RequireToken("s3cret", next)
+
+	cases := map[string]struct {
+		header string
+		want   int
+	}{
+		"valid token":     {"Bearer s3cret", http.StatusNoContent},
+		"missing header":  {"", http.StatusUnauthorized},
+		"wrong token":     {"Bearer guess", http.StatusUnauthorized},
+		"wrong scheme":    {"Basic s3cret", http.StatusUnauthorized},
+		"token prefix":    {"Bearer s3cre", http.StatusUnauthorized},
+		"bare token only": {"s3cret", http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, handler.ExportPath, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, req)
+			assertStatusCode(t, response.Code, tc.want)
+			if tc.want == http.StatusUnauthorized {
+				got, err := getErrorResponse(response.Body)
+				assertNoErr(t, err)
+				assertErrMessage(t, got.Error, handler.ERR_UNAUTHORIZED)
+			}
+		})
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	var got string
+	next := handler.RequireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = audit.FromContext(r.Context()).IP
+	}))
+	trusted, err := handler.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	assertNoErr(t, err)
+
+	cases := map[string]struct {
+		trusted    []netip.Prefix
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		"no proxies trusted":         {nil, "192.0.2.1:1234", "198.51.100.1", "192.0.2.1"},
+		"untrusted peer":             {trusted, "203.0.113.9:1234", "198.51.100.1", "203.0.113.9"},
+		"trusted proxy":              {trusted, "192.0.2.1:1234", "198.51.100.1", "198.51.100.1"},
+		"client prepends a spoof":    {trusted, "192.0.2.1:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		"chain of trusted proxies":   {trusted, "192.0.2.1:1234", "198.51.100.1, 10.0.0.5", "198.51.100.1"},
+		"garbage from the client":    {trusted, "192.0.2.1:1234", "not-an-ip", "192.0.2.1"},
+		"no header from trusted one": {trusted, "192.0.2.1:1234", "", "192.0.2.1"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, handler.ExportPath, nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("Authorization", "Bearer s3cret")
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+
+			handler.TrustProxies(tc.trusted, next).ServeHTTP(httptest.NewRecorder(), req)
+			if got != tc.want {
+				t.Errorf("got client ip %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := handler.ParseTrustedProxies("10.0.0.0/8, proxy.internal"); err == nil {
+		t.Error("expected a host name to be rejected")
+	}
+}
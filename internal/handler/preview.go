@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+const HtmlContentType = "text/html; charset=utf-8"
+
+type previewPage struct {
+	ShortCode   string
+	Destination string
+	// Continue is the link the warning page offers past the warning
+	Continue string
+	Error    string
+	link.Info
+}
+
+// Withheld reports whether the page keeps the destination from the visitor.
+func (p previewPage) Withheld() bool {
+	return withholdsDestination(p.Info)
+}
+
+// withholdsDestination reports whether the destinations of a link are kept
+// from anyone who has not followed it: those of password protected links, and
+// of links with a click limit, whose destination could otherwise be read
+// without using up a click.
+func withholdsDestination(info link.Info) bool {
+	return info.PasswordHash != "" || info.MaxClicks > 0
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Link preview</title></head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</h1>
+{{if .Withheld}}<p>The destination of this short link is only shown by following it.</p>
+{{else}}<p>This short link points to:</p>
+<p><a href="{{.Destination}}" rel="noopener noreferrer">{{.Destination}}</a></p>
+{{end}}<dl>
+<dt>Created</dt><dd>{{if .CreatedAt.IsZero}}unknown{{else}}{{.CreatedAt.Format "2 Jan 2006 15:04 MST"}}{{end}}</dd>
+<dt>Clicks</dt><dd>{{.Clicks}}</dd>
+</dl>
+{{if .Suspicious}}<p><strong>Warning:</strong> this link has been flagged as suspicious.</p>{{end}}
+</body>
+</html>
+`))
+
+var warningTemplate = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Warning: suspicious link</title></head>
+<body>
+<h1>Warning: this link has been flagged as suspicious</h1>
+{{if not .Withheld}}<p>You are about to visit:</p>
+<p><code>{{.Destination}}</code></p>{{end}}
+<p><a href="{{.Continue}}" rel="noopener noreferrer">Continue anyway</a></p>
+</body>
+</html>
+`))
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Password required</title></head>
+<body>
+<h1>This link is password protected</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+<label>Password <input type="password" name="password" autofocus required></label>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+func renderPage(w http.ResponseWriter, status int, tmpl *template.Template, page previewPage) {
+	w.Header().Set("content-type", HtmlContentType)
+	w.WriteHeader(status)
+	tmpl.Execute(w, page)
+}
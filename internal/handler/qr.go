@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/qr"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	QRPathPrefix   = "/qr/"
+	PngContentType = "image/png"
+	SvgContentType = "image/svg+xml"
+	// DefaultBaseURL is what short URLs start with until a base URL is set
+	DefaultBaseURL = "http://localhost:3000"
+)
+
+// QRCode serves /qr/<code>.png and /qr/<code>.svg, encoding the full short URL.
+// The size, level (L, M, Q, H) and margin query parameters tune the output.
+type QRCode struct {
+	store   storage.URLStore
+	baseURL string
+}
+
+func NewQRCode(store storage.URLStore) *QRCode {
+	return &QRCode{store, DefaultBaseURL}
+}
+
+// WithBaseURL encodes short URLs under baseURL, as parsed by ParseBaseURL.
+func (q *QRCode) WithBaseURL(baseURL string) *QRCode {
+	q.baseURL = baseURL
+	return q
+}
+
+// ParseBaseURL checks baseURL is an absolute http or https URL short codes
+// can be appended to, and returns it without a trailing slash.
+func ParseBaseURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("base URL %q is not an absolute http or https URL without a query", baseURL)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// QRCodeURL is the path of the PNG QR code of shortCode.
+func QRCodeURL(shortCode string) string {
+	return QRPathPrefix + url.PathEscape(shortCode) + ".png"
+}
+
+func (q *QRCode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "QRCode.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	// errors are JSON, the content type is replaced once the code renders
+	w.Header().Set("content-type", JsonContentType)
+	name := path.Base(r.URL.Path)
+	ext := path.Ext(name)
+	shortCode := strings.TrimSuffix(name, ext)
+	logging.SetShortCode(ctx, shortCode)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode))
+
+	var contentType string
+	switch ext {
+	case ".png":
+		contentType = PngContentType
+	case ".svg":
+		contentType = SvgContentType
+	default:
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_QR_FORMAT, ERR_QR_FORMAT_CODE, fmt.Sprintf("unsupported format %q", ext))
+		errResponse.WriteError(w)
+		return
+	}
+
+	exists, err := q.store.Exists(r.Context(), shortCode)
+	var info link.Info
+	if err == nil && exists {
+		info, err = q.store.GetInfo(r.Context(), shortCode)
+		if errors.Is(err, storage.ErrShortCodeNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to look up link", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	if !exists {
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+		errResponse.WriteError(w)
+		return
+	}
+	if info.Deleted() {
+		linkDeleted(w)
+		return
+	}
+
+	opts, err := parseQROptions(r)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_QR_OPTIONS, ERR_QR_OPTIONS_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	code, err := qr.Encode(shortURL(q.baseURL, shortCode), opts)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_QR_OPTIONS, ERR_QR_OPTIONS_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	// render into a buffer so a failure can still be reported as an error
+	var buf bytes.Buffer
+	if contentType == PngContentType {
+		err = code.PNG(&buf)
+	} else {
+		err = code.SVG(&buf)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to render QR code", "short_code", shortCode, "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_QR_RENDER, ERR_QR_RENDER_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	w.Header().Set("content-type", contentType)
+	w.Write(buf.Bytes())
+}
+
+func parseQROptions(r *http.Request) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	query := r.URL.Query()
+	var err error
+
+	if size := query.Get("size"); size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			return opts, qr.ErrInvalidSize
+		}
+	}
+	if margin := query.Get("margin"); margin != "" {
+		if opts.Margin, err = strconv.Atoi(margin); err != nil {
+			return opts, qr.ErrInvalidMargin
+		}
+	}
+	if level := query.Get("level"); level != "" {
+		if opts.Level, err = qr.ParseLevel(level); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// shortURL is the public short link for shortCode. It is built from the
+// configured base URL rather than the request, whose Host and forwarding
+// headers the client controls.
+func shortURL(baseURL, shortCode string) string {
+	return baseURL + "/" + url.PathEscape(shortCode)
+}
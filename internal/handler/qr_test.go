@@ -0,0 +1,96 @@
+package handler_test
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+)
+
+func TestParseBaseURL(t *testing.T) {
+	for input, want := range map[string]string{"https://sho.rt": "https://sho.rt", "http://localhost:3000/s/": "http://localhost:3000/s"} {
+		got, err := handler.ParseBaseURL(input)
+		assertNoErr(t, err)
+		if got != want {
+			t.Errorf("ParseBaseURL(%q) = %q, want %q", input, got, want)
+		}
+	}
+	for _, invalid := range []string{"", "sho.rt", "javascript:alert(1)", "https://sho.rt/?a=b", "ftp://sho.rt"} {
+		if _, err := handler.ParseBaseURL(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}}
+	server := handler.NewQRCode(&store)
+
+	t.Run("GET /qr/abc123.png renders a PNG", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newQRRequest("abc123.png?size=128"))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.PngContentType)
+
+		img, err := png.Decode(response.Body)
+		assertNoErr(t, err)
+		if got := img.Bounds().Dx(); got != 128 {
+			t.Errorf("got width %d, want %d", got, 128)
+		}
+	})
+
+	t.Run("GET /qr/abc123.svg renders an SVG", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newQRRequest("abc123.svg?level=H&margin=2"))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.SvgContentType)
+		if !strings.HasPrefix(response.Body.String(), "<svg") {
+			t.Errorf("expected an svg document, got %q", response.Body.String())
+		}
+	})
+
+	errorCases := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "unknown short code", path: "xyz123.png", wantStatus: http.StatusNotFound, wantError: handler.ERR_SHORT_CODE_NOT_FOUND},
+		{name: "unsupported format", path: "abc123.gif", wantStatus: http.StatusNotFound, wantError: handler.ERR_QR_FORMAT},
+		{name: "invalid size", path: "abc123.png?size=huge", wantStatus: http.StatusBadRequest, wantError: handler.ERR_QR_OPTIONS},
+		{name: "size too large", path: "abc123.png?size=100000", wantStatus: http.StatusBadRequest, wantError: handler.ERR_QR_OPTIONS},
+		{name: "size too small to scan", path: "abc123.png?size=10", wantStatus: http.StatusBadRequest, wantError: handler.ERR_QR_OPTIONS},
+		{name: "invalid level", path: "abc123.png?level=Z", wantStatus: http.StatusBadRequest, wantError: handler.ERR_QR_OPTIONS},
+		{name: "negative margin", path: "abc123.svg?margin=-1", wantStatus: http.StatusBadRequest, wantError: handler.ERR_QR_OPTIONS},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, newQRRequest(tt.path))
+			assertStatusCode(t, response.Code, tt.wantStatus)
+			assertContentType(t, response.Result().Header.Get("content-type"), handler.JsonContentType)
+
+			got, err := getErrorResponse(response.Body)
+			assertNoErr(t, err)
+			assertErrMessage(t, got.Error, tt.wantError)
+		})
+	}
+}
+
+func TestQRCodeURL(t *testing.T) {
+	for code, want := range map[string]string{"abc123": "/qr/abc123.png", "a/b?c": "/qr/a%2Fb%3Fc.png"} {
+		if got := handler.QRCodeURL(code); got != want {
+			t.Errorf("QRCodeURL(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func newQRRequest(path string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, handler.QRPathPrefix+path, nil)
+}
@@ -1,26 +1,306 @@
 package handler
 
 import (
+	"errors"
+	"math/rand/v2"
 	"net/http"
-	"path"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/sotiri-geo/url-shortener/internal/geoip"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/password"
 	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// A trailing "+" on the short code or a ?preview query shows the preview page
+	PreviewSuffix     = link.PreviewSuffix
+	PreviewQueryParam = "preview"
+	// Set by the interstitial warning page once the visitor chooses to continue
+	ConfirmQueryParam = "confirm"
+	// Form field submitted by the password page of protected links
+	PasswordFormField = "password"
+	// Remembers the variant a visitor was given on links with sticky variants
+	VariantCookie       = "variant"
+	variantCookieMaxAge = 30 * 24 * time.Hour
 )
 
 type Redirector struct {
-	store storage.URLStore
+	store      storage.URLStore
+	lockout    *lockout
+	defaultUTM map[string]string
+	geoIP      *geoip.DB
 }
 
 func NewRedirector(store storage.URLStore) *Redirector {
-	return &Redirector{store}
+	return &Redirector{store, newLockout(maxPasswordAttempts, passwordLockout), nil, nil}
+}
+
+// WithUTM adds tags, keyed as link.Info.UTM is, to the destination of every
+// link that does not set them itself.
+func (rd *Redirector) WithUTM(tags map[string]string) *Redirector {
+	rd.defaultUTM = tags
+	return rd
+}
+
+// WithGeoIP looks up the country of visitors in db for routing rules. Without
+// it, rules for countries never match.
+func (rd *Redirector) WithGeoIP(db *geoip.DB) *Redirector {
+	rd.geoIP = db
+	return rd
 }
 
 func (rd *Redirector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	originalURL, exists := rd.store.GetOriginalURL(path.Base(r.URL.Path))
-	if !exists {
-		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+	ctx, span := tracing.Tracer().Start(r.Context(), "Redirector.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	shortCode, suffix := splitLinkPath(r.URL)
+	query := r.URL.Query()
+	preview := query.Has(PreviewQueryParam)
+	if strings.HasSuffix(shortCode, PreviewSuffix) {
+		shortCode = strings.TrimSuffix(shortCode, PreviewSuffix)
+		preview = true
+	}
+	logging.SetShortCode(ctx, shortCode)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode), attribute.Bool("shortener.preview", preview))
+
+	originalURL, err := rd.store.GetOriginalURL(ctx, shortCode)
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		linkNotFound(w)
+		return
+	}
+	if err != nil {
+		rd.storeFailure(w, r, err)
+		return
+	}
+
+	info, err := rd.store.GetInfo(ctx, shortCode)
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		// gone since its URL was read, or only its URL was still cached
+		linkNotFound(w)
+		return
+	}
+	if err != nil {
+		// redirecting without the info could skip a password or warning page
+		rd.storeFailure(w, r, err)
+		return
+	}
+	if info.Deleted() {
+		linkDeleted(w)
+		return
+	}
+	if info.Exhausted() {
+		linkExhausted(w)
+		return
+	}
+	if suffix != "" && suffix != "/" && !info.ForwardPath {
+		// only links set up to forward paths answer for anything below them
+		metrics.RedirectsNotFound.Inc()
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, shortCode+" does not forward paths")
 		errResponse.WriteError(w)
 		return
 	}
-	http.Redirect(w, r, originalURL, http.StatusFound)
+	l, routed := link.New(shortCode, originalURL, info), false
+	if len(info.Rules) > 0 {
+		l, routed = l.Route(rd.visitor(r))
+	}
+	// visitors a rule picked out are left out of the split
+	var variant string
+	if len(info.Variants) > 0 && !routed {
+		served := chooseVariant(w, r, l)
+		l.URL, variant = served.URL, served.Name
+		logging.SetVariant(ctx, variant)
+		span.SetAttributes(attribute.String("shortener.variant", variant))
+	}
+	if info.ForwardPath {
+		if l, err = l.AppendPath(suffix); err != nil {
+			if !errors.Is(err, link.ErrUnsafePath) {
+				rd.storeFailure(w, r, err)
+				return
+			}
+			errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_PATH, ERR_INVALID_PATH_CODE, err.Error())
+			errResponse.WriteError(w)
+			return
+		}
+	}
+	// the redirector's own parameters are not passed on to the destination
+	destination := l.Destination(r.URL.RawQuery, rd.defaultUTM, PreviewQueryParam, ConfirmQueryParam)
+	page := previewPage{ShortCode: shortCode, Destination: destination, Continue: continueURL(r), Info: info}
+	if info.Suspicious && !preview && !query.Has(ConfirmQueryParam) {
+		renderPage(w, http.StatusOK, warningTemplate, page)
+		return
+	}
+	// the form posts back to the same URL, so a confirmed warning or preview carries through
+	if info.PasswordHash != "" && !rd.checkPassword(w, r, &page) {
+		return
+	}
+	if preview {
+		renderPage(w, http.StatusOK, previewTemplate, page)
+		return
+	}
+
+	// counting the click is what claims it on links with a click limit, so
+	// the store decides which of concurrent visitors get the last ones
+	if variant != "" {
+		err = rd.store.IncrementVariantClicks(ctx, shortCode, variant)
+	} else {
+		err = rd.store.IncrementClicks(ctx, shortCode)
+	}
+	if errors.Is(err, storage.ErrClickLimitReached) {
+		linkExhausted(w)
+		return
+	}
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		linkNotFound(w)
+		return
+	}
+	if err != nil && info.MaxClicks > 0 {
+		// redirecting without the click counted could go over the limit
+		rd.storeFailure(w, r, err)
+		return
+	}
+	if err != nil {
+		// losing a click is better than failing the redirect
+		logging.FromContext(ctx).Warn("failed to count click", "short_code", shortCode, "error", err)
+	}
+	metrics.RedirectsServed.Inc()
+	status := http.StatusFound
+	if r.Method == http.MethodPost {
+		// make the browser follow up with a GET after submitting the password form
+		status = http.StatusSeeOther
+	}
+	http.Redirect(w, r, destination, status)
+}
+
+// visitor describes who r comes from for matching routing rules.
+func (rd *Redirector) visitor(r *http.Request) link.Visitor {
+	v := link.Visitor{
+		Device:   link.DeviceFromUserAgent(r.UserAgent()),
+		Language: link.PreferredLanguage(r.Header.Get("Accept-Language")),
+		At:       time.Now(),
+	}
+	if rd.geoIP != nil {
+		if ip, err := netip.ParseAddr(clientIP(r)); err == nil {
+			v.Country = rd.geoIP.Country(ip)
+		}
+	}
+	return v
+}
+
+// chooseVariant picks which of l's variants to serve by weight. Visitors of
+// links with sticky variants keep the one their cookie names while it is
+// still there, even once its weight drops to zero.
+func chooseVariant(w http.ResponseWriter, r *http.Request, l link.Link) link.Variant {
+	if l.StickyVariants {
+		if cookie, err := r.Cookie(VariantCookie); err == nil {
+			if variant, ok := l.Variant(cookie.Value); ok {
+				return variant
+			}
+		}
+	}
+	variant := l.PickVariant(rand.IntN(l.VariantWeight()))
+	if l.StickyVariants {
+		// scoped to the link, so each split link remembers its own variant
+		http.SetCookie(w, &http.Cookie{
+			Name:     VariantCookie,
+			Value:    variant.Name,
+			Path:     "/" + url.PathEscape(l.Code),
+			MaxAge:   int(variantCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return variant
+}
+
+// splitLinkPath splits the request path into the short code and the escaped
+// suffix after it, which keeps its leading "/" and is empty when there is
+// none.
+func splitLinkPath(u *url.URL) (shortCode, suffix string) {
+	escapedCode, rest, found := strings.Cut(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	if found {
+		suffix = "/" + rest
+	}
+	shortCode, err := url.PathUnescape(escapedCode)
+	if err != nil {
+		return escapedCode, suffix
+	}
+	return shortCode, suffix
+}
+
+// continueURL is where the warning page sends a visitor who continues,
+// keeping the path suffix and query string for links that pass them on.
+func continueURL(r *http.Request) string {
+	query := ConfirmQueryParam + "=1"
+	if r.URL.RawQuery != "" {
+		query = r.URL.RawQuery + "&" + query
+	}
+	return r.URL.EscapedPath() + "?" + query
+}
+
+// linkDeleted answers requests for a trashed link, which stays reserved so
+// printed copies of it never lead somewhere else.
+func linkDeleted(w http.ResponseWriter) {
+	errResponse := NewErrorResponse(http.StatusGone, ERR_LINK_DELETED, ERR_LINK_DELETED_CODE, ERR_LINK_DELETED_DETAILS)
+	errResponse.WriteError(w)
+}
+
+func linkNotFound(w http.ResponseWriter) {
+	metrics.RedirectsNotFound.Inc()
+	errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, ERR_SHORT_CODE_NOT_FOUND_DETAILS)
+	errResponse.WriteError(w)
+}
+
+// linkExhausted answers requests for a link that has served all the clicks
+// it allows, like a one-time link that has been followed.
+func linkExhausted(w http.ResponseWriter) {
+	metrics.RedirectsExhausted.Inc()
+	errResponse := NewErrorResponse(http.StatusGone, ERR_LINK_EXHAUSTED, ERR_LINK_EXHAUSTED_CODE, ERR_LINK_EXHAUSTED_DETAILS)
+	errResponse.WriteError(w)
+}
+
+func (rd *Redirector) storeFailure(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Error("failed to look up link", "error", err)
+	errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+	errResponse.WriteError(w)
+}
+
+// checkPassword serves the password form for protected links and only reports
+// true once a submitted password has been verified against the stored hash.
+func (rd *Redirector) checkPassword(w http.ResponseWriter, r *http.Request, page *previewPage) bool {
+	logger := logging.FromContext(r.Context())
+	if rd.lockout.Locked(page.ShortCode) {
+		page.Error = "Too many incorrect attempts. Try again later."
+		renderPage(w, http.StatusTooManyRequests, passwordTemplate, *page)
+		return false
+	}
+	if r.Method != http.MethodPost {
+		renderPage(w, http.StatusUnauthorized, passwordTemplate, *page)
+		return false
+	}
+
+	ok, err := password.Verify(r.PostFormValue(PasswordFormField), page.PasswordHash)
+	if err != nil {
+		logger.Error("failed to verify password", "short_code", page.ShortCode, "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_PASSWORD_CHECK, ERR_PASSWORD_CHECK_CODE, err.Error())
+		errResponse.WriteError(w)
+		return false
+	}
+	if !ok {
+		rd.lockout.Fail(page.ShortCode)
+		logger.Warn("incorrect password", "short_code", page.ShortCode, "locked", rd.lockout.Locked(page.ShortCode))
+		page.Error = "Incorrect password."
+		renderPage(w, http.StatusUnauthorized, passwordTemplate, *page)
+		return false
+	}
+	rd.lockout.Reset(page.ShortCode)
+	return true
 }
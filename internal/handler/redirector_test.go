@@ -1,12 +1,23 @@
 package handler_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sotiri-geo/url-shortener/internal/geoip"
 	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/password"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
 )
 
 func TestRedirector(t *testing.T) {
@@ -38,15 +49,536 @@ func TestRedirector(t *testing.T) {
 
 	})
 
+	t.Run("GET /abc123 reports a failing store", func(t *testing.T) {
+		store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}, err: errors.New("connection refused")}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+
+		var got handler.ErrorResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		assertErrMessage(t, got.Error, handler.ERR_LOOKUP_FAILURE)
+	})
+
+	t.Run("GET /abc123 of a trashed link is gone", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {DeletedAt: time.Now()}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertStatusCode(t, response.Code, http.StatusGone)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+
+		var got handler.ErrorResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		assertErrMessage(t, got.Error, handler.ERR_LINK_DELETED)
+		if info, _ := store.GetInfo(context.Background(), "abc123"); info.Clicks != 0 {
+			t.Errorf("got %d clicks, want none", info.Clicks)
+		}
+	})
+
+	t.Run("GET /abc123 of a link deleted since its URL was read is not found", func(t *testing.T) {
+		// as a stale cache of only the URL would answer
+		store := staleURLStore{memory.New(), "https://secret.example"}
+		server := handler.NewRedirector(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertStatusCode(t, response.Code, http.StatusNotFound)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+	})
+
+	t.Run("GET /abc123 counts the click", func(t *testing.T) {
+		store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}}
+		server := handler.NewRedirector(&store)
+
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+		server.ServeHTTP(httptest.NewRecorder(), newRedirectRequest("abc123"))
+
+		info, _ := store.GetInfo(context.Background(), "abc123")
+		if info.Clicks != 2 {
+			t.Errorf("got %d clicks, want %d", info.Clicks, 2)
+		}
+	})
+
+	previewCases := []struct {
+		name string
+		path string
+	}{
+		{name: "GET /abc123+ renders preview page", path: "abc123+"},
+		{name: "GET /abc123?preview renders preview page", path: "abc123?preview"},
+	}
+	for _, tt := range previewCases {
+		t.Run(tt.name, func(t *testing.T) {
+			store := FakeStore{
+				urls: map[string]string{"abc123": "https://example.com"},
+				info: map[string]link.Info{"abc123": {
+					Title:     "Example Domain",
+					CreatedAt: time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC),
+					Clicks:    42,
+				}},
+			}
+			server := handler.NewRedirector(&store)
+			req := newRedirectRequest(tt.path)
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, req)
+			assertStatusCode(t, response.Code, http.StatusOK)
+			assertContentType(t, response.Result().Header.Get("content-type"), handler.HtmlContentType)
+			assertLocationHeader(t, response.Header().Get("Location"), "")
+
+			body := response.Body.String()
+			for _, want := range []string{"https://example.com", "Example Domain", "14 Mar 2025", "42"} {
+				assertBodyContains(t, body, want)
+			}
+
+			info, _ := store.GetInfo(context.Background(), "abc123")
+			if info.Clicks != 42 {
+				t.Errorf("preview should not count a click: got %d clicks", info.Clicks)
+			}
+		})
+	}
+
+	t.Run("GET /abc123 on a suspicious link renders a warning", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {Suspicious: true}},
+		}
+		server := handler.NewRedirector(&store)
+		req := newRedirectRequest("abc123")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.HtmlContentType)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+		assertBodyContains(t, response.Body.String(), "/abc123?confirm=1")
+	})
+
+	t.Run("GET /abc123?confirm on a suspicious link redirects", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {Suspicious: true}},
+		}
+		server := handler.NewRedirector(&store)
+		req := newRedirectRequest("abc123?confirm=1")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, response.Code, http.StatusFound)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com")
+	})
+
+	t.Run("GET /abc123?gclid=x builds the destination query", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com/landing?a=1#pricing"},
+			info: map[string]link.Info{"abc123": {
+				Query:       map[string]string{"ref": "short"},
+				QueryPolicy: link.MergeQuery,
+				UTM:         map[string]string{"campaign": "spring"},
+			}},
+		}
+		server := handler.NewRedirector(&store).WithUTM(map[string]string{"source": "shortener"})
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123?gclid=x&a=2"))
+		assertStatusCode(t, response.Code, http.StatusFound)
+		assertLocationHeader(t, response.Header().Get("Location"),
+			"https://example.com/landing?a=1&ref=short&utm_source=shortener&utm_campaign=spring&gclid=x#pricing")
+	})
+
+	t.Run("GET /abc123?gclid=x on a suspicious link keeps the query past the warning", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {Suspicious: true, QueryPolicy: link.MergeQuery}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123?gclid=x"))
+		assertBodyContains(t, response.Body.String(), "/abc123?gclid=x&amp;confirm=1")
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newRedirectRequest("abc123?gclid=x&confirm=1"))
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com?gclid=x")
+	})
+
+	t.Run("GET /abc123/docs/install forwards the path suffix", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com/base/?lang=en"},
+			info: map[string]link.Info{"abc123": {ForwardPath: true, QueryPolicy: link.MergeQuery}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123/docs/caf%C3%A9%20guide?v=2"))
+		assertStatusCode(t, response.Code, http.StatusFound)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com/base/docs/caf%C3%A9%20guide?lang=en&v=2")
+	})
+
+	t.Run("GET /abc123/docs/install of a link that does not forward paths is not found", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com", "install": "https://example.org"},
+			info: map[string]link.Info{"abc123": {}, "install": {}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123/docs/install"))
+		assertStatusCode(t, response.Code, http.StatusNotFound)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newRedirectRequest("abc123/"))
+		assertStatusCode(t, response.Code, http.StatusFound)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com")
+	})
+
+	t.Run("GET /abc123/%2e%2e/admin is rejected", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com/docs"},
+			info: map[string]link.Info{"abc123": {ForwardPath: true}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123/%2e%2e/admin"))
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+		got, err := getErrorResponse(response.Body)
+		assertNoErr(t, err)
+		assertErrMessage(t, got.Error, handler.ERR_INVALID_PATH)
+	})
+
+	t.Run("GET /abc123/docs on a suspicious link keeps the suffix past the warning", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {Suspicious: true, ForwardPath: true}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123/docs"))
+		assertBodyContains(t, response.Body.String(), "/abc123/docs?confirm=1")
+	})
+
+	t.Run("GET /abc123 follows the routing rules", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com/app"},
+			info: map[string]link.Info{"abc123": {
+				QueryPolicy: link.MergeQuery,
+				Rules: []link.Rule{
+					{URL: "https://apps.apple.com/app/id1", Devices: []string{link.DeviceIOS}},
+					{URL: "https://play.google.com/store/apps/details?id=app", Devices: []string{link.DeviceAndroid}},
+					{URL: "https://example.fr/app", Countries: []string{"FR"}},
+					{URL: "https://example.de/app", Languages: []string{"de"}},
+				},
+			}},
+		}
+		db, err := geoip.Load(strings.NewReader("203.0.113.0,203.0.113.255,FR\n"))
+		assertNoErr(t, err)
+		server := handler.TrustProxies([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, handler.NewRedirector(&store).WithGeoIP(db))
+
+		cases := []struct {
+			name    string
+			headers map[string]string
+			want    string
+		}{
+			{"iOS", map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"}, "https://apps.apple.com/app/id1?ref=x"},
+			{"Android", map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8)"}, "https://play.google.com/store/apps/details?id=app&ref=x"},
+			{"France", map[string]string{"X-Forwarded-For": "203.0.113.10"}, "https://example.fr/app?ref=x"},
+			{"German", map[string]string{"Accept-Language": "de-DE,de;q=0.9"}, "https://example.de/app?ref=x"},
+			{"everyone else", map[string]string{"X-Forwarded-For": "198.51.100.1", "Accept-Language": "en"}, "https://example.com/app?ref=x"},
+		}
+		for _, tt := range cases {
+			request := newRedirectRequest("abc123?ref=x")
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, request)
+			assertStatusCode(t, response.Code, http.StatusFound)
+			assertLocationHeader(t, response.Header().Get("Location"), tt.want)
+		}
+	})
+
+	t.Run("GET /abc123 splits visitors between variants", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {
+				Variants: []link.Variant{
+					{Name: "a", URL: "https://example.com/a", Weight: 1},
+					{Name: "b", URL: "https://example.com/b", Weight: 1},
+				},
+			}},
+		}
+		server := handler.NewRedirector(&store)
+
+		served := map[string]int{}
+		for range 200 {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, newRedirectRequest("abc123"))
+			assertStatusCode(t, response.Code, http.StatusFound)
+			served[response.Header().Get("Location")]++
+			if cookies := response.Result().Cookies(); len(cookies) != 0 {
+				t.Fatalf("got cookies %v without sticky variants", cookies)
+			}
+		}
+		if served["https://example.com/a"] == 0 || served["https://example.com/b"] == 0 || len(served) != 2 {
+			t.Errorf("got destinations %v, want both variants", served)
+		}
+		info := store.info["abc123"]
+		if info.Clicks != 200 || info.Variants[0].Clicks != served["https://example.com/a"] || info.Variants[1].Clicks != served["https://example.com/b"] {
+			t.Errorf("got %d clicks split %+v, served %v", info.Clicks, info.Variants, served)
+		}
+	})
+
+	t.Run("GET /abc123 keeps a visitor on their variant with sticky variants", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {
+				StickyVariants: true,
+				Variants: []link.Variant{
+					{Name: "a", URL: "https://example.com/a", Weight: 1},
+					{Name: "b", URL: "https://example.com/b"},
+				},
+			}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com/a")
+		cookies := response.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != handler.VariantCookie || cookies[0].Value != "a" || cookies[0].Path != "/abc123" {
+			t.Fatalf("got cookies %v", cookies)
+		}
+
+		// b gets no new visitors but keeps those it already has
+		request := newRedirectRequest("abc123")
+		request.AddCookie(&http.Cookie{Name: handler.VariantCookie, Value: "b"})
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com/b")
+		if cookies := response.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("got cookies %v, want the existing one kept", cookies)
+		}
+
+		request = newRedirectRequest("abc123")
+		request.AddCookie(&http.Cookie{Name: handler.VariantCookie, Value: "removed"})
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com/a")
+	})
+
+	t.Run("GET /abc123 leaves visitors a rule picks out of the split", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {
+				Rules: []link.Rule{{URL: "https://apps.apple.com/app/id1", Devices: []string{link.DeviceIOS}}},
+				Variants: []link.Variant{
+					{Name: "a", URL: "https://example.com/a", Weight: 1},
+					{Name: "b", URL: "https://example.com/b"},
+				},
+			}},
+		}
+		server := handler.NewRedirector(&store)
+		request := newRedirectRequest("abc123")
+		request.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://apps.apple.com/app/id1")
+		if info := store.info["abc123"]; info.Clicks != 1 || info.Variants[0].Clicks != 0 {
+			t.Errorf("got %d clicks split %+v", info.Clicks, info.Variants)
+		}
+	})
+
+	t.Run("GET /abc123 of a one-time link only redirects once", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://example.com/invite"},
+			info: map[string]link.Info{"abc123": {MaxClicks: 1}},
+		}
+		server := handler.NewRedirector(&store)
+
+		// looking before following does not use the link up
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, newRedirectRequest("abc123+"))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		response = httptest.NewRecorder()
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertStatusCode(t, response.Code, http.StatusFound)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com/invite")
+
+		for _, path := range []string{"abc123", "abc123+"} {
+			response = httptest.NewRecorder()
+			server.ServeHTTP(response, newRedirectRequest(path))
+			assertStatusCode(t, response.Code, http.StatusGone)
+			got, err := getErrorResponse(response.Body)
+			assertNoErr(t, err)
+			assertErrMessage(t, got.Error, handler.ERR_LINK_EXHAUSTED)
+		}
+	})
+
+	t.Run("GET /abc123 of a link with a click limit lets only that many through at once", func(t *testing.T) {
+		store := memory.New()
+		ctx := context.Background()
+		assertNoErr(t, store.Save(ctx, "abc123", "https://example.com"))
+		assertNoErr(t, store.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 3}))
+		server := handler.NewRedirector(store)
+
+		codes := make(chan int, 20)
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response := httptest.NewRecorder()
+				server.ServeHTTP(response, newRedirectRequest("abc123"))
+				codes <- response.Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		served := map[int]int{}
+		for code := range codes {
+			served[code]++
+		}
+		if served[http.StatusFound] != 3 || served[http.StatusGone] != 17 {
+			t.Errorf("got responses %v, want 3 redirects and 17 gone", served)
+		}
+	})
+
+	t.Run("GET /abc123+ preview of a one-time link withholds the destination", func(t *testing.T) {
+		store := FakeStore{
+			urls: map[string]string{"abc123": "https://invite.example"},
+			info: map[string]link.Info{"abc123": {MaxClicks: 1}},
+		}
+		server := handler.NewRedirector(&store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123+"))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if strings.Contains(response.Body.String(), "invite.example") {
+			t.Errorf("preview gave the destination away: %q", response.Body.String())
+		}
+		if info, _ := store.GetInfo(context.Background(), "abc123"); info.Clicks != 0 {
+			t.Errorf("preview should not count a click: got %d clicks", info.Clicks)
+		}
+	})
+
+	t.Run("GET /xyz123+ preview of unknown code is not found", func(t *testing.T) {
+		store := FakeStore{urls: map[string]string{"abc123": "https://example.com"}}
+		server := handler.NewRedirector(&store)
+		req := newRedirectRequest("xyz123+")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, req)
+		assertStatusCode(t, response.Code, http.StatusNotFound)
+	})
 }
 
 func newRedirectRequest(shortCode string) *http.Request {
 	return httptest.NewRequest(http.MethodGet, "/"+shortCode, nil)
 }
 
+func assertBodyContains(t testing.TB, body, want string) {
+	t.Helper()
+	if !strings.Contains(body, want) {
+		t.Errorf("body %q does not contain %q", body, want)
+	}
+}
+
 func assertLocationHeader(t testing.TB, got, want string) {
 	t.Helper()
 	if got != want {
 		t.Errorf("got location %q, want %q", got, want)
 	}
 }
+
+func TestRedirectorWithPassword(t *testing.T) {
+	hash, err := password.Hash("open sesame")
+	assertNoErr(t, err)
+	newStore := func() *FakeStore {
+		return &FakeStore{
+			urls: map[string]string{"abc123": "https://example.com"},
+			info: map[string]link.Info{"abc123": {PasswordHash: hash}},
+		}
+	}
+
+	t.Run("GET /abc123 serves the password form", func(t *testing.T) {
+		server := handler.NewRedirector(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123"))
+		assertStatusCode(t, response.Code, http.StatusUnauthorized)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.HtmlContentType)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+		assertBodyContains(t, response.Body.String(), `name="password"`)
+		if strings.Contains(response.Body.String(), "https://example.com") {
+			t.Error("password form should not reveal the destination")
+		}
+	})
+
+	t.Run("GET /abc123+ preview also requires the password", func(t *testing.T) {
+		server := handler.NewRedirector(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newRedirectRequest("abc123+"))
+		assertStatusCode(t, response.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("POST /abc123 with the correct password redirects", func(t *testing.T) {
+		server := handler.NewRedirector(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newPasswordRequest("abc123", "open sesame"))
+		assertStatusCode(t, response.Code, http.StatusSeeOther)
+		assertLocationHeader(t, response.Header().Get("Location"), "https://example.com")
+	})
+
+	t.Run("POST /abc123 with the wrong password is rejected", func(t *testing.T) {
+		server := handler.NewRedirector(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newPasswordRequest("abc123", "open barley"))
+		assertStatusCode(t, response.Code, http.StatusUnauthorized)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+		assertBodyContains(t, response.Body.String(), "Incorrect password")
+	})
+
+	t.Run("locks the short code after repeated failures", func(t *testing.T) {
+		server := handler.NewRedirector(newStore())
+		for range 5 {
+			server.ServeHTTP(httptest.NewRecorder(), newPasswordRequest("abc123", "open barley"))
+		}
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, newPasswordRequest("abc123", "open sesame"))
+		assertStatusCode(t, response.Code, http.StatusTooManyRequests)
+		assertLocationHeader(t, response.Header().Get("Location"), "")
+	})
+}
+
+// staleURLStore still resolves every short code to url after the link is gone.
+type staleURLStore struct {
+	*memory.MemoryDB
+	url string
+}
+
+func (s staleURLStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	return s.url, nil
+}
+
+func newPasswordRequest(shortCode, password string) *http.Request {
+	form := url.Values{handler.PasswordFormField: {password}}
+	req := httptest.NewRequest(http.MethodPost, "/"+shortCode, strings.NewReader(form.Encode()))
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	return req
+}
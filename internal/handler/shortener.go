@@ -1,44 +1,138 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"time"
 
+	"github.com/sotiri-geo/url-shortener/internal/audit"
 	"github.com/sotiri-geo/url-shortener/internal/generator"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/password"
 	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	ERR_INVALID_JSON                 = "invalid JSON"
 	ERR_INVALID_JSON_CODE            = "INVALID_JSON"
 	ERR_INVALID_JSON_DETAILS         = "not a valid json format"
+	ERR_REQUEST_TOO_LARGE            = "request body too large"
+	ERR_REQUEST_TOO_LARGE_CODE       = "REQUEST_TOO_LARGE"
 	ERR_EMPTY_URL                    = "url must not be empty"
 	ERR_EMPTY_URL_CODE               = "EMPTY_URL"
 	ERR_EMPTY_URL_DETAILS            = "url must not be empty"
 	ERR_SHORT_CODE_NOT_FOUND         = "short code not found"
 	ERR_SHORT_CODE_NOT_FOUND_CODE    = "NOT_FOUND"
 	ERR_SHORT_CODE_NOT_FOUND_DETAILS = "cannot process redirect without exisiting short code"
+	ERR_LINK_DELETED                 = "link has been deleted"
+	ERR_LINK_DELETED_CODE            = "GONE"
+	ERR_LINK_DELETED_DETAILS         = "the short code was deleted and no longer redirects"
+	ERR_NOT_IN_TRASH                 = "link is not in the trash"
+	ERR_NOT_IN_TRASH_CODE            = "NOT_IN_TRASH"
+	ERR_TRASH_EXPIRED                = "link can no longer be restored"
+	ERR_TRASH_EXPIRED_CODE           = "TRASH_EXPIRED"
+	ERR_STORE_FAILURE                = "failed to store short code"
+	ERR_STORE_FAILURE_CODE           = "STORE_FAILURE"
+	ERR_LOOKUP_FAILURE               = "failed to look up short code"
+	ERR_PASSWORD_CHECK               = "failed to check password"
+	ERR_PASSWORD_CHECK_CODE          = "PASSWORD_CHECK_FAIL"
+	ERR_PASSWORD_HASH                = "failed to hash password"
+	ERR_PASSWORD_HASH_CODE           = "PASSWORD_HASH_FAIL"
+	ERR_INVALID_METADATA             = "invalid link metadata"
+	ERR_INVALID_METADATA_CODE        = "INVALID_METADATA"
+	ERR_INVALID_QUERY                = "invalid destination query options"
+	ERR_INVALID_QUERY_CODE           = "INVALID_QUERY"
+	ERR_INVALID_RULES                = "invalid routing rules"
+	ERR_INVALID_RULES_CODE           = "INVALID_RULES"
+	ERR_INVALID_VARIANTS             = "invalid split variants"
+	ERR_INVALID_VARIANTS_CODE        = "INVALID_VARIANTS"
+	ERR_INVALID_CLICK_LIMIT          = "invalid click limit"
+	ERR_INVALID_CLICK_LIMIT_CODE     = "INVALID_CLICK_LIMIT"
+	ERR_LINK_EXHAUSTED               = "link has been used up"
+	ERR_LINK_EXHAUSTED_CODE          = "EXHAUSTED"
+	ERR_LINK_EXHAUSTED_DETAILS       = "the link has served all the clicks it allows"
+	ERR_INVALID_PATH                 = "invalid path suffix"
+	ERR_INVALID_PATH_CODE            = "INVALID_PATH"
+	ERR_METHOD_NOT_ALLOWED           = "method not allowed"
+	ERR_METHOD_NOT_ALLOWED_CODE      = "METHOD_NOT_ALLOWED"
+	ERR_UNAUTHORIZED                 = "unauthorized"
+	ERR_UNAUTHORIZED_CODE            = "UNAUTHORIZED"
+	ERR_TRANSFER_OPTIONS             = "invalid import or export options"
+	ERR_TRANSFER_OPTIONS_CODE        = "TRANSFER_OPTIONS"
+	ERR_IMPORT_FAILURE               = "failed to import links"
+	ERR_IMPORT_FAILURE_CODE          = "IMPORT_FAILURE"
+	ERR_EXPORT_FAILURE               = "failed to export links"
+	ERR_EXPORT_FAILURE_CODE          = "EXPORT_FAILURE"
+	ERR_AUDIT_FAILURE                = "failed to read link history"
+	ERR_AUDIT_FAILURE_CODE           = "AUDIT_FAILURE"
+	ERR_EVENT_NOT_FOUND              = "history event not found"
+	ERR_EVENT_NOT_FOUND_CODE         = "EVENT_NOT_FOUND"
+	ERR_QR_FORMAT                    = "unsupported QR code format"
+	ERR_QR_FORMAT_CODE               = "QR_FORMAT"
+	ERR_QR_OPTIONS                   = "invalid QR code options"
+	ERR_QR_OPTIONS_CODE              = "QR_OPTIONS"
+	ERR_QR_RENDER                    = "failed to render QR code"
+	ERR_QR_RENDER_CODE               = "QR_RENDER_FAIL"
 	JsonContentType                  = "application/json"
 	maxRetries                       = 3
+	maxTags                          = 20
+	maxMetadataEntries               = 50
+	maxQueryParams                   = 20
+	maxRules                         = 20
+	// maxRequestSize bounds a create request, keeping the link and the audit
+	// event recording it to a sensible size
+	maxRequestSize = 64 << 10
 )
 
 var ErrRetryAttemptsExceeded = errors.New("Exhausted retries.")
 
 type URLShortResponse struct {
 	Short string
+	QR    string
 }
 
 type URLRequest struct {
-	URL string `json:"url"`
+	URL         string            `json:"url"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Creator     string            `json:"creator,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Suspicious  bool              `json:"suspicious,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	// Query parameters added to the destination on every redirect
+	Query map[string]string `json:"query,omitempty"`
+	// QueryPolicy is drop (the default), merge or override
+	QueryPolicy string `json:"query_policy,omitempty"`
+	// UTM tags keyed by source, medium, campaign, term or content
+	UTM map[string]string `json:"utm,omitempty"`
+	// ForwardPath makes /<code>/docs/install redirect to the destination
+	// with /docs/install appended
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// Rules send matching visitors elsewhere, checked in order
+	Rules []link.Rule `json:"rules,omitempty"`
+	// Variants split visitors between destinations by weight, their clicks
+	// are ignored
+	Variants       []link.Variant `json:"variants,omitempty"`
+	StickyVariants bool           `json:"sticky_variants,omitempty"`
+	// MaxClicks caps the redirects the link serves, OneTime caps them at one
+	MaxClicks int  `json:"max_clicks,omitempty"`
+	OneTime   bool `json:"one_time,omitempty"`
 }
 
 type Shortener struct {
 	store      storage.URLStore
 	generator  generator.Generator
 	maxRetries int
+	auditSink  audit.Sink
 }
 
 type ErrorResponse struct {
@@ -48,20 +142,14 @@ type ErrorResponse struct {
 	Status  int    `json:"status"`
 }
 
-type URL map[string]string
-
-func NewUrls(rdr io.Reader) (URL, error) {
-	var urls map[string]string
-
-	err := json.NewDecoder(rdr).Decode(&urls)
-	if err != nil {
-		return nil, err
-	}
-	return urls, nil
+func NewShortener(store storage.URLStore, generator generator.Generator) *Shortener {
+	return &Shortener{store, generator, maxRetries, audit.Discard}
 }
 
-func NewShortener(store storage.URLStore, generator generator.Generator) *Shortener {
-	return &Shortener{store, generator, maxRetries}
+// WithAudit records every link u creates in sink.
+func (u *Shortener) WithAudit(sink audit.Sink) *Shortener {
+	u.auditSink = sink
+	return u
 }
 
 func (e *ErrorResponse) WriteError(w http.ResponseWriter) {
@@ -69,12 +157,12 @@ func (e *ErrorResponse) WriteError(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(e)
 }
 
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	io.WriteString(w, "ok")
-}
-
 // Implement the Handler interface
 func (u *Shortener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "Shortener.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	w.Header().Set("content-type", JsonContentType)
 	switch r.Method {
 	case http.MethodPost:
@@ -84,10 +172,17 @@ func (u *Shortener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *Shortener) processURL(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context())
 	var req URLRequest
 	// try decode the body
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req)
 
+	if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+		details := fmt.Sprintf("the body must be at most %d bytes", maxBytesErr.Limit)
+		errResponse := NewErrorResponse(http.StatusRequestEntityTooLarge, ERR_REQUEST_TOO_LARGE, ERR_REQUEST_TOO_LARGE_CODE, details)
+		errResponse.WriteError(w)
+		return
+	}
 	if err != nil {
 		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_JSON, ERR_INVALID_JSON_CODE, ERR_INVALID_JSON_DETAILS)
 		errResponse.WriteError(w)
@@ -98,30 +193,215 @@ func (u *Shortener) processURL(w http.ResponseWriter, r *http.Request) {
 		errResponse.WriteError(w)
 		return
 	}
+	tags := link.NormaliseTags(req.Tags)
+	if details := validateMetadata(tags, req.Metadata); details != "" {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_METADATA, ERR_INVALID_METADATA_CODE, details)
+		errResponse.WriteError(w)
+		return
+	}
+
+	queryPolicy, utm, err := validateQuery(req)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_QUERY, ERR_INVALID_QUERY_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
 
-	shortCode, err := u.retryShortCode(u.maxRetries)
+	rules, err := validateRules(req.Rules)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_RULES, ERR_INVALID_RULES_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
 
+	variants, err := validateVariants(req.Variants)
 	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_VARIANTS, ERR_INVALID_VARIANTS_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	maxClicks, err := validateClickLimit(req)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_INVALID_CLICK_LIMIT, ERR_INVALID_CLICK_LIMIT_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	var passwordHash string
+	if req.Password != "" {
+		passwordHash, err = password.Hash(req.Password)
+		if err != nil {
+			logger.Error("failed to hash password", "error", err)
+			errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_PASSWORD_HASH, ERR_PASSWORD_HASH_CODE, err.Error())
+			errResponse.WriteError(w)
+			return
+		}
+	}
+
+	shortCode, err := u.retryShortCode(r.Context(), req.URL, u.maxRetries)
+
+	if errors.Is(err, ErrRetryAttemptsExceeded) {
+		metrics.RetryAttemptsExceeded.Inc()
+		logger.Error("failed to generate a free short code", "retries", u.maxRetries, "error", err)
 		errResponse := NewErrorResponse(http.StatusInternalServerError, err.Error(), "RETRY_FAIL", fmt.Sprintf("attempted %d retries", 3))
 		errResponse.WriteError(w)
 		return
 	}
+	if err != nil {
+		logger.Error("failed to save link", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_STORE_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
 
-	u.store.Save(shortCode, req.URL)
+	logging.SetShortCode(r.Context(), shortCode)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("shortener.short_code", shortCode))
+	now := time.Now()
+	info := link.Info{
+		Title:          req.Title,
+		Description:    req.Description,
+		Tags:           tags,
+		Creator:        req.Creator,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Suspicious:     req.Suspicious,
+		PasswordHash:   passwordHash,
+		Metadata:       req.Metadata,
+		Query:          req.Query,
+		QueryPolicy:    queryPolicy,
+		UTM:            utm,
+		ForwardPath:    req.ForwardPath,
+		Rules:          rules,
+		Variants:       variants,
+		StickyVariants: req.StickyVariants,
+		MaxClicks:      maxClicks,
+	}
+	if err := u.store.SaveInfo(r.Context(), shortCode, info); err != nil {
+		logger.Error("failed to save link info", "short_code", shortCode, "error", err)
+		// without its info the link would redirect unprotected and unlimited,
+		// so the code goes back to being free
+		if err := u.store.Delete(context.WithoutCancel(r.Context()), shortCode); err != nil {
+			logger.Error("failed to remove link without info", "short_code", shortCode, "error", err)
+		}
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_STORE_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	metrics.LinksCreated.Inc()
+	if actor := audit.FromContext(r.Context()); req.Creator != "" && actor.Claimed == "" {
+		actor.Claimed = req.Creator
+		r = r.WithContext(audit.WithActor(r.Context(), actor))
+	}
+	recordChange(r, u.auditSink, audit.Create, nil, &link.Link{Code: shortCode, URL: req.URL, Info: info})
+	logger.Info("link created", "short_code", shortCode, "protected", passwordHash != "", "suspicious", req.Suspicious)
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(URLShortResponse{Short: shortCode})
+	json.NewEncoder(w).Encode(URLShortResponse{Short: shortCode, QR: QRCodeURL(shortCode)})
 }
 
-func (u *Shortener) retryShortCode(count int) (string, error) {
+// retryShortCode saves originalURL under a freshly generated short code,
+// generating another one whenever the store reports a collision or the code
+// is one link.ValidateCode rejects. Trashed links keep their code in the
+// store, so a deleted code is never reissued.
+func (u *Shortener) retryShortCode(ctx context.Context, originalURL string, count int) (string, error) {
+	_, span := tracing.Tracer().Start(ctx, "generator.Generate")
 	shortCode := u.generator.Generate()
-	if !u.store.Exists(shortCode) {
-		return shortCode, nil
-	} else if count > 0 {
-		return u.retryShortCode(count - 1)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode))
+	span.End()
+
+	// a code clashing with a route is passed over like a taken one
+	taken := link.ValidateCode(shortCode) != nil
+	if !taken {
+		exists, err := u.store.Exists(ctx, shortCode)
+		if err != nil {
+			return "", err
+		}
+		taken = exists
+	}
+	if !taken {
+		// another writer can still claim the code between Exists and Save
+		err := u.store.Save(ctx, shortCode, originalURL)
+		if !errors.Is(err, storage.ErrShortCodeExists) {
+			return shortCode, err
+		}
+	}
+	metrics.GeneratorCollisions.Inc()
+	trace.SpanFromContext(ctx).AddEvent("short code collision", trace.WithAttributes(attribute.String("shortener.short_code", shortCode)))
+	if count > 0 {
+		return u.retryShortCode(ctx, originalURL, count-1)
 	}
 	return "", ErrRetryAttemptsExceeded
 }
 
+// validateMetadata returns why the tags or metadata of a new link are
+// rejected, or an empty string if they are fine.
+func validateMetadata(tags []string, metadata map[string]string) string {
+	if len(tags) > maxTags {
+		return fmt.Sprintf("at most %d tags are allowed", maxTags)
+	}
+	if len(metadata) > maxMetadataEntries {
+		return fmt.Sprintf("at most %d metadata entries are allowed", maxMetadataEntries)
+	}
+	if _, ok := metadata[""]; ok {
+		return "metadata keys must not be empty"
+	}
+	return ""
+}
+
+// validateQuery checks the destination query options of req, returning its
+// query policy and normalised UTM tags.
+func validateQuery(req URLRequest) (link.QueryPolicy, map[string]string, error) {
+	if len(req.Query) > maxQueryParams {
+		return "", nil, fmt.Errorf("at most %d query parameters are allowed", maxQueryParams)
+	}
+	if _, ok := req.Query[""]; ok {
+		return "", nil, errors.New("query parameter names must not be empty")
+	}
+	var policy link.QueryPolicy
+	if req.QueryPolicy != "" {
+		var err error
+		if policy, err = link.ParseQueryPolicy(req.QueryPolicy); err != nil {
+			return "", nil, err
+		}
+	}
+	utm, err := link.NormaliseUTM(req.UTM)
+	return policy, utm, err
+}
+
+// validateRules checks the routing rules of a new link, returning them
+// normalised.
+func validateRules(rules []link.Rule) ([]link.Rule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("at most %d rules are allowed", maxRules)
+	}
+	return link.NormaliseRules(rules)
+}
+
+// validateVariants checks the variants of a new link, returning them
+// normalised and without clicks.
+func validateVariants(variants []link.Variant) ([]link.Variant, error) {
+	variants, err := link.NormaliseVariants(variants)
+	for i := range variants {
+		variants[i].Clicks = 0
+	}
+	return variants, err
+}
+
+// validateClickLimit returns how many clicks req allows its link, zero for
+// no limit.
+func validateClickLimit(req URLRequest) (int, error) {
+	if req.MaxClicks < 0 {
+		return 0, errors.New("max_clicks must not be negative")
+	}
+	if req.OneTime {
+		if req.MaxClicks > 1 {
+			return 0, errors.New("a one-time link allows a single click, leave max_clicks out")
+		}
+		return 1, nil
+	}
+	return req.MaxClicks, nil
+}
+
 func NewErrorResponse(status int, message, code, details string) *ErrorResponse {
 	return &ErrorResponse{message, code, details, status}
 }
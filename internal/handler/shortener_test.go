@@ -2,40 +2,161 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/password"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
 )
 
 type FakeStore struct {
-	urls map[string]string
+	urls    map[string]string
+	info    map[string]link.Info
+	pingErr error
+	err     error // returned by every lookup and write when set
 }
 
 func (f *FakeStore) GetShortURL(url string) string {
 	return "abc123"
 }
 
-func (f *FakeStore) GetOriginalURL(shortCode string) (string, bool) {
+func (f *FakeStore) UpdateURL(ctx context.Context, shortCode, original string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, exists := f.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	f.urls[shortCode] = original
+	return nil
+}
+
+func (f *FakeStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
 	url, exists := f.urls[shortCode]
-	return url, exists
+	if !exists {
+		return "", storage.ErrShortCodeNotFound
+	}
+	return url, nil
 }
 
-func (f *FakeStore) Save(shortCode, original string) error {
+func (f *FakeStore) Save(ctx context.Context, shortCode, original string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, exists := f.urls[shortCode]; exists {
+		return storage.ErrShortCodeExists
+	}
 	f.urls[shortCode] = original
 	return nil
 }
 
-func (f *FakeStore) Exists(shortCode string) bool {
+func (f *FakeStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
 	_, exists := f.urls[shortCode]
-	return exists
+	return exists, nil
+}
+
+func (f *FakeStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	if f.err != nil {
+		return link.Info{}, f.err
+	}
+	if _, exists := f.urls[shortCode]; !exists {
+		return link.Info{}, storage.ErrShortCodeNotFound
+	}
+	return f.info[shortCode], nil
+}
+
+func (f *FakeStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.info == nil {
+		f.info = make(map[string]link.Info)
+	}
+	f.info[shortCode] = info.KeepClicks(f.info[shortCode])
+	return nil
+}
+
+func (f *FakeStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	info := f.info[shortCode]
+	if info.Exhausted() {
+		return storage.ErrClickLimitReached
+	}
+	info.Clicks++
+	return f.setInfo(shortCode, info)
+}
+
+func (f *FakeStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	info := f.info[shortCode]
+	if info.Exhausted() {
+		return storage.ErrClickLimitReached
+	}
+	info.CountVariantClick(variant)
+	return f.setInfo(shortCode, info)
+}
+
+func (f *FakeStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	info := f.info[shortCode]
+	info.AddClicks(clicks, variantClicks)
+	return f.setInfo(shortCode, info)
+}
+
+// setInfo stores info as is, clicks included, as the click counters do.
+func (f *FakeStore) setInfo(shortCode string, info link.Info) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.info == nil {
+		f.info = make(map[string]link.Info)
+	}
+	f.info[shortCode] = info
+	return nil
+}
+
+func (f *FakeStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var links []link.Link
+	for shortCode, url := range f.urls {
+		if l := link.New(shortCode, url, f.info[shortCode]); filter.Matches(l) {
+			links = append(links, l)
+		}
+	}
+	return filter.Page(links), nil
+}
+
+func (f *FakeStore) Delete(ctx context.Context, shortCode string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, exists := f.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	delete(f.urls, shortCode)
+	delete(f.info, shortCode)
+	return nil
+}
+
+func (f *FakeStore) Ping(ctx context.Context) error {
+	return f.pingErr
 }
 
 func NewFakeStore() *FakeStore {
-	return &FakeStore{urls: make(map[string]string)}
+	return &FakeStore{urls: make(map[string]string), info: make(map[string]link.Info)}
 }
 
 type StubGenerator struct {
@@ -92,6 +213,8 @@ func TestShortener(t *testing.T) {
 		wantStatus       int
 		wantContentType  string
 		wantErrorMessage string
+		wantInfo         *link.Info
+		wantPassword     string
 	}{
 		{
 			name:            "returns a shortened url",
@@ -102,6 +225,177 @@ func TestShortener(t *testing.T) {
 			wantStatus:      http.StatusCreated,
 			wantContentType: handler.JsonContentType,
 		},
+		{
+			name:            "stores title and suspicious flag",
+			payload:         `{ "url": "https://example.com", "title": "Example", "suspicious": true }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo:        &link.Info{Title: "Example", Suspicious: true},
+		},
+		{
+			name: "stores description, tags, creator and metadata",
+			payload: `{ "url": "https://example.com", "description": "An example", "tags": ["News", "docs", "news"],
+				"creator": "alice", "metadata": {"campaign": "spring"} }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo: &link.Info{
+				Description: "An example",
+				Tags:        []string{"docs", "news"},
+				Creator:     "alice",
+				Metadata:    map[string]string{"campaign": "spring"},
+			},
+		},
+		{
+			name:             "rejects metadata with an empty key",
+			payload:          `{ "url": "https://example.com", "metadata": {"": "value"} }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_METADATA,
+		},
+		{
+			name: "stores destination query options",
+			payload: `{ "url": "https://example.com", "query": {"ref": "short"}, "query_policy": "Merge",
+				"utm": {"utm_source": "newsletter", "campaign": "spring"} }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo: &link.Info{
+				Query:       map[string]string{"ref": "short"},
+				QueryPolicy: link.MergeQuery,
+				UTM:         map[string]string{"source": "newsletter", "campaign": "spring"},
+			},
+		},
+		{
+			name:            "stores path forwarding",
+			payload:         `{ "url": "https://example.com/docs", "forward_path": true }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo:        &link.Info{ForwardPath: true},
+		},
+		{
+			name: "stores routing rules",
+			payload: `{ "url": "https://example.com", "rules": [{"url": "https://apps.apple.com/app/id1", "devices": ["iOS"]},
+				{"url": "https://example.fr", "countries": ["fr"], "hours": "09:00-17:00", "time_zone": "Europe/Paris"}] }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo: &link.Info{Rules: []link.Rule{
+				{URL: "https://apps.apple.com/app/id1", Devices: []string{"ios"}},
+				{URL: "https://example.fr", Countries: []string{"FR"}, Hours: "09:00-17:00", TimeZone: "Europe/Paris"},
+			}},
+		},
+		{
+			name:             "rejects a rule without conditions",
+			payload:          `{ "url": "https://example.com", "rules": [{"url": "https://example.org"}] }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_RULES,
+		},
+		{
+			name: "stores split variants without clicks",
+			payload: `{ "url": "https://example.com", "sticky_variants": true,
+				"variants": [{"url": "https://example.com/a", "weight": 3, "clicks": 10}, {"name": "New", "url": "https://example.com/b", "weight": 1}] }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo: &link.Info{StickyVariants: true, Variants: []link.Variant{
+				{Name: "a", URL: "https://example.com/a", Weight: 3},
+				{Name: "new", URL: "https://example.com/b", Weight: 1},
+			}},
+		},
+		{
+			name:             "rejects a split without weights",
+			payload:          `{ "url": "https://example.com", "variants": [{"url": "https://example.com/a"}, {"url": "https://example.com/b"}] }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_VARIANTS,
+		},
+		{
+			name:             "rejects a body over the size limit",
+			payload:          `{ "url": "https://example.com", "description": "` + strings.Repeat("x", 1<<20) + `" }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusRequestEntityTooLarge,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_REQUEST_TOO_LARGE,
+		},
+		{
+			name:            "stores a one-time link as a limit of one click",
+			payload:         `{ "url": "https://example.com/invite", "one_time": true }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantInfo:        &link.Info{MaxClicks: 1},
+		},
+		{
+			name:             "rejects a one-time link with more clicks",
+			payload:          `{ "url": "https://example.com/invite", "one_time": true, "max_clicks": 5 }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_CLICK_LIMIT,
+		},
+		{
+			name:             "rejects a negative click limit",
+			payload:          `{ "url": "https://example.com", "max_clicks": -1 }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_CLICK_LIMIT,
+		},
+		{
+			name:             "rejects an unknown UTM tag",
+			payload:          `{ "url": "https://example.com", "utm": {"gclid": "abc"} }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_QUERY,
+		},
+		{
+			name:             "rejects an unknown query policy",
+			payload:          `{ "url": "https://example.com", "query_policy": "append" }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(s *FakeStore) {},
+			wantStatus:       http.StatusBadRequest,
+			wantContentType:  handler.JsonContentType,
+			wantErrorMessage: handler.ERR_INVALID_QUERY,
+		},
+		{
+			name:            "stores a salted hash of the password",
+			payload:         `{ "url": "https://example.com", "password": "open sesame" }`,
+			setupGen:        func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:      func(s *FakeStore) {},
+			wantShortCode:   "abc123",
+			wantStatus:      http.StatusCreated,
+			wantContentType: handler.JsonContentType,
+			wantPassword:    "open sesame",
+		},
 		{
 			name:             "request body missing url key",
 			payload:          `{ invalid json }`,
@@ -120,6 +414,15 @@ func TestShortener(t *testing.T) {
 			wantStatus:       http.StatusBadRequest,
 			wantErrorMessage: handler.ERR_EMPTY_URL,
 		},
+		{
+			name:             "store failure",
+			payload:          `{ "url": "https://example.com" }`,
+			setupGen:         func(g *StubGenerator) { g.FixedResponse = "abc123" },
+			setupStore:       func(f *FakeStore) { f.err = errors.New("disk full") },
+			wantContentType:  handler.JsonContentType,
+			wantStatus:       http.StatusInternalServerError,
+			wantErrorMessage: handler.ERR_STORE_FAILURE,
+		},
 	}
 
 	for _, tt := range cases {
@@ -144,6 +447,7 @@ func TestShortener(t *testing.T) {
 				got, err := getShortCode(response.Body)
 				assertNoErr(t, err) // decoding error
 				assertShortCode(t, got.Short, tt.wantShortCode)
+				assertShortCode(t, got.QR, handler.QRCodeURL(tt.wantShortCode))
 			}
 
 			if tt.wantErrorMessage != "" {
@@ -151,10 +455,52 @@ func TestShortener(t *testing.T) {
 				assertNoErr(t, err) // decoding error
 				assertErrMessage(t, got.Error, tt.wantErrorMessage)
 			}
+
+			if tt.wantInfo != nil {
+				got, _ := store.GetInfo(context.Background(), tt.wantShortCode)
+				if got.CreatedAt.IsZero() || !got.UpdatedAt.Equal(got.CreatedAt) {
+					t.Errorf("creation time should be recorded, got %v and %v", got.CreatedAt, got.UpdatedAt)
+				}
+				want := *tt.wantInfo
+				want.CreatedAt, want.UpdatedAt = got.CreatedAt, got.UpdatedAt
+				if !got.Equal(want) {
+					t.Errorf("got info %+v, want %+v", got, want)
+				}
+			}
+
+			if tt.wantPassword != "" {
+				got, _ := store.GetInfo(context.Background(), tt.wantShortCode)
+				ok, err := password.Verify(tt.wantPassword, got.PasswordHash)
+				assertNoErr(t, err)
+				if !ok {
+					t.Errorf("stored hash %q does not match password", got.PasswordHash)
+				}
+			}
 		})
 	}
 }
 
+func TestShortenerFailingToSaveInfo(t *testing.T) {
+	store := failingInfoStore{NewFakeStore()}
+	response := httptest.NewRecorder()
+
+	handler.NewShortener(store, NewStubGeneratorWithFixedResponse("abc123", 0)).ServeHTTP(response, newShortenRequest(`{"url": "https://example.com", "password": "hunter2"}`))
+	assertStatusCode(t, response.Code, http.StatusInternalServerError)
+	// the link would otherwise redirect without the password it was created with
+	if exists, _ := store.Exists(context.Background(), "abc123"); exists {
+		t.Error("the link should be removed when its info cannot be saved")
+	}
+}
+
+// failingInfoStore saves links but fails to save their info.
+type failingInfoStore struct {
+	*FakeStore
+}
+
+func (s failingInfoStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	return errors.New("connection reset")
+}
+
 func TestShortenerWithGenerator(t *testing.T) {
 	cases := []struct {
 		name             string
@@ -181,6 +527,35 @@ func TestShortenerWithGenerator(t *testing.T) {
 			wantContentType:  handler.JsonContentType,
 			wantGenCallCount: 2,
 		},
+		{
+			name:    "a trashed short code is never reissued",
+			payload: `{"url": "https://example.com"}`,
+			setupStore: func(f *FakeStore) {
+				f.urls = map[string]string{"xyz123": "https://test.com"}
+				f.info = map[string]link.Info{"xyz123": {DeletedAt: time.Now()}}
+			},
+			setupGen: func(g *StubGenerator) {
+				g.FixedResponse = "abc123"
+				g.RepeatResponse = "xyz123"
+				g.Repeat = 1
+			},
+			wantStatus:       http.StatusCreated,
+			wantContentType:  handler.JsonContentType,
+			wantGenCallCount: 1,
+		},
+		{
+			name:       "a code clashing with a route is never issued",
+			payload:    `{"url": "https://example.com"}`,
+			setupStore: func(f *FakeStore) {},
+			setupGen: func(g *StubGenerator) {
+				g.FixedResponse = "abc123"
+				g.RepeatResponse = "links"
+				g.Repeat = 1
+			},
+			wantStatus:       http.StatusCreated,
+			wantContentType:  handler.JsonContentType,
+			wantGenCallCount: 1,
+		},
 		{
 			name:    "max retries exceeded",
 			payload: `{"url": "https://example.com"}`,
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+const (
+	ExportPath            = "/admin/export"
+	ImportPath            = "/admin/import"
+	FormatQueryParam      = "format"
+	ConflictQueryParam    = "conflict"
+	DryRunQueryParam      = "dry_run"
+	defaultTransferFormat = transfer.JSON
+)
+
+// Export streams every link in the store at GET /admin/export?format=, where
+// format is json (the default), ndjson or csv.
+type Export struct {
+	store storage.URLStore
+}
+
+func NewExport(store storage.URLStore) *Export {
+	return &Export{store}
+}
+
+func (e *Export) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "Export.ServeHTTP")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	format, err := transfer.ParseFormat(queryOr(r, FormatQueryParam, string(defaultTransferFormat)))
+	if err != nil {
+		w.Header().Set("content-type", JsonContentType)
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_TRANSFER_OPTIONS, ERR_TRANSFER_OPTIONS_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	w.Header().Set("content-type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+	out := &writeTracker{w: w}
+	exported, err := transfer.Export(ctx, e.store, out, format)
+	logger := logging.FromContext(ctx)
+	if err != nil && !out.written {
+		logger.Error("failed to export links", "error", err)
+		w.Header().Set("content-type", JsonContentType)
+		w.Header().Del("Content-Disposition")
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_EXPORT_FAILURE, ERR_EXPORT_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	if err != nil {
+		// the status has been sent, so the client only sees a truncated body
+		logger.Error("export failed part way", "exported", exported, "error", err)
+		return
+	}
+	logger.Info("links exported", "format", format, "exported", exported)
+}
+
+// Import loads links from the request body at
+// POST /admin/import?format=&conflict=&dry_run= and responds with a
+// transfer.Report. conflict is skip (the default), overwrite or rename.
+type Import struct {
+	store     storage.URLStore
+	auditSink audit.Sink
+}
+
+func NewImport(store storage.URLStore) *Import {
+	return &Import{store, audit.Discard}
+}
+
+// WithAudit records every link i creates or overwrites in sink.
+func (i *Import) WithAudit(sink audit.Sink) *Import {
+	i.auditSink = sink
+	return i
+}
+
+// ImportFailureResponse is the error an import stopped on, along with the
+// report of what it wrote before, which stays written.
+type ImportFailureResponse struct {
+	ErrorResponse
+	Report transfer.Report `json:"report"`
+}
+
+func (i *Import) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "Import.ServeHTTP")
+	defer span.End()
+
+	w.Header().Set("content-type", JsonContentType)
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	opts, err := importOptions(r)
+	if err != nil {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_TRANSFER_OPTIONS, ERR_TRANSFER_OPTIONS_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	opts.Changed = func(ctx context.Context, before *link.Link, after link.Link) {
+		action := audit.Create
+		if before != nil {
+			action = audit.Update
+		}
+		recordChange(r.WithContext(ctx), i.auditSink, action, before, &after)
+	}
+	logger := logging.FromContext(ctx)
+	report, err := transfer.Import(ctx, i.store, r.Body, opts)
+	if errors.Is(err, transfer.ErrInvalidInput) {
+		errResponse := NewErrorResponse(http.StatusBadRequest, ERR_IMPORT_FAILURE, ERR_IMPORT_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+	if err != nil {
+		logger.Error("failed to import links", "imported", report.Total, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ImportFailureResponse{
+			ErrorResponse: *NewErrorResponse(http.StatusInternalServerError, ERR_IMPORT_FAILURE, ERR_IMPORT_FAILURE_CODE, err.Error()),
+			Report:        report,
+		})
+		return
+	}
+	logger.Info("links imported", "format", opts.Format, "conflict", opts.Strategy, "dry_run", opts.DryRun,
+		"total", report.Total, "created", report.Created, "failed", report.Failed)
+	json.NewEncoder(w).Encode(report)
+}
+
+func importOptions(r *http.Request) (transfer.Options, error) {
+	var opts transfer.Options
+	var err error
+	if opts.Format, err = transfer.ParseFormat(queryOr(r, FormatQueryParam, string(defaultTransferFormat))); err != nil {
+		return opts, err
+	}
+	if opts.Strategy, err = transfer.ParseStrategy(queryOr(r, ConflictQueryParam, string(transfer.Skip))); err != nil {
+		return opts, err
+	}
+	if opts.DryRun, err = strconv.ParseBool(queryOr(r, DryRunQueryParam, "false")); err != nil {
+		return opts, fmt.Errorf("%s must be true or false", DryRunQueryParam)
+	}
+	return opts, nil
+}
+
+func queryOr(r *http.Request, key, fallback string) string {
+	if value := r.URL.Query().Get(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("content-type", JsonContentType)
+	w.Header().Set("Allow", allow)
+	errResponse := NewErrorResponse(http.StatusMethodNotAllowed, ERR_METHOD_NOT_ALLOWED, ERR_METHOD_NOT_ALLOWED_CODE, r.Method+" is not supported")
+	errResponse.WriteError(w)
+}
+
+// writeTracker notes whether anything reached the response, after which an
+// error status can no longer be sent.
+type writeTracker struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *writeTracker) Write(b []byte) (int, error) {
+	t.written = true
+	return t.w.Write(b)
+}
@@ -0,0 +1,201 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+func TestExport(t *testing.T) {
+	newStore := func() *FakeStore {
+		return &FakeStore{
+			urls: map[string]string{"abc123": "https://example.com", "def456": "https://example.org"},
+			info: map[string]link.Info{"abc123": {Title: "Example"}},
+		}
+	}
+
+	t.Run("GET /admin/export streams JSON by default", func(t *testing.T) {
+		server := handler.NewExport(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.ExportPath, nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.JsonContentType)
+		if got := response.Header().Get("Content-Disposition"); got != `attachment; filename="links.json"` {
+			t.Errorf("got Content-Disposition %q", got)
+		}
+
+		got, err := link.Decode(response.Body)
+		assertNoErr(t, err)
+		if len(got) != 2 || got[0].Code != "abc123" || got[0].Title != "Example" || got[1].Code != "def456" {
+			t.Errorf("got %+v", got)
+		}
+	})
+
+	t.Run("GET /admin/export?format=csv writes CSV", func(t *testing.T) {
+		server := handler.NewExport(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.ExportPath+"?format=csv", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+		assertContentType(t, response.Result().Header.Get("content-type"), transfer.CSV.ContentType())
+		lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+		if len(lines) != 3 || !strings.HasPrefix(lines[1], "abc123,https://example.com,Example,") {
+			t.Errorf("got %q", lines)
+		}
+	})
+
+	t.Run("GET /admin/export?format=xml is rejected", func(t *testing.T) {
+		server := handler.NewExport(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.ExportPath+"?format=xml", nil))
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+		got, err := getErrorResponse(response.Body)
+		assertNoErr(t, err)
+		assertErrMessage(t, got.Error, handler.ERR_TRANSFER_OPTIONS)
+	})
+
+	t.Run("GET /admin/export reports a failing store", func(t *testing.T) {
+		store := newStore()
+		store.err = errors.New("connection refused")
+		server := handler.NewExport(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.ExportPath, nil))
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+		assertContentType(t, response.Result().Header.Get("content-type"), handler.JsonContentType)
+	})
+}
+
+func TestImport(t *testing.T) {
+	const body = `{"code":"abc123","url":"https://new.example"}
+{"code":"def456","url":"https://example.org","title":"Imported"}
+`
+	newStore := func() *FakeStore {
+		return &FakeStore{urls: map[string]string{"abc123": "https://example.com"}, info: map[string]link.Info{}}
+	}
+
+	t.Run("POST /admin/import saves links and reports", func(t *testing.T) {
+		store := newStore()
+		server := handler.NewImport(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath+"?format=ndjson&conflict=overwrite", strings.NewReader(body)))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		report := decodeReport(t, response)
+		if report.Total != 2 || report.Created != 1 || report.Overwritten != 1 {
+			t.Errorf("got report %+v", report)
+		}
+		if store.urls["abc123"] != "https://new.example" || store.info["def456"].Title != "Imported" {
+			t.Errorf("got urls %v and info %v", store.urls, store.info)
+		}
+	})
+
+	t.Run("POST /admin/import?dry_run=true leaves the store alone", func(t *testing.T) {
+		store := newStore()
+		server := handler.NewImport(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath+"?format=ndjson&dry_run=true", strings.NewReader(body)))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		report := decodeReport(t, response)
+		if !report.DryRun || report.Created != 1 || report.Skipped != 1 {
+			t.Errorf("got report %+v", report)
+		}
+		if len(store.urls) != 1 {
+			t.Errorf("dry run saved links: %v", store.urls)
+		}
+	})
+
+	t.Run("POST /admin/import rejects unknown options", func(t *testing.T) {
+		for _, query := range []string{"?format=xml", "?conflict=merge", "?dry_run=maybe"} {
+			server := handler.NewImport(newStore())
+			response := httptest.NewRecorder()
+
+			server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath+query, strings.NewReader(body)))
+			assertStatusCode(t, response.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("POST /admin/import rejects malformed input", func(t *testing.T) {
+		server := handler.NewImport(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath, strings.NewReader("{not json")))
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+		got, err := getErrorResponse(response.Body)
+		assertNoErr(t, err)
+		assertErrMessage(t, got.Error, handler.ERR_IMPORT_FAILURE)
+	})
+
+	t.Run("POST /admin/import reports a failing store", func(t *testing.T) {
+		store := newStore()
+		store.err = errors.New("connection refused")
+		server := handler.NewImport(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath+"?format=ndjson", strings.NewReader(body)))
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+	})
+
+	t.Run("POST /admin/import reports what it wrote before the store failed", func(t *testing.T) {
+		store := failingSaveStore{newStore(), "def456"}
+		server := handler.NewImport(store)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.ImportPath+"?format=ndjson", strings.NewReader(body)))
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+
+		var got handler.ImportFailureResponse
+		assertNoErr(t, json.NewDecoder(response.Body).Decode(&got))
+		assertErrMessage(t, got.Error, handler.ERR_IMPORT_FAILURE)
+		if got.Report.Total != 2 || got.Report.Skipped != 1 {
+			t.Errorf("got report %+v", got.Report)
+		}
+	})
+
+	t.Run("GET /admin/import is not allowed", func(t *testing.T) {
+		server := handler.NewImport(newStore())
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.ImportPath, nil))
+		assertStatusCode(t, response.Code, http.StatusMethodNotAllowed)
+		if got := response.Header().Get("Allow"); got != http.MethodPost {
+			t.Errorf("got Allow %q, want %q", got, http.MethodPost)
+		}
+	})
+}
+
+// failingSaveStore fails to save code, as a store going down part way
+// through an import would.
+type failingSaveStore struct {
+	*FakeStore
+	code string
+}
+
+func (s failingSaveStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	if shortCode == s.code {
+		return errors.New("connection refused")
+	}
+	return s.FakeStore.Save(ctx, shortCode, originalUrl)
+}
+
+func decodeReport(t testing.TB, response *httptest.ResponseRecorder) transfer.Report {
+	t.Helper()
+	var report transfer.Report
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return report
+}
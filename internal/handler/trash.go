@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/audit"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	TrashPath             = "/admin/trash"
+	restoreAction         = "restore"
+	DefaultTrashRetention = 30 * 24 * time.Hour
+)
+
+// Trash soft deletes and restores links:
+//
+//	GET  /admin/trash                lists trashed links
+//	POST /admin/trash/<code>         moves a link to the trash
+//	POST /admin/trash/<code>/restore takes it out again
+//
+// A trashed link answers 410 Gone and can be restored for the retention
+// window. It is never removed from the store, so its code stays reserved
+// and is not handed out to another destination.
+type Trash struct {
+	store     storage.URLStore
+	retention time.Duration
+	auditSink audit.Sink
+	baseURL   string
+}
+
+func NewTrash(store storage.URLStore, retention time.Duration) *Trash {
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	return &Trash{store, retention, audit.Discard, DefaultBaseURL}
+}
+
+// WithBaseURL builds short URLs under baseURL, as parsed by ParseBaseURL.
+func (t *Trash) WithBaseURL(baseURL string) *Trash {
+	t.baseURL = baseURL
+	return t
+}
+
+// WithAudit records every link t trashes or restores in sink.
+func (t *Trash) WithAudit(sink audit.Sink) *Trash {
+	t.auditSink = sink
+	return t
+}
+
+func (t *Trash) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "Trash.ServeHTTP")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	w.Header().Set("content-type", JsonContentType)
+	shortCode, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, TrashPath), "/"), "/")
+	if shortCode == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		t.list(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	logging.SetShortCode(ctx, shortCode)
+	span.SetAttributes(attribute.String("shortener.short_code", shortCode))
+	switch action {
+	case "":
+		t.trash(w, r, shortCode)
+	case restoreAction:
+		t.restore(w, r, shortCode)
+	default:
+		errResponse := NewErrorResponse(http.StatusNotFound, ERR_SHORT_CODE_NOT_FOUND, ERR_SHORT_CODE_NOT_FOUND_CODE, "unknown trash action "+action)
+		errResponse.WriteError(w)
+	}
+}
+
+func (t *Trash) list(w http.ResponseWriter, r *http.Request) {
+	links, err := t.store.List(r.Context(), storage.ListFilter{Tag: r.URL.Query().Get(TagQueryParam), Trash: storage.OnlyTrash})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list trashed links", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_LOOKUP_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return
+	}
+
+	response := LinkListResponse{Links: make([]LinkResponse, len(links))}
+	for i, lk := range links {
+		response.Links[i] = t.linkResponse(lk)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// trash moves a link to the trash. Trashing it again keeps the original
+// deletion time, so repeating the request cannot extend the window.
+func (t *Trash) trash(w http.ResponseWriter, r *http.Request, shortCode string) {
+	l, ok := lookupLink(w, r, t.store, shortCode)
+	if !ok {
+		return
+	}
+	if !l.Deleted() {
+		before := l
+		now := time.Now()
+		l.DeletedAt, l.UpdatedAt = now, now
+		if !t.save(w, r, l) {
+			return
+		}
+		recordChange(r, t.auditSink, audit.Delete, &before, &l)
+		logging.FromContext(r.Context()).Info("link trashed")
+	}
+	json.NewEncoder(w).Encode(t.linkResponse(l))
+}
+
+func (t *Trash) restore(w http.ResponseWriter, r *http.Request, shortCode string) {
+	l, ok := lookupLink(w, r, t.store, shortCode)
+	if !ok {
+		return
+	}
+	if !l.Deleted() {
+		errResponse := NewErrorResponse(http.StatusConflict, ERR_NOT_IN_TRASH, ERR_NOT_IN_TRASH_CODE, "the link is live")
+		errResponse.WriteError(w)
+		return
+	}
+	if time.Now().After(t.restorableUntil(l)) {
+		errResponse := NewErrorResponse(http.StatusGone, ERR_TRASH_EXPIRED, ERR_TRASH_EXPIRED_CODE, "the link was deleted more than "+t.retention.String()+" ago")
+		errResponse.WriteError(w)
+		return
+	}
+	before := l
+	l.DeletedAt, l.UpdatedAt = time.Time{}, time.Now()
+	if !t.save(w, r, l) {
+		return
+	}
+	recordChange(r, t.auditSink, audit.Restore, &before, &l)
+	logging.FromContext(r.Context()).Info("link restored")
+	json.NewEncoder(w).Encode(t.linkResponse(l))
+}
+
+func (t *Trash) save(w http.ResponseWriter, r *http.Request, l link.Link) bool {
+	if err := t.store.SaveInfo(r.Context(), l.Code, l.Info); err != nil {
+		logging.FromContext(r.Context()).Error("failed to save link", "error", err)
+		errResponse := NewErrorResponse(http.StatusInternalServerError, ERR_STORE_FAILURE, ERR_STORE_FAILURE_CODE, err.Error())
+		errResponse.WriteError(w)
+		return false
+	}
+	return true
+}
+
+func (t *Trash) restorableUntil(l link.Link) time.Time {
+	return l.DeletedAt.Add(t.retention)
+}
+
+func (t *Trash) linkResponse(l link.Link) LinkResponse {
+	response := newLinkResponse(t.baseURL, l)
+	if l.Deleted() {
+		response.RestorableUntil = t.restorableUntil(l)
+	}
+	return response
+}
@@ -0,0 +1,123 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+func TestTrash(t *testing.T) {
+	const retention = time.Hour
+	newStore := func() *FakeStore {
+		return &FakeStore{
+			urls: map[string]string{"abc123": "https://example.com", "def456": "https://example.org", "old789": "https://old.example"},
+			info: map[string]link.Info{
+				"abc123": {Title: "Example"},
+				"def456": {DeletedAt: time.Now().Add(-time.Minute)},
+				"old789": {DeletedAt: time.Now().Add(-2 * retention)},
+			},
+		}
+	}
+
+	t.Run("GET /admin/trash lists trashed links only", func(t *testing.T) {
+		server := handler.NewTrash(newStore(), retention)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, handler.TrashPath, nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got := decodeLinkList(t, response)
+		assertLinkCodes(t, got, "def456", "old789")
+		if want := got[0].DeletedAt.Add(retention); !got[0].RestorableUntil.Equal(want) {
+			t.Errorf("got restorable until %v, want %v", got[0].RestorableUntil, want)
+		}
+	})
+
+	t.Run("GET /links hides trashed links", func(t *testing.T) {
+		response := httptest.NewRecorder()
+
+		handler.NewLinks(newStore()).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/links", nil))
+		assertLinkCodes(t, decodeLinkList(t, response), "abc123")
+	})
+
+	t.Run("POST /admin/trash/abc123 trashes the link", func(t *testing.T) {
+		store := newStore()
+		server := handler.NewTrash(store, retention)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.TrashPath+"/abc123", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		info, _ := store.GetInfo(context.Background(), "abc123")
+		if !info.Deleted() || !info.UpdatedAt.Equal(info.DeletedAt) {
+			t.Errorf("got info %+v", info)
+		}
+		redirect := httptest.NewRecorder()
+		handler.NewRedirector(store).ServeHTTP(redirect, newRedirectRequest("abc123"))
+		assertStatusCode(t, redirect.Code, http.StatusGone)
+	})
+
+	t.Run("POST /admin/trash/def456 again keeps the deletion time", func(t *testing.T) {
+		store := newStore()
+		before, _ := store.GetInfo(context.Background(), "def456")
+		response := httptest.NewRecorder()
+
+		handler.NewTrash(store, retention).ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.TrashPath+"/def456", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		after, _ := store.GetInfo(context.Background(), "def456")
+		if !after.DeletedAt.Equal(before.DeletedAt) {
+			t.Errorf("got deleted at %v, want %v", after.DeletedAt, before.DeletedAt)
+		}
+	})
+
+	t.Run("POST /admin/trash/def456/restore restores the link", func(t *testing.T) {
+		store := newStore()
+		response := httptest.NewRecorder()
+
+		handler.NewTrash(store, retention).ServeHTTP(response, httptest.NewRequest(http.MethodPost, handler.TrashPath+"/def456/restore", nil))
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		var got handler.LinkResponse
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+		if !got.DeletedAt.IsZero() || !got.RestorableUntil.IsZero() || got.URL != "https://example.org" {
+			t.Errorf("got %+v", got)
+		}
+		redirect := httptest.NewRecorder()
+		handler.NewRedirector(store).ServeHTTP(redirect, newRedirectRequest("def456"))
+		assertStatusCode(t, redirect.Code, http.StatusFound)
+	})
+
+	errorCases := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantError  string
+	}{
+		{"restoring a live link conflicts", http.MethodPost, "/abc123/restore", http.StatusConflict, handler.ERR_NOT_IN_TRASH},
+		{"restoring after the retention window is gone", http.MethodPost, "/old789/restore", http.StatusGone, handler.ERR_TRASH_EXPIRED},
+		{"trashing an unknown code is not found", http.MethodPost, "/nope", http.StatusNotFound, handler.ERR_SHORT_CODE_NOT_FOUND},
+		{"DELETE is not allowed", http.MethodDelete, "/abc123", http.StatusMethodNotAllowed, handler.ERR_METHOD_NOT_ALLOWED},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+
+			handler.NewTrash(newStore(), retention).ServeHTTP(response, httptest.NewRequest(tt.method, handler.TrashPath+tt.path, nil))
+			assertStatusCode(t, response.Code, tt.wantStatus)
+
+			got, err := getErrorResponse(response.Body)
+			assertNoErr(t, err)
+			assertErrMessage(t, got.Error, tt.wantError)
+		})
+	}
+}
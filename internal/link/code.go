@@ -0,0 +1,42 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PreviewSuffix ends the path of a short link's preview page, as in /abc123+.
+const PreviewSuffix = "+"
+
+// reservedCodes are the first path segments the server routes itself. A link
+// under one of them could never be reached, or would hide the route.
+var reservedCodes = map[string]bool{
+	"admin":     true,
+	"health":    true,
+	"healthz":   true,
+	"links":     true,
+	"livez":     true,
+	"metrics":   true,
+	"qr":        true,
+	"readyz":    true,
+	"shortener": true,
+}
+
+// ValidateCode returns why code cannot be a short code, or nil if it can.
+// Generated and imported codes alike are checked with it.
+func ValidateCode(code string) error {
+	if code == "" {
+		return errors.New("code must not be empty")
+	}
+	if strings.ContainsAny(code, "/?# ") {
+		return errors.New("code must not contain '/', '?', '#' or spaces")
+	}
+	if strings.HasSuffix(code, PreviewSuffix) {
+		return fmt.Errorf("code must not end in %q, which requests the preview page", PreviewSuffix)
+	}
+	if reservedCodes[strings.ToLower(code)] {
+		return fmt.Errorf("code %q is reserved", code)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package link
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Encoder streams links to w as a JSON array with one link per line.
+type Encoder struct {
+	w       io.Writer
+	written int
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Encode(l Link) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to encode link %q: %w", l.Code, err)
+	}
+	separator := ",\n"
+	if e.written == 0 {
+		separator = "[\n"
+	}
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return fmt.Errorf("failed to write links: %w", err)
+	}
+	if _, err := e.w.Write(b); err != nil {
+		return fmt.Errorf("failed to write links: %w", err)
+	}
+	e.written++
+	return nil
+}
+
+// Close ends the array. It does not close the underlying writer.
+func (e *Encoder) Close() error {
+	end := "\n]\n"
+	if e.written == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(e.w, end); err != nil {
+		return fmt.Errorf("failed to write links: %w", err)
+	}
+	return nil
+}
+
+// Encode writes links as a JSON array ordered by short code.
+func Encode(w io.Writer, links []Link) error {
+	sorted := slices.Clone(links)
+	slices.SortFunc(sorted, func(a, b Link) int { return strings.Compare(a.Code, b.Code) })
+	encoder := NewEncoder(w)
+	for _, l := range sorted {
+		if err := encoder.Encode(l); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+// Decoder reads links one at a time from input written by Encoder. It also
+// accepts the older format of a JSON object mapping short codes to URLs,
+// optionally followed by a second object holding each link's info, which
+// is read in full up front. Empty input holds no links.
+type Decoder struct {
+	r       *bufio.Reader
+	json    *json.Decoder
+	legacy  []Link
+	started bool
+	done    bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next link, or io.EOF once there are none left.
+func (d *Decoder) Next() (Link, error) {
+	if !d.started {
+		d.started = true
+		if err := d.start(); err != nil {
+			d.done = true
+			return Link{}, err
+		}
+	}
+	if d.done {
+		return Link{}, io.EOF
+	}
+	if d.json == nil {
+		if len(d.legacy) == 0 {
+			d.done = true
+			return Link{}, io.EOF
+		}
+		l := d.legacy[0]
+		d.legacy = d.legacy[1:]
+		return l, nil
+	}
+	if !d.json.More() {
+		d.done = true
+		if _, err := d.json.Token(); err != nil {
+			return Link{}, fmt.Errorf("failed to read links: %w", err)
+		}
+		return Link{}, io.EOF
+	}
+	var l Link
+	if err := d.json.Decode(&l); err != nil {
+		d.done = true
+		return Link{}, fmt.Errorf("failed to read links: %w", err)
+	}
+	return l, nil
+}
+
+func (d *Decoder) start() error {
+	first, err := peekNonSpace(d.r)
+	if errors.Is(err, io.EOF) {
+		d.done = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read links: %w", err)
+	}
+	if first == '{' {
+		d.legacy, err = decodeLegacy(json.NewDecoder(d.r))
+		return err
+	}
+
+	d.json = json.NewDecoder(d.r)
+	if token, err := d.json.Token(); err != nil || token != json.Delim('[') {
+		return fmt.Errorf("failed to read links: expected a JSON array, got %v (%v)", token, err)
+	}
+	return nil
+}
+
+// Decode reads every link written by Encode, see Decoder for the formats.
+func Decode(r io.Reader) ([]Link, error) {
+	var links []Link
+	decoder := NewDecoder(r)
+	for {
+		l, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+}
+
+// legacyInfo is how link info was written before it had JSON field names.
+type legacyInfo struct {
+	Title        string
+	CreatedAt    time.Time
+	Clicks       int
+	Suspicious   bool
+	PasswordHash string
+}
+
+func decodeLegacy(decoder *json.Decoder) ([]Link, error) {
+	var urls map[string]string
+	if err := decoder.Decode(&urls); err != nil {
+		return nil, fmt.Errorf("failed to read urls: %w", err)
+	}
+	var infos map[string]legacyInfo
+	if err := decoder.Decode(&infos); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read link info: %w", err)
+	}
+
+	links := make([]Link, 0, len(urls))
+	for code, url := range urls {
+		info := infos[code]
+		links = append(links, New(code, url, Info{
+			Title:        info.Title,
+			CreatedAt:    info.CreatedAt,
+			Clicks:       info.Clicks,
+			Suspicious:   info.Suspicious,
+			PasswordHash: info.PasswordHash,
+		}))
+	}
+	slices.SortFunc(links, func(a, b Link) int { return strings.Compare(a.Code, b.Code) })
+	return links, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
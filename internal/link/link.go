@@ -0,0 +1,137 @@
+// Package link is the domain model shared by the HTTP handlers and the
+// storage backends.
+package link
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Info is the metadata kept alongside a short code's destination.
+type Info struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"` // normalised by NormaliseTags
+	Creator     string    `json:"creator,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+	Clicks      int       `json:"clicks,omitempty"`
+	Suspicious  bool      `json:"suspicious,omitempty"` // forces an interstitial warning before redirecting
+	// Salted hash of the passphrase required to follow the link, empty if unprotected
+	PasswordHash string `json:"password_hash,omitempty"`
+	// Free-form key/value pairs supplied by the creator
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// When the link was moved to the trash, zero while it is live
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	// Query parameters added to the destination unless it already sets them
+	Query map[string]string `json:"query,omitempty"`
+	// What a redirect does with the query string of the short link request
+	QueryPolicy QueryPolicy `json:"query_policy,omitempty"`
+	// UTM tags added to the destination, keyed by the names in UTMTags
+	UTM map[string]string `json:"utm,omitempty"`
+	// ForwardPath appends whatever follows the short code in the request
+	// path to the destination's path
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// Rules pick another destination for some visitors, first match wins
+	Rules []Rule `json:"rules,omitempty"`
+	// Variants split visitors no rule matched between several destinations
+	Variants []Variant `json:"variants,omitempty"`
+	// StickyVariants keeps a visitor on the variant they were first sent to
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+	// MaxClicks is how many redirects the link serves before it is used up,
+	// zero for no limit
+	MaxClicks int `json:"max_clicks,omitempty"`
+}
+
+// Clone returns a copy of info that shares no tags, metadata, rules or
+// variants with it.
+func (info Info) Clone() Info {
+	info.Tags = slices.Clone(info.Tags)
+	info.Metadata = maps.Clone(info.Metadata)
+	info.Query = maps.Clone(info.Query)
+	info.UTM = maps.Clone(info.UTM)
+	info.Variants = slices.Clone(info.Variants)
+	if info.Rules != nil {
+		rules := make([]Rule, len(info.Rules))
+		for i, rule := range info.Rules {
+			rules[i] = rule.clone()
+		}
+		info.Rules = rules
+	}
+	return info
+}
+
+// HasTag reports whether info carries tag, compared after normalising.
+func (info Info) HasTag(tag string) bool {
+	return slices.Contains(info.Tags, NormaliseTag(tag))
+}
+
+// Equal reports whether info and other hold the same values. Missing and
+// empty tags or metadata are treated alike, as backends may store either.
+func (info Info) Equal(other Info) bool {
+	return info.Title == other.Title &&
+		info.Description == other.Description &&
+		slices.Equal(info.Tags, other.Tags) &&
+		info.Creator == other.Creator &&
+		info.CreatedAt.Equal(other.CreatedAt) &&
+		info.UpdatedAt.Equal(other.UpdatedAt) &&
+		info.Clicks == other.Clicks &&
+		info.Suspicious == other.Suspicious &&
+		info.PasswordHash == other.PasswordHash &&
+		maps.Equal(info.Metadata, other.Metadata) &&
+		info.DeletedAt.Equal(other.DeletedAt) &&
+		maps.Equal(info.Query, other.Query) &&
+		info.QueryPolicy == other.QueryPolicy &&
+		maps.Equal(info.UTM, other.UTM) &&
+		info.ForwardPath == other.ForwardPath &&
+		slices.EqualFunc(info.Rules, other.Rules, Rule.Equal) &&
+		slices.Equal(info.Variants, other.Variants) &&
+		info.StickyVariants == other.StickyVariants &&
+		info.MaxClicks == other.MaxClicks
+}
+
+// Exhausted reports whether the link has served all the clicks it may.
+func (info Info) Exhausted() bool {
+	return info.MaxClicks > 0 && info.Clicks >= info.MaxClicks
+}
+
+// Deleted reports whether the link is in the trash.
+func (info Info) Deleted() bool {
+	return !info.DeletedAt.IsZero()
+}
+
+// NormaliseTags lowercases and trims tags, dropping empty and repeated ones,
+// and returns them sorted. It returns nil when no tags remain.
+func NormaliseTags(tags []string) []string {
+	var normalised []string
+	for _, tag := range tags {
+		if tag = NormaliseTag(tag); tag != "" {
+			normalised = append(normalised, tag)
+		}
+	}
+	slices.Sort(normalised)
+	return slices.Compact(normalised)
+}
+
+// NormaliseTag lowercases and trims a single tag.
+func NormaliseTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// Link is a short code together with its destination and metadata.
+type Link struct {
+	Code string `json:"code"`
+	URL  string `json:"url"`
+	Info
+}
+
+func New(code, url string, info Info) Link {
+	return Link{Code: code, URL: url, Info: info}
+}
+
+// Equal reports whether l and other have the same code, URL and info.
+func (l Link) Equal(other Link) bool {
+	return l.Code == other.Code && l.URL == other.URL && l.Info.Equal(other.Info)
+}
@@ -0,0 +1,505 @@
+package link_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+func TestCodec(t *testing.T) {
+	t.Run("round-trips links in short code order", func(t *testing.T) {
+		links := []link.Link{
+			link.New("xyz789", "https://example.org", link.Info{}),
+			link.New("abc123", "https://example.com", link.Info{
+				Title:        "Example",
+				CreatedAt:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+				Clicks:       4,
+				Suspicious:   true,
+				PasswordHash: "pbkdf2$hash",
+				Description:  "An example link",
+				Tags:         []string{"docs", "example"},
+				Creator:      "alice",
+				UpdatedAt:    time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC),
+				Metadata:     map[string]string{"campaign": "spring"},
+			}),
+		}
+
+		var buf bytes.Buffer
+		if err := link.Encode(&buf, links); err != nil {
+			t.Fatalf("failed to encode: %v", err)
+		}
+		got, err := link.Decode(&buf)
+		if err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+
+		assertLinks(t, got, []link.Link{links[1], links[0]})
+	})
+
+	t.Run("uses snake case field names", func(t *testing.T) {
+		var buf bytes.Buffer
+		link.Encode(&buf, []link.Link{link.New("abc123", "https://example.com", link.Info{Title: "Example"})})
+
+		want := "[\n" + `{"code":"abc123","url":"https://example.com","title":"Example"}` + "\n]"
+		if got := strings.TrimSpace(buf.String()); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("reads the legacy short code to URL format", func(t *testing.T) {
+		legacy := `{"abc123": "https://example.com"}
+{"abc123": {"Title": "Example", "Clicks": 2}}`
+
+		got, err := link.Decode(strings.NewReader(legacy))
+		if err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+
+		assertLinks(t, got, []link.Link{link.New("abc123", "https://example.com", link.Info{Title: "Example", Clicks: 2})})
+	})
+
+	t.Run("streams links one at a time", func(t *testing.T) {
+		var buf bytes.Buffer
+		encoder := link.NewEncoder(&buf)
+		for _, code := range []string{"abc123", "def456"} {
+			if err := encoder.Encode(link.New(code, "https://example.com", link.Info{})); err != nil {
+				t.Fatalf("failed to encode: %v", err)
+			}
+		}
+		encoder.Close()
+
+		decoder := link.NewDecoder(&buf)
+		for _, want := range []string{"abc123", "def456"} {
+			got, err := decoder.Next()
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if got.Code != want {
+				t.Errorf("got %q, want %q", got.Code, want)
+			}
+		}
+		if _, err := decoder.Next(); !errors.Is(err, io.EOF) {
+			t.Errorf("got error %v, want %v", err, io.EOF)
+		}
+	})
+
+	t.Run("encodes no links as an empty array", func(t *testing.T) {
+		var buf bytes.Buffer
+		link.Encode(&buf, nil)
+
+		got, err := link.Decode(&buf)
+		if err != nil || len(got) != 0 {
+			t.Errorf("got %v links and error %v", got, err)
+		}
+	})
+
+	t.Run("empty input holds no links", func(t *testing.T) {
+		got, err := link.Decode(strings.NewReader("  \n"))
+		if err != nil {
+			t.Fatalf("failed to decode: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("got %d links, want none", len(got))
+		}
+	})
+
+	t.Run("rejects malformed input", func(t *testing.T) {
+		for _, input := range []string{`[{"code": `, `{"abc123": `, `"abc123"`} {
+			if _, err := link.Decode(strings.NewReader(input)); err == nil {
+				t.Errorf("decoding %q should fail", input)
+			}
+		}
+	})
+}
+
+func TestTags(t *testing.T) {
+	t.Run("normalises, dedupes and sorts tags", func(t *testing.T) {
+		got := link.NormaliseTags([]string{" News", "docs", "", "news ", "DOCS"})
+		want := []string{"docs", "news"}
+		if !slices.Equal(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got := link.NormaliseTags([]string{" "}); got != nil {
+			t.Errorf("got %q, want nil", got)
+		}
+	})
+
+	t.Run("matches tags after normalising", func(t *testing.T) {
+		info := link.Info{Tags: link.NormaliseTags([]string{"Docs"})}
+		if !info.HasTag(" DOCS ") {
+			t.Error("tag should match regardless of case and spacing")
+		}
+		if info.HasTag("news") {
+			t.Error("tag should not match")
+		}
+	})
+}
+
+func TestValidateCode(t *testing.T) {
+	for _, code := range []string{"abc123", "ABC123", "docs-2", "qrcode"} {
+		if err := link.ValidateCode(code); err != nil {
+			t.Errorf("ValidateCode(%q) = %v, want nil", code, err)
+		}
+	}
+	for _, code := range []string{"", "a/b", "a?b", "a#b", "a b", "abc+", "links", "Metrics", "healthz", "readyz", "qr"} {
+		if link.ValidateCode(code) == nil {
+			t.Errorf("expected %q to be rejected", code)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	t.Run("clones share no tags or metadata", func(t *testing.T) {
+		info := link.Info{Tags: []string{"docs"}, Metadata: map[string]string{"k": "v"}, Query: map[string]string{"q": "v"}, UTM: map[string]string{"source": "v"}}
+		clone := info.Clone()
+		clone.Tags[0], clone.Metadata["k"], clone.Query["q"], clone.UTM["source"] = "changed", "changed", "changed", "changed"
+
+		if info.Tags[0] != "docs" || info.Metadata["k"] != "v" || info.Query["q"] != "v" || info.UTM["source"] != "v" {
+			t.Errorf("original changed through its clone: %+v", info)
+		}
+	})
+
+	t.Run("missing and empty tags or metadata are equal", func(t *testing.T) {
+		if !(link.Info{}).Equal(link.Info{Tags: []string{}, Metadata: map[string]string{}}) {
+			t.Error("empty and missing should be equal")
+		}
+		if (link.Info{}).Equal(link.Info{Tags: []string{"docs"}}) {
+			t.Error("different tags should not be equal")
+		}
+	})
+
+	t.Run("a link is exhausted once it reaches its click limit", func(t *testing.T) {
+		cases := []struct {
+			clicks, maxClicks int
+			want              bool
+		}{
+			{0, 0, false},
+			{100, 0, false},
+			{0, 1, false},
+			{1, 1, true},
+			{4, 3, true},
+		}
+		for _, tt := range cases {
+			if got := (link.Info{Clicks: tt.clicks, MaxClicks: tt.maxClicks}).Exhausted(); got != tt.want {
+				t.Errorf("%d of %d clicks: got exhausted %v, want %v", tt.clicks, tt.maxClicks, got, tt.want)
+			}
+		}
+	})
+}
+
+func assertLinks(t testing.TB, got, want []link.Link) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d links, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("link %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDestination(t *testing.T) {
+	defaults := map[string]string{"source": "shortener", "medium": "link"}
+	cases := []struct {
+		name     string
+		url      string
+		info     link.Info
+		defaults map[string]string
+		request  string
+		want     string
+	}{
+		{
+			name:    "leaves a plain link alone",
+			url:     "https://example.com/a%2Fb?b=2&a=1#top",
+			request: "gclid=abc",
+			want:    "https://example.com/a%2Fb?b=2&a=1#top",
+		},
+		{
+			name: "appends default parameters before the fragment",
+			url:  "https://example.com/page?b=2&a=1#top",
+			info: link.Info{Query: map[string]string{"ref": "short link", "a": "ignored"}},
+			want: "https://example.com/page?b=2&a=1&ref=short+link#top",
+		},
+		{
+			name:     "adds UTM tags, falling back to the defaults",
+			url:      "https://example.com",
+			info:     link.Info{UTM: map[string]string{"campaign": "spring", "medium": "email"}},
+			defaults: defaults,
+			want:     "https://example.com?utm_source=shortener&utm_medium=email&utm_campaign=spring",
+		},
+		{
+			name:     "keeps UTM tags the destination already sets",
+			url:      "https://example.com/?utm_source=partner",
+			info:     link.Info{UTM: map[string]string{"source": "newsletter"}},
+			defaults: defaults,
+			want:     "https://example.com/?utm_source=partner&utm_medium=link",
+		},
+		{
+			name:     "an empty UTM tag turns a default off",
+			url:      "https://example.com/",
+			info:     link.Info{UTM: map[string]string{"source": ""}},
+			defaults: defaults,
+			want:     "https://example.com/?utm_medium=link",
+		},
+		{
+			name:    "merges request parameters the destination lacks",
+			url:     "https://example.com/?a=1",
+			info:    link.Info{QueryPolicy: link.MergeQuery},
+			request: "a=2&tag=x&tag=y&preview&q=caf%C3%A9",
+			want:    "https://example.com/?a=1&tag=x&tag=y&q=caf%C3%A9",
+		},
+		{
+			name:    "lets request parameters override the destination",
+			url:     "https://example.com/?a=1&b=2#frag",
+			info:    link.Info{QueryPolicy: link.OverrideQuery},
+			request: "a=3&a=4",
+			want:    "https://example.com/?b=2&a=3&a=4#frag",
+		},
+		{
+			name:     "request parameters override UTM tags too",
+			url:      "https://example.com/",
+			info:     link.Info{QueryPolicy: link.OverrideQuery},
+			defaults: defaults,
+			request:  "utm_source=ad",
+			want:     "https://example.com/?utm_medium=link&utm_source=ad",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			l := link.New("abc123", tt.url, tt.info)
+			if got := l.Destination(tt.request, tt.defaults, "preview"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUTM(t *testing.T) {
+	got, err := link.ParseUTM("Source=newsletter, utm_medium=email,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]string{"source": "newsletter", "medium": "email"}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, invalid := range []string{"gclid=abc", "source"} {
+		if _, err := link.ParseUTM(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseQueryPolicy(t *testing.T) {
+	for input, want := range map[string]link.QueryPolicy{"": link.DropQuery, "Merge": link.MergeQuery, " override ": link.OverrideQuery} {
+		if got, err := link.ParseQueryPolicy(input); err != nil || got != want {
+			t.Errorf("ParseQueryPolicy(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := link.ParseQueryPolicy("append"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestAppendPath(t *testing.T) {
+	cases := []struct {
+		name   string
+		url    string
+		suffix string
+		want   string
+	}{
+		{"appends to the destination path", "https://example.com/docs", "/install/linux", "https://example.com/docs/install/linux"},
+		{"joins a trailing slash", "https://example.com/docs/", "/install", "https://example.com/docs/install"},
+		{"appends to a bare host", "https://example.com", "/install", "https://example.com/install"},
+		{"keeps the escaping", "https://example.com/a%2Fb", "/caf%C3%A9/x%2Fy", "https://example.com/a%2Fb/caf%C3%A9/x%2Fy"},
+		{"keeps the query and fragment", "https://example.com/docs?lang=en#top", "/install", "https://example.com/docs/install?lang=en#top"},
+		{"keeps a trailing slash of the suffix", "https://example.com/docs", "/install/", "https://example.com/docs/install/"},
+		{"leaves the link alone without a suffix", "https://example.com/docs", "", "https://example.com/docs"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := link.New("abc123", tt.url, link.Info{}).AppendPath(tt.suffix)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.URL != tt.want {
+				t.Errorf("got %q, want %q", got.URL, tt.want)
+			}
+		})
+	}
+
+	for _, unsafe := range []string{"/../admin", "/a/./b", "/%2e%2E/admin", "/a/%2E", "/a%5C..%5Cb", "/a%00", "/%zz", "install"} {
+		if _, err := link.New("abc123", "https://example.com/docs", link.Info{}).AppendPath(unsafe); !errors.Is(err, link.ErrUnsafePath) {
+			t.Errorf("AppendPath(%q) returned %v, want ErrUnsafePath", unsafe, err)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	// a Monday, 10:30 in Paris
+	monday := time.Date(2025, 6, 2, 8, 30, 0, 0, time.UTC)
+	rules, err := link.NormaliseRules([]link.Rule{
+		{URL: "https://apps.apple.com/app/id1", Devices: []string{"iOS"}},
+		{URL: "https://play.google.com/store/apps/details?id=app", Devices: []string{"android"}},
+		{URL: "https://example.com/office", Countries: []string{"fr"}, Days: []string{"Monday", "tue"}, Hours: "09:00-17:00", TimeZone: "Europe/Paris"},
+		{URL: "https://example.com/late", Hours: "22:00-06:00"},
+		{URL: "https://example.com/de", Languages: []string{"de"}},
+		{URL: "https://example.com/sale", Start: monday.Add(-time.Hour), End: monday.Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l := link.New("abc123", "https://example.com", link.Info{Rules: rules})
+
+	cases := []struct {
+		name    string
+		visitor link.Visitor
+		want    string
+	}{
+		{"iOS", link.Visitor{Device: link.DeviceIOS, At: monday}, "https://apps.apple.com/app/id1"},
+		{"Android", link.Visitor{Device: link.DeviceAndroid, At: monday}, "https://play.google.com/store/apps/details?id=app"},
+		{"France in office hours", link.Visitor{Device: link.DeviceDesktop, Country: "FR", At: monday}, "https://example.com/office"},
+		{"France out of office hours", link.Visitor{Device: link.DeviceDesktop, Country: "FR", At: monday.Add(9 * time.Hour)}, "https://example.com"},
+		{"France at the weekend", link.Visitor{Device: link.DeviceDesktop, Country: "FR", At: monday.Add(-48 * time.Hour)}, "https://example.com"},
+		{"a window past midnight", link.Visitor{Device: link.DeviceDesktop, At: monday.Add(-5 * time.Hour)}, "https://example.com/late"},
+		{"a regional language", link.Visitor{Device: link.DeviceDesktop, Language: "de-at", At: monday.Add(4 * time.Hour)}, "https://example.com/de"},
+		{"a similar language", link.Visitor{Device: link.DeviceDesktop, Language: "dev", At: monday.Add(4 * time.Hour)}, "https://example.com"},
+		{"a time range", link.Visitor{Device: link.DeviceDesktop, At: monday}, "https://example.com/sale"},
+		{"the end of a time range", link.Visitor{Device: link.DeviceDesktop, At: monday.Add(time.Hour)}, "https://example.com"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := l.Route(tt.visitor); got.URL != tt.want {
+				t.Errorf("got %q, want %q", got.URL, tt.want)
+			}
+		})
+	}
+
+	if mobile := (link.Rule{Devices: []string{link.DeviceMobile}}); !mobile.Matches(link.Visitor{Device: link.DeviceIOS}) {
+		t.Error("a mobile rule should match iOS")
+	}
+}
+
+func TestNormaliseRules(t *testing.T) {
+	got, err := link.NormaliseRules([]link.Rule{{URL: " https://example.fr ", Devices: []string{" Desktop"}, Countries: []string{"fr"},
+		Languages: []string{"pt_BR"}, Days: []string{"Sat", "sunday"}, Hours: "09:00 - 17:30"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := link.Rule{URL: "https://example.fr", Devices: []string{"desktop"}, Countries: []string{"FR"},
+		Languages: []string{"pt-br"}, Days: []string{"sat", "sun"}, Hours: "09:00-17:30"}
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	start := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	invalid := map[string]link.Rule{
+		"no conditions":       {URL: "https://example.com"},
+		"a relative URL":      {URL: "/elsewhere", Devices: []string{"ios"}},
+		"a javascript URL":    {URL: "javascript:alert(1)", Devices: []string{"ios"}},
+		"an unknown device":   {URL: "https://example.com", Devices: []string{"watch"}},
+		"a long country":      {URL: "https://example.com", Countries: []string{"FRA"}},
+		"a bad language":      {URL: "https://example.com", Languages: []string{"1"}},
+		"an unknown day":      {URL: "https://example.com", Days: []string{"someday"}},
+		"open-ended hours":    {URL: "https://example.com", Hours: "09:00"},
+		"an empty window":     {URL: "https://example.com", Hours: "09:00-09:00"},
+		"a bad time":          {URL: "https://example.com", Hours: "9-17:00"},
+		"an unknown timezone": {URL: "https://example.com", Hours: "09:00-17:00", TimeZone: "Mars/Olympus"},
+		"an inverted range":   {URL: "https://example.com", Start: start, End: start.Add(-time.Hour)},
+	}
+	for name, rule := range invalid {
+		if _, err := link.NormaliseRules([]link.Rule{rule}); err == nil {
+			t.Errorf("expected a rule with %s to be rejected", name)
+		}
+	}
+}
+
+func TestDeviceFromUserAgent(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148":     link.DeviceIOS,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36": link.DeviceAndroid,
+		"Mozilla/5.0 (Mobile; rv:48.0) Gecko/48.0 Firefox/48.0 KAIOS/2.5":                               link.DeviceMobile,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36":       link.DeviceDesktop,
+		"": link.DeviceDesktop,
+	}
+	for userAgent, want := range cases {
+		if got := link.DeviceFromUserAgent(userAgent); got != want {
+			t.Errorf("DeviceFromUserAgent(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	cases := map[string]string{
+		"fr-CH, fr;q=0.9, en;q=0.8": "fr-ch",
+		"en;q=0.5, de_DE":           "de-de",
+		"*, es;q=0.1":               "es",
+		"en;q=0, it;q=abc":          "",
+		"":                          "",
+	}
+	for header, want := range cases {
+		if got := link.PreferredLanguage(header); got != want {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	variants, err := link.NormaliseVariants([]link.Variant{
+		{URL: "https://example.com/a", Weight: 3},
+		{Name: " New ", URL: "https://example.com/b", Weight: 1},
+		{URL: "https://example.com/c"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info := link.Info{Variants: variants}
+	if info.VariantWeight() != 4 {
+		t.Errorf("got total weight %d, want 4", info.VariantWeight())
+	}
+	for n, want := range map[int]string{0: "a", 2: "a", 3: "new"} {
+		if got := info.PickVariant(n); got.Name != want {
+			t.Errorf("PickVariant(%d) = %q, want %q", n, got.Name, want)
+		}
+	}
+	if v, ok := info.Variant("c"); !ok || v.URL != "https://example.com/c" {
+		t.Errorf("got variant %+v, %v", v, ok)
+	}
+
+	counted := info.Clone()
+	counted.CountVariantClick("new")
+	counted.CountVariantClick("unknown")
+	if counted.Clicks != 2 || counted.Variants[1].Clicks != 1 || info.Variants[1].Clicks != 0 {
+		t.Errorf("got %d clicks split %+v", counted.Clicks, counted.Variants)
+	}
+
+	edited := link.Info{Variants: []link.Variant{{Name: "new", Clicks: 9}, {Name: "d", Clicks: 9}}}.KeepClicks(counted)
+	if edited.Clicks != 2 || edited.Variants[0].Clicks != 1 || edited.Variants[1].Clicks != 0 {
+		t.Errorf("got %d clicks split %+v after keeping the stored clicks", edited.Clicks, edited.Variants)
+	}
+	edited.AddClicks(3, map[string]int{"d": 2, "unknown": 5})
+	if edited.Clicks != 5 || edited.Variants[0].Clicks != 1 || edited.Variants[1].Clicks != 2 {
+		t.Errorf("got %d clicks split %+v after adding clicks", edited.Clicks, edited.Variants)
+	}
+
+	invalid := map[string][]link.Variant{
+		"a single variant":  {{URL: "https://example.com/a", Weight: 1}},
+		"no weights":        {{URL: "https://example.com/a"}, {URL: "https://example.com/b"}},
+		"a negative weight": {{URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: -1}},
+		"a repeated name":   {{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "A", URL: "https://example.com/b", Weight: 1}},
+		"a bad name":        {{Name: "a;b", URL: "https://example.com/a", Weight: 1}, {URL: "https://example.com/b", Weight: 1}},
+		"a relative URL":    {{URL: "/a", Weight: 1}, {URL: "https://example.com/b", Weight: 1}},
+	}
+	for name, variants := range invalid {
+		if _, err := link.NormaliseVariants(variants); err == nil {
+			t.Errorf("expected a split with %s to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrUnsafePath is returned for path suffixes that could climb out of the
+// destination's path.
+var ErrUnsafePath = errors.New("unsafe path suffix")
+
+// AppendPath returns l with suffix, an escaped path starting with "/",
+// appended to the path of its URL. The suffix keeps its escaping, so an
+// encoded slash stays part of its segment. Suffixes with "." or ".."
+// segments or backslashes, encoded or not, are rejected with ErrUnsafePath.
+func (l Link) AppendPath(suffix string) (Link, error) {
+	if suffix == "" {
+		return l, nil
+	}
+	if !strings.HasPrefix(suffix, "/") {
+		return l, fmt.Errorf("%w: %q does not start with /", ErrUnsafePath, suffix)
+	}
+	for segment := range strings.SplitSeq(suffix[1:], "/") {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return l, fmt.Errorf("%w: %w", ErrUnsafePath, err)
+		}
+		if decoded == "." || decoded == ".." || strings.ContainsAny(decoded, "\\\x00") {
+			return l, fmt.Errorf("%w: %q", ErrUnsafePath, suffix)
+		}
+	}
+
+	destination, err := url.Parse(l.URL)
+	if err != nil {
+		return l, fmt.Errorf("invalid destination %q: %w", l.URL, err)
+	}
+	escaped := strings.TrimSuffix(destination.EscapedPath(), "/") + suffix
+	if destination.Path, err = url.PathUnescape(escaped); err != nil {
+		return l, fmt.Errorf("%w: %w", ErrUnsafePath, err)
+	}
+	destination.RawPath = escaped
+	l.URL = destination.String()
+	return l, nil
+}
@@ -0,0 +1,161 @@
+package link
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// QueryPolicy decides what a redirect does with the query string of the
+// request for the short link.
+type QueryPolicy string
+
+const (
+	// DropQuery ignores the request's query string. It is the default.
+	DropQuery QueryPolicy = "drop"
+	// MergeQuery adds the request's parameters the destination does not set
+	MergeQuery QueryPolicy = "merge"
+	// OverrideQuery lets the request's parameters replace the destination's
+	OverrideQuery QueryPolicy = "override"
+)
+
+// ParseQueryPolicy reads a policy, treating an empty one as DropQuery.
+func ParseQueryPolicy(s string) (QueryPolicy, error) {
+	switch policy := QueryPolicy(strings.ToLower(strings.TrimSpace(s))); policy {
+	case "":
+		return DropQuery, nil
+	case DropQuery, MergeQuery, OverrideQuery:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown query policy %q, want drop, merge or override", s)
+}
+
+// UTMTags are the UTM tags a link can carry, added to the destination as
+// utm_<tag> in this order.
+var UTMTags = []string{"source", "medium", "campaign", "term", "content"}
+
+const utmPrefix = "utm_"
+
+// NormaliseUTM keys tags by their names in UTMTags, accepting them with or
+// without the utm_ prefix, and rejects unknown tags. An empty value keeps a
+// default for that tag off the link. It returns nil when there are no tags.
+func NormaliseUTM(tags map[string]string) (map[string]string, error) {
+	var normalised map[string]string
+	for key, value := range tags {
+		name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(key)), utmPrefix)
+		if !slices.Contains(UTMTags, name) {
+			return nil, fmt.Errorf("unknown UTM tag %q, want one of %s", key, strings.Join(UTMTags, ", "))
+		}
+		if normalised == nil {
+			normalised = make(map[string]string)
+		}
+		normalised[name] = strings.TrimSpace(value)
+	}
+	return normalised, nil
+}
+
+// ParseUTM reads UTM tags written as "source=newsletter,medium=email".
+func ParseUTM(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for pair := range strings.SplitSeq(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("UTM tag %q is not name=value", pair)
+		}
+		tags[key] = value
+	}
+	return NormaliseUTM(tags)
+}
+
+// Destination is where a redirect sends the visitor: l.URL with l.Query and
+// the UTM tags added where the URL does not already set them, then the
+// parameters of requestQuery, a raw query string, applied as l.QueryPolicy
+// says. A tag missing from l.UTM falls back to defaultUTM. Request
+// parameters named in ignore are never passed on.
+//
+// Parameters already in l.URL keep their order and encoding and the fragment
+// is kept. A URL that does not parse is returned as it is.
+func (l Link) Destination(requestQuery string, defaultUTM map[string]string, ignore ...string) string {
+	destination, err := url.Parse(l.URL)
+	if err != nil {
+		return l.URL
+	}
+	params := splitQuery(destination.RawQuery)
+	changed := false
+	add := func(key, value string) {
+		if !slices.ContainsFunc(params, func(p queryParam) bool { return p.key == key }) {
+			params = append(params, queryParam{key, url.QueryEscape(key) + "=" + url.QueryEscape(value)})
+			changed = true
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(l.Query)) {
+		add(key, l.Query[key])
+	}
+	for _, tag := range UTMTags {
+		value, ok := l.UTM[tag]
+		if !ok {
+			value = defaultUTM[tag]
+		}
+		if value != "" {
+			add(utmPrefix+tag, value)
+		}
+	}
+
+	if l.QueryPolicy == MergeQuery || l.QueryPolicy == OverrideQuery {
+		request := slices.DeleteFunc(splitQuery(requestQuery), func(p queryParam) bool {
+			return slices.Contains(ignore, p.key)
+		})
+		fromRequest := func(p queryParam) bool {
+			return slices.ContainsFunc(request, func(r queryParam) bool { return r.key == p.key })
+		}
+		if l.QueryPolicy == OverrideQuery {
+			params = slices.DeleteFunc(params, fromRequest)
+		}
+		// repeated request parameters all pass through, so check against the
+		// destination's parameters before any are added
+		kept := slices.Clone(params)
+		for _, p := range request {
+			if !slices.ContainsFunc(kept, func(k queryParam) bool { return k.key == p.key }) {
+				params = append(params, p)
+			}
+		}
+		changed = changed || len(request) > 0
+	}
+
+	if !changed {
+		return l.URL
+	}
+	raw := make([]string, len(params))
+	for i, p := range params {
+		raw[i] = p.raw
+	}
+	destination.RawQuery = strings.Join(raw, "&")
+	return destination.String()
+}
+
+// queryParam is one key=value segment of a query string, kept as it was
+// written so rebuilding the query does not re-encode it.
+type queryParam struct {
+	key string
+	raw string
+}
+
+func splitQuery(rawQuery string) []queryParam {
+	var params []queryParam
+	for segment := range strings.SplitSeq(rawQuery, "&") {
+		if segment == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(segment, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		params = append(params, queryParam{key, segment})
+	}
+	return params
+}
@@ -0,0 +1,299 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Devices a rule can match, as told apart by DeviceFromUserAgent. A rule for
+// DeviceMobile also matches iOS and Android devices.
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+)
+
+var devices = []string{DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop}
+
+// weekdays are indexed by time.Weekday
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Rule sends visitors who meet every condition it sets to URL instead of the
+// link's own destination. Conditions listing several values match any of
+// them.
+type Rule struct {
+	URL       string   `json:"url"`
+	Devices   []string `json:"devices,omitempty"`
+	Countries []string `json:"countries,omitempty"` // ISO 3166-1 alpha-2 codes
+	// Language tags matched against the visitor's preferred language, where
+	// "en" also matches "en-gb"
+	Languages []string `json:"languages,omitempty"`
+	// Days of the week, as mon to sun, and a daily window like "09:00-17:00"
+	// that may run past midnight, both in TimeZone, or UTC if it is empty
+	Days     []string `json:"days,omitempty"`
+	Hours    string   `json:"hours,omitempty"`
+	TimeZone string   `json:"time_zone,omitempty"`
+	// The rule only applies from Start and until End when they are set
+	Start time.Time `json:"start,omitzero"`
+	End   time.Time `json:"end,omitzero"`
+}
+
+// Visitor is what rules are matched against.
+type Visitor struct {
+	Device   string
+	Country  string // upper-case, empty when unknown
+	Language string // the most preferred, lower-case, empty when unknown
+	At       time.Time
+}
+
+// Route returns l pointed at the URL of the first of its rules v matches,
+// reporting whether one did.
+func (l Link) Route(v Visitor) (Link, bool) {
+	for _, rule := range l.Rules {
+		if rule.Matches(v) {
+			l.URL = rule.URL
+			return l, true
+		}
+	}
+	return l, false
+}
+
+// Matches reports whether v meets every condition of r. Rules are expected
+// to have been normalised by NormaliseRules.
+func (r Rule) Matches(v Visitor) bool {
+	if len(r.Devices) > 0 && !slices.ContainsFunc(r.Devices, func(device string) bool {
+		return device == v.Device || (device == DeviceMobile && (v.Device == DeviceIOS || v.Device == DeviceAndroid))
+	}) {
+		return false
+	}
+	if len(r.Countries) > 0 && !slices.Contains(r.Countries, v.Country) {
+		return false
+	}
+	if len(r.Languages) > 0 && !slices.ContainsFunc(r.Languages, func(language string) bool {
+		return v.Language == language || strings.HasPrefix(v.Language, language+"-")
+	}) {
+		return false
+	}
+	if (!r.Start.IsZero() && v.At.Before(r.Start)) || (!r.End.IsZero() && !v.At.Before(r.End)) {
+		return false
+	}
+	if len(r.Days) == 0 && r.Hours == "" {
+		return true
+	}
+	location, err := loadLocation(r.TimeZone)
+	if err != nil {
+		return false
+	}
+	at := v.At.In(location)
+	if len(r.Days) > 0 && !slices.Contains(r.Days, weekdays[at.Weekday()]) {
+		return false
+	}
+	if r.Hours != "" {
+		from, to, err := parseHours(r.Hours)
+		if err != nil {
+			return false
+		}
+		minute := at.Hour()*60 + at.Minute()
+		if from < to {
+			return from <= minute && minute < to
+		}
+		return minute >= from || minute < to
+	}
+	return true
+}
+
+// Equal reports whether r and other hold the same values.
+func (r Rule) Equal(other Rule) bool {
+	return r.URL == other.URL &&
+		slices.Equal(r.Devices, other.Devices) &&
+		slices.Equal(r.Countries, other.Countries) &&
+		slices.Equal(r.Languages, other.Languages) &&
+		slices.Equal(r.Days, other.Days) &&
+		r.Hours == other.Hours &&
+		r.TimeZone == other.TimeZone &&
+		r.Start.Equal(other.Start) &&
+		r.End.Equal(other.End)
+}
+
+func (r Rule) clone() Rule {
+	r.Devices = slices.Clone(r.Devices)
+	r.Countries = slices.Clone(r.Countries)
+	r.Languages = slices.Clone(r.Languages)
+	r.Days = slices.Clone(r.Days)
+	return r
+}
+
+// NormaliseRules checks rules and returns them with their values in the case
+// Matches compares them in. It returns nil when there are no rules.
+func NormaliseRules(rules []Rule) ([]Rule, error) {
+	var normalised []Rule
+	for i, rule := range rules {
+		rule, err := normaliseRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		normalised = append(normalised, rule)
+	}
+	return normalised, nil
+}
+
+func normaliseRule(r Rule) (Rule, error) {
+	r = r.clone()
+	destination, err := url.Parse(strings.TrimSpace(r.URL))
+	if err != nil || (destination.Scheme != "http" && destination.Scheme != "https") || destination.Host == "" {
+		return r, fmt.Errorf("url %q is not an absolute http or https URL", r.URL)
+	}
+	r.URL = destination.String()
+
+	for i, device := range r.Devices {
+		r.Devices[i] = strings.ToLower(strings.TrimSpace(device))
+		if !slices.Contains(devices, r.Devices[i]) {
+			return r, fmt.Errorf("unknown device %q, want one of %s", device, strings.Join(devices, ", "))
+		}
+	}
+	for i, country := range r.Countries {
+		r.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+		if len(r.Countries[i]) != 2 || !isLetters(r.Countries[i]) {
+			return r, fmt.Errorf("country %q is not a two-letter code", country)
+		}
+	}
+	for i, language := range r.Languages {
+		r.Languages[i] = normaliseLanguage(language)
+		primary, _, _ := strings.Cut(r.Languages[i], "-")
+		if len(primary) < 2 || len(primary) > 8 || !isLetters(primary) {
+			return r, fmt.Errorf("language %q is not a language tag", language)
+		}
+	}
+	for i, day := range r.Days {
+		r.Days[i] = strings.ToLower(strings.TrimSpace(day))
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			if r.Days[i] == strings.ToLower(weekday.String()) {
+				r.Days[i] = weekdays[weekday]
+			}
+		}
+		if !slices.Contains(weekdays, r.Days[i]) {
+			return r, fmt.Errorf("unknown day %q, want mon to sun", day)
+		}
+	}
+	if r.Hours = strings.ReplaceAll(r.Hours, " ", ""); r.Hours != "" {
+		if _, _, err := parseHours(r.Hours); err != nil {
+			return r, err
+		}
+	}
+	if r.TimeZone = strings.TrimSpace(r.TimeZone); r.TimeZone != "" {
+		if _, err := loadLocation(r.TimeZone); err != nil {
+			return r, fmt.Errorf("unknown time zone %q", r.TimeZone)
+		}
+	}
+	if !r.Start.IsZero() && !r.End.IsZero() && !r.Start.Before(r.End) {
+		return r, errors.New("start must be before end")
+	}
+
+	if len(r.Devices) == 0 && len(r.Countries) == 0 && len(r.Languages) == 0 && len(r.Days) == 0 &&
+		r.Hours == "" && r.Start.IsZero() && r.End.IsZero() {
+		return r, errors.New("a rule needs at least one condition")
+	}
+	return r, nil
+}
+
+// parseHours reads a window like "09:00-17:00" as minutes since midnight.
+func parseHours(hours string) (from, to int, err error) {
+	start, end, ok := strings.Cut(hours, "-")
+	if ok {
+		from, err = parseClock(start)
+	}
+	if ok && err == nil {
+		to, err = parseClock(end)
+	}
+	if !ok || err != nil || from == to {
+		return 0, 0, fmt.Errorf("hours %q are not a window like 09:00-17:00", hours)
+	}
+	return from, to, nil
+}
+
+func parseClock(s string) (int, error) {
+	hour, minute, ok := strings.Cut(s, ":")
+	h, err := strconv.Atoi(hour)
+	if err != nil || !ok || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m, err := strconv.Atoi(minute)
+	if err != nil || len(minute) != 2 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// locations caches time zones, which are otherwise read from disk on every
+// lookup.
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// DeviceFromUserAgent tells the kind of device a User-Agent header comes
+// from, taking anything it does not know as a desktop.
+func DeviceFromUserAgent(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return DeviceIOS
+	case strings.Contains(userAgent, "Android"):
+		return DeviceAndroid
+	case strings.Contains(userAgent, "Mobile"), strings.Contains(userAgent, "Opera Mini"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// PreferredLanguage returns the language an Accept-Language header ranks
+// highest, lower-cased, or an empty string if it names none.
+func PreferredLanguage(acceptLanguage string) string {
+	preferred, best := "", 0.0
+	for entry := range strings.SplitSeq(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = normaliseLanguage(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		// earlier languages win ties
+		if quality > best {
+			preferred, best = tag, quality
+		}
+	}
+	return preferred
+}
+
+func normaliseLanguage(tag string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), "_", "-")
+}
+
+// isLetters reports whether s is made of ASCII letters only.
+func isLetters(s string) bool {
+	return !strings.ContainsFunc(strings.ToLower(s), func(r rune) bool { return r < 'a' || r > 'z' })
+}
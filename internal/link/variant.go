@@ -0,0 +1,129 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	MaxVariants = 10
+	// MaxVariantWeight bounds the weight of a single variant
+	MaxVariantWeight = 1000
+	maxVariantName   = 32
+)
+
+// Variant is one of the destinations a link splits its visitors between, in
+// proportion to its weight. A variant with no weight gets no new visitors.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int    `json:"clicks,omitempty"`
+}
+
+// Variant returns the variant of info called name.
+func (info Info) Variant(name string) (Variant, bool) {
+	i := slices.IndexFunc(info.Variants, func(v Variant) bool { return v.Name == name })
+	if i < 0 {
+		return Variant{}, false
+	}
+	return info.Variants[i], true
+}
+
+// VariantWeight is the sum of the weights of info's variants.
+func (info Info) VariantWeight() int {
+	total := 0
+	for _, v := range info.Variants {
+		total += v.Weight
+	}
+	return total
+}
+
+// PickVariant returns the variant n falls on when the variants are laid end
+// to end by weight, for n from 0 up to VariantWeight.
+func (info Info) PickVariant(n int) Variant {
+	for _, v := range info.Variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return info.Variants[len(info.Variants)-1]
+}
+
+// CountVariantClick adds a click to info and to its variant called name, if
+// it has one.
+func (info *Info) CountVariantClick(name string) {
+	info.Clicks++
+	if i := slices.IndexFunc(info.Variants, func(v Variant) bool { return v.Name == name }); i >= 0 {
+		info.Variants = slices.Clone(info.Variants)
+		info.Variants[i].Clicks++
+	}
+}
+
+// KeepClicks returns info with the click counters of stored, the info it
+// replaces. Variants keep the clicks of the stored variant with the same name
+// and new variants start from none.
+func (info Info) KeepClicks(stored Info) Info {
+	info.Clicks = stored.Clicks
+	info.Variants = slices.Clone(info.Variants)
+	for i := range info.Variants {
+		previous, _ := stored.Variant(info.Variants[i].Name)
+		info.Variants[i].Clicks = previous.Clicks
+	}
+	return info
+}
+
+// AddClicks adds clicks to info and variantClicks to its variants by name,
+// ignoring names it has no variant for. Both may be negative.
+func (info *Info) AddClicks(clicks int, variantClicks map[string]int) {
+	info.Clicks += clicks
+	info.Variants = slices.Clone(info.Variants)
+	for i := range info.Variants {
+		info.Variants[i].Clicks += variantClicks[info.Variants[i].Name]
+	}
+}
+
+// NormaliseVariants checks variants, lower-casing their names and naming
+// those without one a, b, c and so on by position. It returns nil when there
+// are no variants.
+func NormaliseVariants(variants []Variant) ([]Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) == 1 || len(variants) > MaxVariants {
+		return nil, fmt.Errorf("a split needs between 2 and %d variants", MaxVariants)
+	}
+	normalised := slices.Clone(variants)
+	total := 0
+	for i := range normalised {
+		v := &normalised[i]
+		if v.Name = strings.ToLower(strings.TrimSpace(v.Name)); v.Name == "" {
+			v.Name = string(rune('a' + i))
+		}
+		if len(v.Name) > maxVariantName || strings.ContainsFunc(v.Name, func(r rune) bool {
+			return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+		}) {
+			return nil, fmt.Errorf("variant name %q must be up to %d letters, digits, '-' or '_'", v.Name, maxVariantName)
+		}
+		if slices.ContainsFunc(normalised[:i], func(other Variant) bool { return other.Name == v.Name }) {
+			return nil, fmt.Errorf("variant name %q is used twice", v.Name)
+		}
+		destination, err := url.Parse(strings.TrimSpace(v.URL))
+		if err != nil || (destination.Scheme != "http" && destination.Scheme != "https") || destination.Host == "" {
+			return nil, fmt.Errorf("variant %q: url %q is not an absolute http or https URL", v.Name, v.URL)
+		}
+		v.URL = destination.String()
+		if v.Weight < 0 || v.Weight > MaxVariantWeight {
+			return nil, fmt.Errorf("variant %q: weight must be between 0 and %d", v.Name, MaxVariantWeight)
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return nil, errors.New("at least one variant needs a weight")
+	}
+	return normalised, nil
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+type contextKey struct{}
+
+// requestInfo is the per-request state shared between the access log
+// middleware and the handlers it wraps.
+type requestInfo struct {
+	logger    *slog.Logger
+	requestID string
+
+	mu        sync.Mutex
+	shortCode string
+	variant   string
+}
+
+// New returns a JSON logger writing records at or above level to w.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// ParseLevel accepts debug, info, warn or error, optionally with an offset such as "info+2".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewContext attaches a logger tagged with the request ID to ctx.
+func NewContext(ctx context.Context, logger *slog.Logger, requestID string) context.Context {
+	info := &requestInfo{logger: logger.With("request_id", requestID), requestID: requestID}
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+func fromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(contextKey{}).(*requestInfo)
+	return info
+}
+
+// FromContext returns the request-scoped logger, falling back to slog.Default.
+func FromContext(ctx context.Context) *slog.Logger {
+	if info := fromContext(ctx); info != nil {
+		return info.logger
+	}
+	return slog.Default()
+}
+
+func RequestID(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		return info.requestID
+	}
+	return ""
+}
+
+// SetShortCode records the short code a request operated on so it appears in the access log.
+func SetShortCode(ctx context.Context, shortCode string) {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		info.shortCode = shortCode
+	}
+}
+
+func ShortCode(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.shortCode
+	}
+	return ""
+}
+
+// SetVariant records the variant of a split link a redirect served so it
+// appears in the access log.
+func SetVariant(ctx context.Context, variant string) {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		info.variant = variant
+	}
+}
+
+func Variant(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.variant
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+)
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{"debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, " error ": slog.LevelError}
+	for input, want := range cases {
+		got, err := logging.ParseLevel(input)
+		if err != nil {
+			t.Fatalf("ParseLevel(%q) failed: %v", input, err)
+		}
+		if got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", input, got, want)
+		}
+	}
+
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestContext(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := logging.NewContext(context.Background(), logging.New(&buf, slog.LevelInfo), "req-1")
+
+	logging.FromContext(ctx).Info("hello")
+	logging.SetShortCode(ctx, "abc123")
+
+	if !strings.Contains(buf.String(), `"request_id":"req-1"`) {
+		t.Errorf("request id missing from %q", buf.String())
+	}
+	if got := logging.RequestID(ctx); got != "req-1" {
+		t.Errorf("got request id %q, want %q", got, "req-1")
+	}
+	if got := logging.ShortCode(ctx); got != "abc123" {
+		t.Errorf("got short code %q, want %q", got, "abc123")
+	}
+	if logging.FromContext(context.Background()) != slog.Default() {
+		t.Error("should fall back to the default logger")
+	}
+}
+
+func TestLoggedStore(t *testing.T) {
+	var buf bytes.Buffer
+	store := logging.LogStore(memory.New(), "memory", logging.New(&buf, slog.LevelDebug))
+
+	ctx := logging.NewContext(context.Background(), slog.Default(), "req-1")
+	store.Save(ctx, "abc123", "https://example.com")
+	store.Save(ctx, "abc123", "https://example.com")
+
+	logs := buf.String()
+	for _, want := range []string{`"operation":"save"`, `"backend":"memory"`, `"request_id":"req-1"`, storage.ErrShortCodeExists.Error()} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs %q should contain %q", logs, want)
+		}
+	}
+	if strings.Contains(logs, `"level":"ERROR"`) {
+		t.Errorf("a taken short code is not a failure: %q", logs)
+	}
+
+	buf.Reset()
+	store.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 1})
+	store.IncrementClicks(ctx, "abc123")
+	store.IncrementClicks(ctx, "abc123")
+	if logs := buf.String(); !strings.Contains(logs, storage.ErrClickLimitReached.Error()) || strings.Contains(logs, `"level":"ERROR"`) {
+		t.Errorf("a used up link is not a failure: %q", logs)
+	}
+
+	buf.Reset()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	store.Save(cancelled, "xyz123", "https://example.com")
+	if logs := buf.String(); !strings.Contains(logs, `"level":"ERROR"`) || !strings.Contains(logs, context.Canceled.Error()) {
+		t.Errorf("failed writes should be logged as errors: %q", logs)
+	}
+
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore { return logging.LogStore(memory.New(), "memory", slog.Default()) },
+	}.Test(t)
+}
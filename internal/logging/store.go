@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+// LoggedStore logs every call to the wrapped backend at debug level and
+// failures at error level. Unknown or taken short codes and used up links
+// are expected outcomes rather than failures and are logged at debug.
+type LoggedStore struct {
+	store  storage.URLStore
+	logger *slog.Logger
+}
+
+func LogStore(store storage.URLStore, backend string, logger *slog.Logger) *LoggedStore {
+	return &LoggedStore{store, logger.With("component", "storage", "backend", backend)}
+}
+
+func (s *LoggedStore) log(ctx context.Context, operation, shortCode string, start time.Time, err error) {
+	attrs := []any{"operation", operation, "duration", time.Since(start)}
+	if shortCode != "" {
+		attrs = append(attrs, "short_code", shortCode)
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	if err != nil && !errors.Is(err, storage.ErrShortCodeNotFound) && !errors.Is(err, storage.ErrShortCodeExists) &&
+		!errors.Is(err, storage.ErrClickLimitReached) {
+		s.logger.ErrorContext(ctx, "storage operation failed", append(attrs, "error", err)...)
+		return
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	s.logger.DebugContext(ctx, "storage operation", attrs...)
+}
+
+func (s *LoggedStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	start := time.Now()
+	exists, err := s.store.Exists(ctx, shortCode)
+	s.log(ctx, "exists", shortCode, start, err)
+	return exists, err
+}
+
+func (s *LoggedStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	start := time.Now()
+	err := s.store.Save(ctx, shortCode, originalUrl)
+	s.log(ctx, "save", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	start := time.Now()
+	err := s.store.UpdateURL(ctx, shortCode, originalUrl)
+	s.log(ctx, "update_url", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	start := time.Now()
+	originalUrl, err := s.store.GetOriginalURL(ctx, shortCode)
+	s.log(ctx, "get_original_url", shortCode, start, err)
+	return originalUrl, err
+}
+
+func (s *LoggedStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	start := time.Now()
+	info, err := s.store.GetInfo(ctx, shortCode)
+	s.log(ctx, "get_info", shortCode, start, err)
+	return info, err
+}
+
+func (s *LoggedStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	start := time.Now()
+	err := s.store.SaveInfo(ctx, shortCode, info)
+	s.log(ctx, "save_info", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.store.Ping(ctx)
+	s.log(ctx, "ping", "", start, err)
+	return err
+}
+
+func (s *LoggedStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	start := time.Now()
+	err := s.store.IncrementClicks(ctx, shortCode)
+	s.log(ctx, "increment_clicks", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	start := time.Now()
+	err := s.store.IncrementVariantClicks(ctx, shortCode, variant)
+	s.log(ctx, "increment_variant_clicks", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	start := time.Now()
+	err := s.store.AddClicks(ctx, shortCode, clicks, variantClicks)
+	s.log(ctx, "add_clicks", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) Delete(ctx context.Context, shortCode string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, shortCode)
+	s.log(ctx, "delete", shortCode, start, err)
+	return err
+}
+
+func (s *LoggedStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	start := time.Now()
+	links, err := s.store.List(ctx, filter)
+	s.log(ctx, "list", "", start, err)
+	return links, err
+}
@@ -0,0 +1,158 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("renders counters in the text format", func(t *testing.T) {
+		registry := metrics.NewRegistry()
+		plain := registry.NewCounter("links_total", "Links created.")
+		labelled := registry.NewCounter("requests_total", "Requests.", "path")
+		plain.Inc()
+		labelled.Add(2, `/a"b`)
+
+		got := render(registry)
+		want := `# HELP links_total Links created.
+# TYPE links_total counter
+links_total 1
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{path="/a\"b"} 2
+`
+		if got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("renders cumulative histogram buckets", func(t *testing.T) {
+		registry := metrics.NewRegistry()
+		h := registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "handler")
+		h.Observe(0.05, "redirector")
+		h.Observe(0.5, "redirector")
+		h.Observe(5, "redirector")
+
+		got := render(registry)
+		want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{handler="redirector",le="0.1"} 1
+latency_seconds_bucket{handler="redirector",le="1"} 2
+latency_seconds_bucket{handler="redirector",le="+Inf"} 3
+latency_seconds_sum{handler="redirector"} 5.55
+latency_seconds_count{handler="redirector"} 3
+`
+		if got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+	})
+
+	t.Run("renders what the Prometheus text parser reads back", func(t *testing.T) {
+		registry := metrics.NewRegistry()
+		counter := registry.NewCounter("requests_total", "Requests, by path.\nSee \\docs.", "path")
+		counter.Add(3, "/a\"b\\c\nd")
+		counter.Inc("/\xff")
+		h := registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "handler")
+		h.Observe(0.05, "redirector")
+		h.Observe(5, "redirector")
+
+		families := parse(t, render(registry))
+		requests := families["requests_total"]
+		if requests.GetHelp() != "Requests, by path.\nSee \\docs." || requests.GetType() != dto.MetricType_COUNTER || len(requests.GetMetric()) != 2 {
+			t.Fatalf("got family %v", requests)
+		}
+		values := map[string]float64{}
+		for _, m := range requests.GetMetric() {
+			values[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+		if values["/a\"b\\c\nd"] != 3 || values["/\uFFFD"] != 1 {
+			t.Errorf("got label values %v", values)
+		}
+
+		latency := families["latency_seconds"].GetMetric()
+		if len(latency) != 1 {
+			t.Fatalf("got %d latency series, want 1", len(latency))
+		}
+		histogram := latency[0].GetHistogram()
+		if histogram.GetSampleCount() != 2 || histogram.GetSampleSum() != 5.05 || len(histogram.GetBucket()) != 3 ||
+			histogram.GetBucket()[0].GetCumulativeCount() != 1 || histogram.GetBucket()[1].GetCumulativeCount() != 1 || histogram.GetBucket()[2].GetCumulativeCount() != 2 {
+			t.Errorf("got histogram %v", histogram)
+		}
+	})
+
+	t.Run("renders the service metrics in the text format", func(t *testing.T) {
+		metrics.LinksCreated.Inc()
+		metrics.StorageDuration.Observe(0.01, "memory", "save")
+
+		families := parse(t, render(metrics.Default))
+		created := families["shortener_links_created_total"]
+		if created.GetType() != dto.MetricType_COUNTER || len(created.GetMetric()) != 1 || created.GetMetric()[0].GetCounter().GetValue() < 1 {
+			t.Errorf("got family %v", created)
+		}
+		if storage := families["shortener_storage_operation_duration_seconds"]; storage.GetType() != dto.MetricType_HISTOGRAM {
+			t.Errorf("got family %v", storage)
+		}
+	})
+
+	t.Run("serves the registry over HTTP", func(t *testing.T) {
+		registry := metrics.NewRegistry()
+		registry.NewCounter("links_total", "Links created.")
+		response := httptest.NewRecorder()
+
+		registry.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		if got := response.Header().Get("content-type"); got != metrics.ContentType {
+			t.Errorf("got content type %q, want %q", got, metrics.ContentType)
+		}
+		if !strings.Contains(response.Body.String(), "links_total 0") {
+			t.Errorf("unexpected body %q", response.Body.String())
+		}
+	})
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	store := metrics.InstrumentStore(memory.New(), "test-backend")
+	before := metrics.StorageDuration.Count("test-backend", "save")
+
+	store.Save(context.Background(), "abc123", "https://example.com")
+	store.GetOriginalURL(context.Background(), "abc123")
+
+	if got := metrics.StorageDuration.Count("test-backend", "save") - before; got != 1 {
+		t.Errorf("got %d save observations, want %d", got, 1)
+	}
+	if got := metrics.StorageDuration.Count("test-backend", "get_original_url"); got == 0 {
+		t.Error("lookups should be timed")
+	}
+
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore { return metrics.InstrumentStore(memory.New(), "contract") },
+	}.Test(t)
+}
+
+// parse reads text with the parser Prometheus scrapes with, failing the test
+// on anything it rejects.
+func parse(t testing.TB, text string) map[string]*dto.MetricFamily {
+	t.Helper()
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("failed to parse:\n%s\nerror: %v", text, err)
+	}
+	return families
+}
+
+func render(r *metrics.Registry) string {
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package metrics
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+var (
+	LinksCreated = Default.NewCounter("shortener_links_created_total",
+		"Number of short links created.")
+	RedirectsServed = Default.NewCounter("shortener_redirects_total",
+		"Number of redirects served.")
+	RedirectsNotFound = Default.NewCounter("shortener_redirect_not_found_total",
+		"Number of redirects for unknown short codes.")
+	RedirectsExhausted = Default.NewCounter("shortener_redirect_exhausted_total",
+		"Number of redirects refused because the link reached its click limit.")
+	RetryAttemptsExceeded = Default.NewCounter("shortener_retry_attempts_exceeded_total",
+		"Number of link creations that gave up after exhausting short code retries.")
+	GeneratorCollisions = Default.NewCounter("shortener_generator_collisions_total",
+		"Number of generated short codes that were already taken.")
+	CacheRequests = Default.NewCounter("shortener_cache_requests_total",
+		"Number of short code lookups served by the cache, by hit or miss.", "result")
+	CacheEvictions = Default.NewCounter("shortener_cache_evictions_total",
+		"Number of cached short codes evicted to stay within capacity.")
+	DualWriteFailures = Default.NewCounter("shortener_dual_write_failures_total",
+		"Number of writes that reached the primary storage but could not be mirrored to the secondary.", "operation")
+	AuditFailures = Default.NewCounter("shortener_audit_failures_total",
+		"Number of link changes that were made but could not be recorded in the audit log.")
+	Snapshots = Default.NewCounter("shortener_snapshots_total",
+		"Number of scheduled storage snapshots, by ok or error.", "result")
+	RequestDuration = Default.NewHistogram("shortener_http_request_duration_seconds",
+		"Latency of HTTP requests by handler.", DefaultBuckets, "handler", "method", "code")
+	StorageDuration = Default.NewHistogram("shortener_storage_operation_duration_seconds",
+		"Latency of storage operations by backend.", DefaultBuckets, "backend", "operation")
+)
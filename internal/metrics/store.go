@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+// InstrumentedStore records the duration of every call to the wrapped backend.
+type InstrumentedStore struct {
+	store    storage.URLStore
+	backend  string
+	duration *Histogram
+}
+
+func InstrumentStore(store storage.URLStore, backend string) *InstrumentedStore {
+	return &InstrumentedStore{store, backend, StorageDuration}
+}
+
+func (s *InstrumentedStore) observe(operation string, start time.Time) {
+	s.duration.Observe(time.Since(start).Seconds(), s.backend, operation)
+}
+
+func (s *InstrumentedStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	defer s.observe("exists", time.Now())
+	return s.store.Exists(ctx, shortCode)
+}
+
+func (s *InstrumentedStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	defer s.observe("save", time.Now())
+	return s.store.Save(ctx, shortCode, originalUrl)
+}
+
+func (s *InstrumentedStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	defer s.observe("update_url", time.Now())
+	return s.store.UpdateURL(ctx, shortCode, originalUrl)
+}
+
+func (s *InstrumentedStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	defer s.observe("get_original_url", time.Now())
+	return s.store.GetOriginalURL(ctx, shortCode)
+}
+
+func (s *InstrumentedStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	defer s.observe("get_info", time.Now())
+	return s.store.GetInfo(ctx, shortCode)
+}
+
+func (s *InstrumentedStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	defer s.observe("save_info", time.Now())
+	return s.store.SaveInfo(ctx, shortCode, info)
+}
+
+func (s *InstrumentedStore) Ping(ctx context.Context) error {
+	defer s.observe("ping", time.Now())
+	return s.store.Ping(ctx)
+}
+
+func (s *InstrumentedStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	defer s.observe("increment_clicks", time.Now())
+	return s.store.IncrementClicks(ctx, shortCode)
+}
+
+func (s *InstrumentedStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	defer s.observe("increment_variant_clicks", time.Now())
+	return s.store.IncrementVariantClicks(ctx, shortCode, variant)
+}
+
+func (s *InstrumentedStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	defer s.observe("add_clicks", time.Now())
+	return s.store.AddClicks(ctx, shortCode, clicks, variantClicks)
+}
+
+func (s *InstrumentedStore) Delete(ctx context.Context, shortCode string) error {
+	defer s.observe("delete", time.Now())
+	return s.store.Delete(ctx, shortCode)
+}
+
+func (s *InstrumentedStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	defer s.observe("list", time.Now())
+	return s.store.List(ctx, filter)
+}
@@ -0,0 +1,336 @@
+// Package migrate copies links from one storage.URLStore to another and
+// verifies that both hold the same links.
+//
+// Moving to a new backend without downtime goes:
+//
+//  1. run the server with the new backend as the dual-write secondary, so
+//     new writes reach both
+//  2. Copy the existing links across, resuming from the checkpoint if it is
+//     interrupted
+//  3. Verify, and Copy again to repair any link that changed mid-copy
+//  4. switch the server to the new backend
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+const (
+	DefaultBatchSize = 500
+	// maxListedCodes bounds how many differing codes a Verification lists
+	maxListedCodes = 100
+)
+
+// ErrCheckpointMismatch is returned by Copy when the checkpoint was written
+// by a copy between other stores than the ones it is given.
+var ErrCheckpointMismatch = errors.New("the checkpoint belongs to another migration")
+
+type Options struct {
+	BatchSize int
+	// Checkpoint is a file recording how far Copy got so an interrupted run
+	// resumes there, empty disables it. It is removed once the copy finishes.
+	Checkpoint string
+	// Source and Target describe the stores, such as by backend and location.
+	// The checkpoint records them and Copy only resumes from a checkpoint
+	// recording the same, so progress is never carried over to another copy.
+	Source, Target string
+	// Progress, if set, is called after every batch
+	Progress func(Progress)
+}
+
+// Progress is how far a copy has got.
+type Progress struct {
+	// Copied counts links written to the target
+	Copied int `json:"copied"`
+	// Unchanged counts links the target already held as they are in the source
+	Unchanged int `json:"unchanged"`
+	// LastCode is the last short code copied, links are copied in code order
+	LastCode string `json:"last_code"`
+	// Resumed reports whether the copy started from a checkpoint
+	Resumed bool          `json:"-"`
+	Elapsed time.Duration `json:"-"`
+}
+
+// Copy copies every link in source to target, replacing links in target that
+// differ. It is safe to run repeatedly and against a target that is receiving
+// dual writes, as every link is compared before it is written.
+func Copy(ctx context.Context, source, target storage.URLStore, opts Options) (Progress, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	start := time.Now()
+	progress, err := readCheckpoint(opts)
+	if err != nil {
+		return progress, err
+	}
+
+	filter := storage.ListFilter{After: progress.LastCode, Limit: opts.BatchSize}
+	for {
+		page, err := source.List(ctx, filter)
+		if err != nil {
+			return progress, fmt.Errorf("failed to list source links after %q: %w", filter.After, err)
+		}
+		for _, l := range page {
+			written, err := copyLink(ctx, target, l)
+			if err != nil {
+				return progress, fmt.Errorf("failed to copy %q: %w", l.Code, err)
+			}
+			if written {
+				progress.Copied++
+			} else {
+				progress.Unchanged++
+			}
+			progress.LastCode = l.Code
+		}
+		if len(page) > 0 {
+			if err := writeCheckpoint(opts, progress); err != nil {
+				return progress, err
+			}
+		}
+		progress.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if len(page) < filter.Limit {
+			break
+		}
+		filter.After = progress.LastCode
+	}
+
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return progress, fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+	return progress, nil
+}
+
+// copyLink writes l to target unless target already holds it, reporting
+// whether it wrote anything.
+func copyLink(ctx context.Context, target storage.URLStore, l link.Link) (bool, error) {
+	// the counters the target holds, which SaveInfo keeps and SetClicks then
+	// brings to the source's
+	var current link.Info
+	err := target.Save(ctx, l.Code, l.URL)
+	if errors.Is(err, storage.ErrShortCodeExists) {
+		existing, lookupErr := getLink(ctx, target, l.Code)
+		if lookupErr != nil {
+			return false, lookupErr
+		}
+		if string(canonical(existing)) == string(canonical(l)) {
+			return false, nil
+		}
+		// the source is the source of truth, updated in place so the code is
+		// never free on the target while it may be serving
+		current = existing.Info
+		err = target.UpdateURL(ctx, l.Code, l.URL)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := target.SaveInfo(ctx, l.Code, l.Info); err != nil {
+		return true, err
+	}
+	return true, storage.SetClicks(ctx, target, l.Code, current, l.Info)
+}
+
+func getLink(ctx context.Context, store storage.URLStore, shortCode string) (link.Link, error) {
+	url, err := store.GetOriginalURL(ctx, shortCode)
+	if err != nil {
+		return link.Link{}, err
+	}
+	info, err := store.GetInfo(ctx, shortCode)
+	if err != nil && !errors.Is(err, storage.ErrShortCodeNotFound) {
+		return link.Link{}, err
+	}
+	return link.New(shortCode, url, info), nil
+}
+
+// Verification compares the links held by a source and target store.
+type Verification struct {
+	SourceCount    int    `json:"source_count"`
+	TargetCount    int    `json:"target_count"`
+	SourceChecksum string `json:"source_checksum"`
+	TargetChecksum string `json:"target_checksum"`
+	// Missing, Extra and Different list the first few codes only in the
+	// source, only in the target, or in both with different values
+	Missing   []string `json:"missing,omitempty"`
+	Extra     []string `json:"extra,omitempty"`
+	Different []string `json:"different,omitempty"`
+}
+
+// OK reports whether both stores hold the same links.
+func (v Verification) OK() bool {
+	return v.SourceChecksum == v.TargetChecksum
+}
+
+// Verify walks source and target in short code order, checksumming each and
+// noting the codes where they differ. Links written while it runs, such as
+// clicks on a live service, can show up as differences that a rerun clears.
+func Verify(ctx context.Context, source, target storage.URLStore, batchSize int) (Verification, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	src := &cursor{store: source, limit: batchSize, sum: sha256.New()}
+	dst := &cursor{store: target, limit: batchSize, sum: sha256.New()}
+	var v Verification
+	list := func(codes *[]string, code string) {
+		if len(*codes) < maxListedCodes {
+			*codes = append(*codes, code)
+		}
+	}
+
+	for {
+		s, sok, err := src.peek(ctx)
+		if err != nil {
+			return v, fmt.Errorf("failed to read source: %w", err)
+		}
+		d, dok, err := dst.peek(ctx)
+		if err != nil {
+			return v, fmt.Errorf("failed to read target: %w", err)
+		}
+		switch {
+		case !sok && !dok:
+			v.SourceCount, v.TargetCount = src.count, dst.count
+			v.SourceChecksum = hex.EncodeToString(src.sum.Sum(nil))
+			v.TargetChecksum = hex.EncodeToString(dst.sum.Sum(nil))
+			return v, nil
+		case !dok || (sok && s.Code < d.Code):
+			list(&v.Missing, s.Code)
+			src.advance()
+		case !sok || d.Code < s.Code:
+			list(&v.Extra, d.Code)
+			dst.advance()
+		default:
+			if string(src.encoded) != string(dst.encoded) {
+				list(&v.Different, s.Code)
+			}
+			src.advance()
+			dst.advance()
+		}
+	}
+}
+
+// cursor walks a store in short code order a page at a time, hashing every
+// link it passes.
+type cursor struct {
+	store   storage.URLStore
+	limit   int
+	page    []link.Link
+	after   string
+	done    bool
+	encoded []byte
+	count   int
+	sum     hash.Hash
+}
+
+// peek returns the current link, or false once the store is exhausted.
+func (c *cursor) peek(ctx context.Context) (link.Link, bool, error) {
+	if len(c.page) == 0 && !c.done {
+		page, err := c.store.List(ctx, storage.ListFilter{After: c.after, Limit: c.limit})
+		if err != nil {
+			return link.Link{}, false, err
+		}
+		c.page, c.done = page, len(page) < c.limit
+		if len(page) > 0 {
+			c.after = page[len(page)-1].Code
+		}
+	}
+	if len(c.page) == 0 {
+		return link.Link{}, false, nil
+	}
+	c.encoded = canonical(c.page[0])
+	return c.page[0], true, nil
+}
+
+func (c *cursor) advance() {
+	c.sum.Write(c.encoded)
+	c.sum.Write([]byte{'\n'})
+	c.count++
+	c.page = c.page[1:]
+}
+
+// canonical encodes l so that backends storing the same link produce the
+// same bytes, whatever time zone or precision they keep timestamps in.
+func canonical(l link.Link) []byte {
+	l.CreatedAt = l.CreatedAt.UTC().Truncate(time.Microsecond)
+	l.UpdatedAt = l.UpdatedAt.UTC().Truncate(time.Microsecond)
+	if l.Deleted() {
+		l.DeletedAt = l.DeletedAt.UTC().Truncate(time.Microsecond)
+	}
+	b, _ := json.Marshal(l)
+	return b
+}
+
+// checkpoint is what the checkpoint file holds: the progress of a copy and
+// the stores it was copying between.
+type checkpoint struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Progress
+}
+
+func readCheckpoint(opts Options) (Progress, error) {
+	path := opts.Checkpoint
+	if path == "" {
+		return Progress{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Progress{}, nil
+	}
+	if err != nil {
+		return Progress{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var c checkpoint
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Progress{}, fmt.Errorf("failed to read checkpoint %s: %w", path, err)
+	}
+	if c.Source != opts.Source || c.Target != opts.Target {
+		return Progress{}, fmt.Errorf("%w: %s was written copying from %q to %q, not %q to %q, remove it to start over",
+			ErrCheckpointMismatch, path, c.Source, c.Target, opts.Source, opts.Target)
+	}
+	c.Progress.Resumed = true
+	return c.Progress, nil
+}
+
+// writeCheckpoint replaces the checkpoint in one rename so an interruption
+// never leaves half of one behind.
+func writeCheckpoint(opts Options, progress Progress) error {
+	path := opts.Checkpoint
+	if path == "" {
+		return nil
+	}
+	b, err := json.Marshal(checkpoint{Source: opts.Source, Target: opts.Target, Progress: progress})
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/migrate"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+)
+
+// flakyStore fails List once it has served failAfter pages, as if the
+// process was interrupted part way through a copy.
+type flakyStore struct {
+	*memory.MemoryDB
+	failAfter int
+	pages     int
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func (f *flakyStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	if f.failAfter > 0 && f.pages >= f.failAfter {
+		return nil, errInterrupted
+	}
+	f.pages++
+	return f.MemoryDB.List(ctx, filter)
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("copies every link and reports progress", func(t *testing.T) {
+		source := newSource(t, 25)
+		target := memory.New()
+		var reports []migrate.Progress
+
+		progress, err := migrate.Copy(ctx, source, target, migrate.Options{
+			BatchSize: 10,
+			Progress:  func(p migrate.Progress) { reports = append(reports, p) },
+		})
+		assertNoErr(t, err)
+		if progress.Copied != 25 || progress.Unchanged != 0 || progress.LastCode != "code0024" {
+			t.Errorf("got progress %+v", progress)
+		}
+		if len(reports) != 3 || reports[0].Copied != 10 || reports[1].Copied != 20 {
+			t.Errorf("got progress reports %+v", reports)
+		}
+		assertVerified(t, source, target)
+	})
+
+	t.Run("leaves unchanged links alone and repairs changed ones", func(t *testing.T) {
+		source := newSource(t, 5)
+		target := memory.New()
+		_, err := migrate.Copy(ctx, source, target, migrate.Options{})
+		assertNoErr(t, err)
+
+		assertNoErr(t, source.IncrementClicks(ctx, "code0002"))
+		assertNoErr(t, target.Delete(ctx, "code0003"))
+		assertNoErr(t, target.Save(ctx, "code0003", "https://stale.example"))
+
+		progress, err := migrate.Copy(ctx, source, target, migrate.Options{})
+		assertNoErr(t, err)
+		if progress.Copied != 2 || progress.Unchanged != 3 {
+			t.Errorf("got progress %+v", progress)
+		}
+		assertVerified(t, source, target)
+	})
+
+	t.Run("resumes from the checkpoint after an interruption", func(t *testing.T) {
+		source := &flakyStore{MemoryDB: newSource(t, 25), failAfter: 2}
+		target := memory.New()
+		checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+		opts := migrate.Options{BatchSize: 10, Checkpoint: checkpoint}
+
+		_, err := migrate.Copy(ctx, source, target, opts)
+		if !errors.Is(err, errInterrupted) {
+			t.Fatalf("got %v, want %v", err, errInterrupted)
+		}
+		if _, err := os.Stat(checkpoint); err != nil {
+			t.Fatalf("no checkpoint left behind: %v", err)
+		}
+
+		source.failAfter = 0
+		progress, err := migrate.Copy(ctx, source, target, opts)
+		assertNoErr(t, err)
+		if !progress.Resumed || progress.Copied != 25 || progress.Unchanged != 0 {
+			t.Errorf("got progress %+v", progress)
+		}
+		if _, err := os.Stat(checkpoint); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("checkpoint was not removed: %v", err)
+		}
+		assertVerified(t, source, target)
+	})
+
+	t.Run("refuses a checkpoint written copying between other stores", func(t *testing.T) {
+		source := &flakyStore{MemoryDB: newSource(t, 25), failAfter: 2}
+		checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+		opts := migrate.Options{BatchSize: 10, Checkpoint: checkpoint, Source: "file /data/links.json", Target: "redis redis://cache:6379/0"}
+		_, err := migrate.Copy(ctx, source, memory.New(), opts)
+		if !errors.Is(err, errInterrupted) {
+			t.Fatalf("got %v, want %v", err, errInterrupted)
+		}
+
+		source.failAfter = 0
+		opts.Target = "redis redis://cache:6379/1"
+		_, err = migrate.Copy(ctx, source, memory.New(), opts)
+		if !errors.Is(err, migrate.ErrCheckpointMismatch) {
+			t.Fatalf("got %v, want %v", err, migrate.ErrCheckpointMismatch)
+		}
+		if _, err := os.Stat(checkpoint); err != nil {
+			t.Errorf("the checkpoint should be left for its own migration: %v", err)
+		}
+	})
+
+	t.Run("rejects a corrupt checkpoint", func(t *testing.T) {
+		checkpoint := filepath.Join(t.TempDir(), "migrate.checkpoint")
+		assertNoErr(t, os.WriteFile(checkpoint, []byte("{not json"), 0o644))
+
+		_, err := migrate.Copy(ctx, newSource(t, 1), memory.New(), migrate.Options{Checkpoint: checkpoint})
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("lists missing, extra and different codes", func(t *testing.T) {
+		source := newSource(t, 5)
+		target := memory.New()
+		_, err := migrate.Copy(ctx, source, target, migrate.Options{})
+		assertNoErr(t, err)
+
+		assertNoErr(t, target.Delete(ctx, "code0001"))
+		assertNoErr(t, target.IncrementClicks(ctx, "code0002"))
+		assertNoErr(t, target.Save(ctx, "extra", "https://extra.example"))
+
+		v, err := migrate.Verify(ctx, source, target, 2)
+		assertNoErr(t, err)
+		if v.OK() {
+			t.Error("expected the stores to differ")
+		}
+		if v.SourceCount != 5 || v.TargetCount != 5 {
+			t.Errorf("got counts %d and %d", v.SourceCount, v.TargetCount)
+		}
+		if !slices.Equal(v.Missing, []string{"code0001"}) || !slices.Equal(v.Extra, []string{"extra"}) || !slices.Equal(v.Different, []string{"code0002"}) {
+			t.Errorf("got missing %q, extra %q and different %q", v.Missing, v.Extra, v.Different)
+		}
+	})
+
+	t.Run("ignores time zone and sub-microsecond differences", func(t *testing.T) {
+		created := time.Date(2025, 1, 2, 3, 4, 5, 123456789, time.UTC)
+		source, target := memory.New(), memory.New()
+		mustSave(t, source, "abc123", link.Info{CreatedAt: created})
+		mustSave(t, target, "abc123", link.Info{CreatedAt: created.Truncate(time.Microsecond).In(time.FixedZone("CET", 3600))})
+
+		v, err := migrate.Verify(ctx, source, target, 0)
+		assertNoErr(t, err)
+		if !v.OK() {
+			t.Errorf("got %+v, want a match", v)
+		}
+	})
+
+	t.Run("empty stores match", func(t *testing.T) {
+		v, err := migrate.Verify(ctx, memory.New(), memory.New(), 0)
+		assertNoErr(t, err)
+		if !v.OK() || v.SourceCount != 0 {
+			t.Errorf("got %+v", v)
+		}
+	})
+}
+
+func newSource(t testing.TB, n int) *memory.MemoryDB {
+	t.Helper()
+	store := memory.New()
+	for i := range n {
+		mustSave(t, store, fmt.Sprintf("code%04d", i), link.Info{
+			Title:     fmt.Sprintf("Link %d", i),
+			Tags:      []string{"migrated"},
+			CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+			Clicks:    i,
+		})
+	}
+	return store
+}
+
+func mustSave(t testing.TB, store storage.URLStore, code string, info link.Info) {
+	t.Helper()
+	ctx := context.Background()
+	assertNoErr(t, store.Save(ctx, code, "https://example.com/"+code))
+	assertNoErr(t, store.SaveInfo(ctx, code, info))
+	assertNoErr(t, storage.SetClicks(ctx, store, code, link.Info{}, info))
+}
+
+func assertVerified(t testing.TB, source, target storage.URLStore) {
+	t.Helper()
+	v, err := migrate.Verify(context.Background(), source, target, 7)
+	assertNoErr(t, err)
+	if !v.OK() {
+		t.Errorf("stores differ: %+v", v)
+	}
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	scheme     = "pbkdf2-sha256"
+	Iterations = 600_000
+	saltSize   = 16
+	keySize    = 32
+	// hashes from elsewhere, such as an import, may use other counts, but
+	// only within these bounds so one can't make every attempt arbitrarily
+	// slow
+	minIterations = 10_000
+	maxIterations = 2 * Iterations
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Hash derives a salted hash of password, encoded as
+// "pbkdf2-sha256$<iterations>$<salt>$<key>" so it can be stored next to the URL.
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, Iterations, keySize)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive key: %v", err)
+	}
+	return strings.Join([]string{
+		scheme,
+		strconv.Itoa(Iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// Verify reports whether password matches an encoded hash produced by Hash.
+// Hashes with an iteration count or key size Hash would not produce are
+// rejected as malformed.
+func Verify(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != scheme {
+		return false, ErrMalformedHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < minIterations || iterations > maxIterations {
+		return false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) != keySize {
+		return false, ErrMalformedHash
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false, fmt.Errorf("failed to derive key: %v", err)
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
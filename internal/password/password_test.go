@@ -0,0 +1,65 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/password"
+)
+
+func TestPassword(t *testing.T) {
+	t.Run("verifies the password it was hashed from", func(t *testing.T) {
+		hash, err := password.Hash("open sesame")
+		if err != nil {
+			t.Fatalf("failed to hash: %v", err)
+		}
+
+		ok, err := password.Verify("open sesame", hash)
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+		if !ok {
+			t.Error("password should match its hash")
+		}
+	})
+
+	t.Run("rejects the wrong password", func(t *testing.T) {
+		hash, _ := password.Hash("open sesame")
+
+		ok, _ := password.Verify("open barley", hash)
+		if ok {
+			t.Error("wrong password should not match")
+		}
+	})
+
+	t.Run("salts every hash", func(t *testing.T) {
+		first, _ := password.Hash("open sesame")
+		second, _ := password.Hash("open sesame")
+
+		if first == second {
+			t.Error("hashes of the same password should differ")
+		}
+	})
+
+	t.Run("rejects a malformed hash", func(t *testing.T) {
+		_, err := password.Verify("open sesame", "plaintext")
+		if !errors.Is(err, password.ErrMalformedHash) {
+			t.Errorf("got error %v, want %v", err, password.ErrMalformedHash)
+		}
+	})
+
+	t.Run("rejects hashes with an iteration count or key size out of range", func(t *testing.T) {
+		hash, _ := password.Hash("open sesame")
+		parts := strings.Split(hash, "$")
+		for _, tampered := range []string{
+			strings.Join([]string{parts[0], "1", parts[2], parts[3]}, "$"),
+			strings.Join([]string{parts[0], "2000000000", parts[2], parts[3]}, "$"),
+			strings.Join([]string{parts[0], parts[1], parts[2], parts[3] + parts[3]}, "$"),
+		} {
+			if _, err := password.Verify("open sesame", tampered); !errors.Is(err, password.ErrMalformedHash) {
+				t.Errorf("got error %v for %q, want %v", err, tampered, password.ErrMalformedHash)
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	DefaultSize   = 256
+	MaxSize       = 2048
+	DefaultMargin = 4 // quiet zone in modules, as recommended by the QR spec
+	MaxMargin     = 32
+)
+
+var (
+	ErrInvalidLevel  = errors.New("error correction level must be one of L, M, Q or H")
+	ErrInvalidSize   = fmt.Errorf("size must be between 1 and %d pixels", MaxSize)
+	ErrInvalidMargin = fmt.Errorf("margin must be between 0 and %d modules", MaxMargin)
+	// ErrSizeTooSmall is returned for sizes giving a module less than a
+	// pixel, which scanners cannot read
+	ErrSizeTooSmall = errors.New("size is too small for the code to be readable")
+)
+
+type Options struct {
+	Size   int // width and height of the rendered code in pixels
+	Level  qrcode.RecoveryLevel
+	Margin int // quiet zone around the code in modules
+}
+
+func DefaultOptions() Options {
+	return Options{Size: DefaultSize, Level: qrcode.Medium, Margin: DefaultMargin}
+}
+
+// ParseLevel maps the conventional L/M/Q/H names onto error correction levels.
+func ParseLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, ErrInvalidLevel
+}
+
+// Code is an encoded QR symbol that can be rendered as PNG or SVG.
+type Code struct {
+	modules [][]bool
+	opts    Options
+}
+
+func Encode(content string, opts Options) (*Code, error) {
+	if opts.Size < 1 || opts.Size > MaxSize {
+		return nil, ErrInvalidSize
+	}
+	if opts.Margin < 0 || opts.Margin > MaxMargin {
+		return nil, ErrInvalidMargin
+	}
+	q, err := qrcode.New(content, opts.Level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %q: %v", content, err)
+	}
+	q.DisableBorder = true
+	code := &Code{modules: q.Bitmap(), opts: opts}
+	if opts.Size < code.Modules() {
+		return nil, fmt.Errorf("%w: the code needs at least %d pixels", ErrSizeTooSmall, code.Modules())
+	}
+	return code, nil
+}
+
+// Modules returns the number of modules along one side, including the margin.
+func (c *Code) Modules() int {
+	return len(c.modules) + 2*c.opts.Margin
+}
+
+func (c *Code) dark(x, y int) bool {
+	x, y = x-c.opts.Margin, y-c.opts.Margin
+	if y < 0 || y >= len(c.modules) || x < 0 || x >= len(c.modules[y]) {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+func (c *Code) PNG(w io.Writer) error {
+	n := c.Modules()
+	size := c.opts.Size
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for py := range size {
+		for px := range size {
+			if c.dark(px*n/size, py*n/size) {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func (c *Code) SVG(w io.Writer) error {
+	n := c.Modules()
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, c.opts.Size, c.opts.Size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range n {
+		for x := range n {
+			if c.dark(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
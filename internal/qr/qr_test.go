@@ -0,0 +1,111 @@
+package qr_test
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/sotiri-geo/url-shortener/internal/qr"
+)
+
+func TestEncode(t *testing.T) {
+	t.Run("renders a PNG of the requested size", func(t *testing.T) {
+		code, err := qr.Encode("https://example.com/abc123", qr.Options{Size: 290, Level: qrcode.Medium, Margin: 4})
+		assertNoErr(t, err)
+
+		var buf bytes.Buffer
+		assertNoErr(t, code.PNG(&buf))
+		img, err := png.Decode(&buf)
+		assertNoErr(t, err)
+
+		if got := img.Bounds().Dx(); got != 290 {
+			t.Errorf("got width %d, want %d", got, 290)
+		}
+		// the margin is blank and the finder pattern starts right after it
+		finder := 290 * 9 / (2 * code.Modules()) // centre of module 4
+		assertColor(t, img.At(0, 0), color.White)
+		assertColor(t, img.At(finder, finder), color.Black)
+	})
+
+	t.Run("margin is configurable", func(t *testing.T) {
+		withMargin, err := qr.Encode("https://example.com/abc123", qr.Options{Size: 100, Level: qrcode.Medium, Margin: 4})
+		assertNoErr(t, err)
+		without, err := qr.Encode("https://example.com/abc123", qr.Options{Size: 100, Level: qrcode.Medium, Margin: 0})
+		assertNoErr(t, err)
+
+		if got := withMargin.Modules() - without.Modules(); got != 8 {
+			t.Errorf("got %d extra modules, want %d", got, 8)
+		}
+	})
+
+	t.Run("higher error correction needs more modules", func(t *testing.T) {
+		low, _ := qr.Encode("https://example.com/abc123", qr.Options{Size: 100, Level: qrcode.Low})
+		high, _ := qr.Encode("https://example.com/abc123", qr.Options{Size: 100, Level: qrcode.Highest})
+
+		if high.Modules() <= low.Modules() {
+			t.Errorf("got %d modules for H, want more than %d for L", high.Modules(), low.Modules())
+		}
+	})
+
+	t.Run("renders an SVG", func(t *testing.T) {
+		code, err := qr.Encode("https://example.com/abc123", qr.DefaultOptions())
+		assertNoErr(t, err)
+
+		var buf bytes.Buffer
+		assertNoErr(t, code.SVG(&buf))
+		got := buf.String()
+		if !strings.HasPrefix(got, "<svg") || !strings.Contains(got, `width="256"`) {
+			t.Errorf("unexpected svg %q", got)
+		}
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		_, err := qr.Encode("https://example.com", qr.Options{Size: qr.MaxSize + 1})
+		if !errors.Is(err, qr.ErrInvalidSize) {
+			t.Errorf("got error %v, want %v", err, qr.ErrInvalidSize)
+		}
+		_, err = qr.Encode("https://example.com", qr.Options{Size: 100, Margin: -1})
+		if !errors.Is(err, qr.ErrInvalidMargin) {
+			t.Errorf("got error %v, want %v", err, qr.ErrInvalidMargin)
+		}
+		_, err = qr.Encode("https://example.com", qr.Options{Size: 20, Margin: qr.DefaultMargin})
+		if !errors.Is(err, qr.ErrSizeTooSmall) {
+			t.Errorf("got error %v, want %v", err, qr.ErrSizeTooSmall)
+		}
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]qrcode.RecoveryLevel{"L": qrcode.Low, "m": qrcode.Medium, "Q": qrcode.High, "H": qrcode.Highest}
+	for level, want := range cases {
+		got, err := qr.ParseLevel(level)
+		assertNoErr(t, err)
+		if got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", level, got, want)
+		}
+	}
+
+	if _, err := qr.ParseLevel("X"); !errors.Is(err, qr.ErrInvalidLevel) {
+		t.Errorf("got error %v, want %v", err, qr.ErrInvalidLevel)
+	}
+}
+
+func assertColor(t testing.TB, got, want color.Color) {
+	t.Helper()
+	gr, gg, gb, _ := got.RGBA()
+	wr, wg, wb, _ := want.RGBA()
+	if gr != wr || gg != wg || gb != wb {
+		t.Errorf("got colour %v, want %v", got, want)
+	}
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("should not error: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+// Package snapshot takes compressed, checksummed backups of a
+// storage.URLStore into a directory, prunes old ones and restores them.
+//
+// Each snapshot is a gzipped link.Encoder file named after the time it was
+// taken, next to a sha256sum style file holding the checksum of the
+// compressed bytes.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+const (
+	prefix         = "links-"
+	suffix         = ".json.gz"
+	checksumSuffix = ".sha256"
+	timeLayout     = "20060102T150405.000000000Z"
+)
+
+var ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+type Options struct {
+	Dir string
+	// Keep is how many of the newest snapshots are kept, zero keeps all
+	Keep int
+	// MaxAge removes snapshots older than this, zero keeps them regardless of age
+	MaxAge time.Duration
+	Now    func() time.Time
+}
+
+// Snapshot is a snapshot file in a snapshot directory.
+type Snapshot struct {
+	Path  string
+	Taken time.Time
+	Size  int64
+}
+
+// Take writes a snapshot of every link in store to opts.Dir, then prunes
+// the directory. Stores that implement storage.ConsistentReader, like the
+// memory, file and PostgreSQL stores, give a point in time copy. Others are
+// read a page at a time as transfer.Export reads them, each page as it was
+// when it was read.
+func Take(ctx context.Context, store storage.URLStore, opts Options) (Snapshot, error) {
+	opts = withDefaults(opts)
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	taken := opts.Now().UTC()
+	path := filepath.Join(opts.Dir, prefix+taken.Format(timeLayout)+suffix)
+	tmp, checksum, size, err := writeSnapshot(ctx, store, path, taken)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer os.Remove(tmp)
+	checksumTmp, err := writeTemp(path+checksumSuffix, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s  %s\n", checksum, filepath.Base(path))
+		return err
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to write checksum: %w", err)
+	}
+	defer os.Remove(checksumTmp)
+	// the checksum goes in place first, so a crash never leaves a snapshot
+	// that cannot be validated, only a checksum that List ignores
+	if err := os.Rename(checksumTmp, path+checksumSuffix); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to write checksum: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(path + checksumSuffix)
+		return Snapshot{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if _, err := Prune(opts); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Path: path, Taken: taken, Size: size}, nil
+}
+
+// writeSnapshot writes the links in store to a temporary file for path,
+// returning its name, checksum and size.
+func writeSnapshot(ctx context.Context, store storage.URLStore, path string, taken time.Time) (string, string, int64, error) {
+	hasher := sha256.New()
+	var size int64
+	tmp, err := writeTemp(path, func(w io.Writer) error {
+		counted := &countingWriter{w: io.MultiWriter(w, hasher)}
+		compressed, err := gzip.NewWriterLevel(counted, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		compressed.Name = "links.json"
+		compressed.ModTime = taken
+		if err := encodeLinks(ctx, store, compressed); err != nil {
+			return err
+		}
+		if err := compressed.Close(); err != nil {
+			return err
+		}
+		size = counted.n
+		return nil
+	})
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return tmp, hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+func encodeLinks(ctx context.Context, store storage.URLStore, w io.Writer) error {
+	reader, ok := store.(storage.ConsistentReader)
+	if !ok {
+		_, err := transfer.Export(ctx, store, w, transfer.JSON)
+		return err
+	}
+	encoder := link.NewEncoder(w)
+	if err := reader.ReadAll(ctx, encoder.Encode); err != nil {
+		return fmt.Errorf("failed to read links: %w", err)
+	}
+	return encoder.Close()
+}
+
+// List returns the snapshots in dir, newest first.
+func List(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, suffix); !ok {
+			continue
+		}
+		taken, err := time.Parse(timeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+		}
+		snapshots = append(snapshots, Snapshot{Path: filepath.Join(dir, name), Taken: taken, Size: info.Size()})
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return b.Taken.Compare(a.Taken) })
+	return snapshots, nil
+}
+
+// Prune removes the snapshots in opts.Dir that fall outside the retention
+// set by opts.Keep and opts.MaxAge, never the newest one, and returns them.
+func Prune(opts Options) ([]Snapshot, error) {
+	opts = withDefaults(opts)
+	snapshots, err := List(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Snapshot
+	for i, s := range snapshots {
+		expired := opts.MaxAge > 0 && opts.Now().Sub(s.Taken) > opts.MaxAge
+		if i == 0 || (!expired && (opts.Keep <= 0 || i < opts.Keep)) {
+			continue
+		}
+		if err := os.Remove(s.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+		if err := os.Remove(s.Path + checksumSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove snapshot checksum: %w", err)
+		}
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
+
+// Validate checks the snapshot at path against its checksum and that it
+// decodes, returning how many links it holds.
+func Validate(path string) (int, error) {
+	want, err := readChecksum(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != want {
+		return 0, fmt.Errorf("%w: %s has %s, want %s", ErrChecksumMismatch, filepath.Base(path), got, want)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	compressed, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	defer compressed.Close()
+	decoder := link.NewDecoder(compressed)
+	count := 0
+	for {
+		_, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		count++
+	}
+}
+
+// Restore validates the snapshot at path and loads it into store. opts.Format
+// is ignored, snapshots are always JSON.
+func Restore(ctx context.Context, store storage.URLStore, path string, opts transfer.Options) (transfer.Report, error) {
+	if _, err := Validate(path); err != nil {
+		return transfer.Report{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return transfer.Report{}, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	compressed, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return transfer.Report{}, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	defer compressed.Close()
+
+	opts.Format = transfer.JSON
+	return transfer.Import(ctx, store, compressed, opts)
+}
+
+// Run takes a snapshot every interval until ctx is done. Failures are logged
+// and retried at the next interval.
+func Run(ctx context.Context, store storage.URLStore, opts Options, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		s, err := Take(ctx, store, opts)
+		if err != nil {
+			metrics.Snapshots.Inc("error")
+			logger.ErrorContext(ctx, "failed to take snapshot", "dir", opts.Dir, "error", err)
+			continue
+		}
+		metrics.Snapshots.Inc("ok")
+		logger.InfoContext(ctx, "snapshot taken", "path", s.Path, "bytes", s.Size, "duration", time.Since(start))
+	}
+}
+
+func readChecksum(path string) (string, error) {
+	b, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to read snapshot checksum: %w", err)
+	}
+	checksum, name, ok := strings.Cut(strings.TrimSpace(string(b)), "  ")
+	if !ok || name != filepath.Base(path) {
+		return "", fmt.Errorf("malformed checksum file for %s", filepath.Base(path))
+	}
+	return checksum, nil
+}
+
+// writeTemp writes a synced temporary file next to path, to be renamed into
+// place, so a crash never leaves a partial file behind. The file is removed
+// if writing fails.
+func writeTemp(path string, write func(io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		buffered := bufio.NewWriter(tmp)
+		if err := write(buffered); err != nil {
+			return err
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func withDefaults(opts Options) Options {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return opts
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
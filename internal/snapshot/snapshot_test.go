@@ -0,0 +1,246 @@
+package snapshot_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/snapshot"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newClock() *fakeClock {
+	return &fakeClock{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func newStore(t testing.TB) *memory.MemoryDB {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.New()
+	assertNoErr(t, store.Save(ctx, "abc123", "https://example.com"))
+	assertNoErr(t, store.SaveInfo(ctx, "abc123", link.Info{Title: "Example", Tags: []string{"docs"}}))
+	assertNoErr(t, store.AddClicks(ctx, "abc123", 3, nil))
+	assertNoErr(t, store.Save(ctx, "def456", "https://example.org"))
+	return store
+}
+
+// unpagedStore fails List, so only its ReadAll can be used.
+type unpagedStore struct {
+	*memory.MemoryDB
+}
+
+func (unpagedStore) List(context.Context, storage.ListFilter) ([]link.Link, error) {
+	return nil, errors.New("listed instead of read all")
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("takes, validates and restores a snapshot", func(t *testing.T) {
+		clock := newClock()
+		opts := snapshot.Options{Dir: t.TempDir(), Now: clock.Now}
+
+		s, err := snapshot.Take(ctx, newStore(t), opts)
+		assertNoErr(t, err)
+		if filepath.Base(s.Path) != "links-20250101T000000.000000000Z.json.gz" || s.Size == 0 {
+			t.Errorf("got snapshot %+v", s)
+		}
+		count, err := snapshot.Validate(s.Path)
+		assertNoErr(t, err)
+		if count != 2 {
+			t.Errorf("got %d links, want 2", count)
+		}
+
+		restored := memory.New()
+		report, err := snapshot.Restore(ctx, restored, s.Path, transfer.Options{Strategy: transfer.Overwrite})
+		assertNoErr(t, err)
+		if report.Created != 2 {
+			t.Errorf("got report %+v", report)
+		}
+		info, err := restored.GetInfo(ctx, "abc123")
+		assertNoErr(t, err)
+		if !info.Equal(link.Info{Title: "Example", Clicks: 3, Tags: []string{"docs"}}) {
+			t.Errorf("got info %+v", info)
+		}
+	})
+
+	t.Run("keeps the newest snapshots", func(t *testing.T) {
+		clock := newClock()
+		opts := snapshot.Options{Dir: t.TempDir(), Keep: 2, Now: clock.Now}
+		store := newStore(t)
+		for range 4 {
+			_, err := snapshot.Take(ctx, store, opts)
+			assertNoErr(t, err)
+			clock.Advance(time.Hour)
+		}
+
+		snapshots, err := snapshot.List(opts.Dir)
+		assertNoErr(t, err)
+		if len(snapshots) != 2 || !snapshots[0].Taken.Equal(time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)) {
+			t.Errorf("got snapshots %+v", snapshots)
+		}
+		entries, _ := os.ReadDir(opts.Dir)
+		if len(entries) != 4 {
+			t.Errorf("got %d files, want 2 snapshots and their checksums", len(entries))
+		}
+	})
+
+	t.Run("removes expired snapshots but never the newest", func(t *testing.T) {
+		clock := newClock()
+		opts := snapshot.Options{Dir: t.TempDir(), MaxAge: 90 * time.Minute, Now: clock.Now}
+		store := newStore(t)
+		for range 3 {
+			_, err := snapshot.Take(ctx, store, opts)
+			assertNoErr(t, err)
+			clock.Advance(time.Hour)
+		}
+		snapshots, err := snapshot.List(opts.Dir)
+		assertNoErr(t, err)
+		if len(snapshots) != 2 {
+			t.Errorf("got %d snapshots, want 2", len(snapshots))
+		}
+
+		clock.Advance(24 * time.Hour)
+		removed, err := snapshot.Prune(opts)
+		assertNoErr(t, err)
+		if len(removed) != 1 {
+			t.Errorf("removed %d snapshots, want 1", len(removed))
+		}
+		if snapshots, _ := snapshot.List(opts.Dir); len(snapshots) != 1 {
+			t.Errorf("got %d snapshots, want the newest", len(snapshots))
+		}
+	})
+
+	t.Run("reads links across several pages", func(t *testing.T) {
+		store := memory.New()
+		for i := range 1234 {
+			assertNoErr(t, store.Save(ctx, fmt.Sprintf("code%04d", i), "https://example.com"))
+		}
+		// hides ReadAll, so the links are read a page at a time
+		paged := struct{ storage.URLStore }{store}
+		s, err := snapshot.Take(ctx, paged, snapshot.Options{Dir: t.TempDir(), Now: newClock().Now})
+		assertNoErr(t, err)
+
+		count, err := snapshot.Validate(s.Path)
+		assertNoErr(t, err)
+		if count != 1234 {
+			t.Errorf("got %d links, want 1234", count)
+		}
+	})
+
+	t.Run("reads a store with consistent reads in one go", func(t *testing.T) {
+		store := unpagedStore{newStore(t)}
+		s, err := snapshot.Take(ctx, store, snapshot.Options{Dir: t.TempDir(), Now: newClock().Now})
+		assertNoErr(t, err)
+
+		count, err := snapshot.Validate(s.Path)
+		assertNoErr(t, err)
+		if count != 2 {
+			t.Errorf("got %d links, want 2", count)
+		}
+	})
+
+	t.Run("leaves nothing behind when the store fails", func(t *testing.T) {
+		dir := t.TempDir()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := snapshot.Take(cancelled, newStore(t), snapshot.Options{Dir: dir, Now: newClock().Now}); err == nil {
+			t.Fatal("expected an error")
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("got %d files, want none", len(entries))
+		}
+	})
+
+	t.Run("rejects a corrupted snapshot", func(t *testing.T) {
+		opts := snapshot.Options{Dir: t.TempDir(), Now: newClock().Now}
+		s, err := snapshot.Take(ctx, newStore(t), opts)
+		assertNoErr(t, err)
+
+		b, err := os.ReadFile(s.Path)
+		assertNoErr(t, err)
+		b[len(b)/2] ^= 0xff
+		assertNoErr(t, os.WriteFile(s.Path, b, 0o644))
+
+		if _, err := snapshot.Validate(s.Path); !errors.Is(err, snapshot.ErrChecksumMismatch) {
+			t.Errorf("got %v, want %v", err, snapshot.ErrChecksumMismatch)
+		}
+		restored := memory.New()
+		if _, err := snapshot.Restore(ctx, restored, s.Path, transfer.Options{}); err == nil {
+			t.Error("expected restore to fail")
+		}
+		if exists, _ := restored.Exists(ctx, "abc123"); exists {
+			t.Error("a corrupted snapshot was partly restored")
+		}
+	})
+
+	t.Run("rejects a snapshot without a checksum", func(t *testing.T) {
+		opts := snapshot.Options{Dir: t.TempDir(), Now: newClock().Now}
+		s, err := snapshot.Take(ctx, newStore(t), opts)
+		assertNoErr(t, err)
+		assertNoErr(t, os.Remove(s.Path+".sha256"))
+
+		if _, err := snapshot.Validate(s.Path); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("ignores unrelated files", func(t *testing.T) {
+		dir := t.TempDir()
+		assertNoErr(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644))
+		assertNoErr(t, os.WriteFile(filepath.Join(dir, "links-yesterday.json.gz"), nil, 0o644))
+
+		snapshots, err := snapshot.List(dir)
+		assertNoErr(t, err)
+		if len(snapshots) != 0 {
+			t.Errorf("got %+v", snapshots)
+		}
+	})
+
+	t.Run("Run takes snapshots until cancelled", func(t *testing.T) {
+		dir := t.TempDir()
+		store := newStore(t)
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			snapshot.Run(ctx, store, snapshot.Options{Dir: dir, Keep: 1}, 10*time.Millisecond, discard)
+			close(done)
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if snapshots, _ := snapshot.List(dir); len(snapshots) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("no snapshot was taken")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		<-done
+	})
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+const (
+	DefaultCapacity = 10_000
+	// DefaultTTL bounds how long other replicas keep serving a link after it
+	// is changed or deleted, as only this one's entries are dropped on writes
+	DefaultTTL         = 30 * time.Second
+	DefaultNegativeTTL = 30 * time.Second
+)
+
+type Options struct {
+	Capacity int           // maximum number of cached short codes, found or not
+	TTL      time.Duration // how long a link is served from cache, zero means until evicted
+	// NegativeTTL is how long an unknown short code is remembered, zero disables negative caching
+	NegativeTTL time.Duration
+	Now         func() time.Time
+}
+
+func DefaultOptions() Options {
+	return Options{Capacity: DefaultCapacity, TTL: DefaultTTL, NegativeTTL: DefaultNegativeTTL}
+}
+
+type Stats struct {
+	Hits   int
+	Misses int
+}
+
+// Store is a read-through LRU cache for GetOriginalURL, GetInfo and Exists
+// in front of any URLStore. A link's URL and info are cached as one entry,
+// so a redirect reads both from one place. Writes go straight to the
+// backend and drop the code, except clicks, which are counted into the entry.
+type Store struct {
+	store storage.URLStore
+	opts  Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	stats   Stats
+	// bumped on every invalidation so a backend read that raced with a
+	// write is not cached
+	version uint64
+}
+
+type entry struct {
+	shortCode   string
+	originalUrl string
+	info        link.Info
+	found       bool
+	expires     time.Time // zero never expires
+}
+
+func New(store storage.URLStore, opts Options) *Store {
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Store{store: store, opts: opts, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (s *Store) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	e, err := s.load(ctx, shortCode)
+	return e.originalUrl, err
+}
+
+func (s *Store) Exists(ctx context.Context, shortCode string) (bool, error) {
+	_, err := s.load(ctx, shortCode)
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	e, err := s.load(ctx, shortCode)
+	if err != nil {
+		return link.Info{}, err
+	}
+	return e.info.Clone(), nil
+}
+
+// load returns the cached entry for shortCode, reading the link's URL and
+// info from the backend on a miss.
+func (s *Store) load(ctx context.Context, shortCode string) (entry, error) {
+	e, version, ok := s.lookup(shortCode)
+	if ok {
+		if !e.found {
+			return entry{}, storage.ErrShortCodeNotFound
+		}
+		return e, nil
+	}
+	e = entry{shortCode: shortCode, found: true}
+	var err error
+	e.originalUrl, err = s.store.GetOriginalURL(ctx, shortCode)
+	if err == nil {
+		// a link deleted between the two reads is not found either way
+		e.info, err = s.store.GetInfo(ctx, shortCode)
+	}
+	switch {
+	case err == nil:
+		s.add(e, version)
+	case errors.Is(err, storage.ErrShortCodeNotFound):
+		s.add(entry{shortCode: shortCode}, version)
+	}
+	if err != nil {
+		return entry{}, err
+	}
+	return e, nil
+}
+
+func (s *Store) Save(ctx context.Context, shortCode, originalUrl string) error {
+	// invalidate even on failure, the backend may have partially written
+	defer s.Invalidate(shortCode)
+	return s.store.Save(ctx, shortCode, originalUrl)
+}
+
+func (s *Store) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	defer s.Invalidate(shortCode)
+	return s.store.UpdateURL(ctx, shortCode, originalUrl)
+}
+
+func (s *Store) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	defer s.Invalidate(shortCode)
+	return s.store.SaveInfo(ctx, shortCode, info)
+}
+
+func (s *Store) IncrementClicks(ctx context.Context, shortCode string) error {
+	err := s.store.IncrementClicks(ctx, shortCode)
+	s.countClicks(shortCode, err, func(info *link.Info) { info.Clicks++ })
+	return err
+}
+
+func (s *Store) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	err := s.store.IncrementVariantClicks(ctx, shortCode, variant)
+	s.countClicks(shortCode, err, func(info *link.Info) { info.CountVariantClick(variant) })
+	return err
+}
+
+func (s *Store) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	err := s.store.AddClicks(ctx, shortCode, clicks, variantClicks)
+	s.countClicks(shortCode, err, func(info *link.Info) { info.AddClicks(clicks, variantClicks) })
+	return err
+}
+
+// countClicks applies clicks the backend counted to the cached entry, so
+// its click limit stays current on this replica. Failures drop the entry,
+// as one could mean the limit was reached elsewhere.
+func (s *Store) countClicks(shortCode string, err error, count func(*link.Info)) {
+	if err != nil {
+		s.Invalidate(shortCode)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[shortCode]; ok {
+		if e := el.Value.(*entry); e.found {
+			e.info = e.info.Clone()
+			count(&e.info)
+		}
+	}
+}
+
+func (s *Store) Delete(ctx context.Context, shortCode string) error {
+	defer s.Invalidate(shortCode)
+	return s.store.Delete(ctx, shortCode)
+}
+
+// List always reads through, listings are not cached.
+func (s *Store) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	return s.store.List(ctx, filter)
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.store.Ping(ctx)
+}
+
+// Invalidate drops any cached result for shortCode, for when the backend is changed behind the cache's back.
+func (s *Store) Invalidate(shortCode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	if el, ok := s.entries[shortCode]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Store) lookup(shortCode string) (entry, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[shortCode]
+	if ok {
+		e := el.Value.(*entry)
+		if e.expires.IsZero() || s.opts.Now().Before(e.expires) {
+			s.lru.MoveToFront(el)
+			s.stats.Hits++
+			metrics.CacheRequests.Inc("hit")
+			return *e, s.version, true
+		}
+		s.remove(el)
+	}
+	s.stats.Misses++
+	metrics.CacheRequests.Inc("miss")
+	return entry{}, s.version, false
+}
+
+func (s *Store) add(e entry, version uint64) {
+	ttl := s.opts.TTL
+	if !e.found {
+		if s.opts.NegativeTTL <= 0 {
+			return
+		}
+		ttl = s.opts.NegativeTTL
+	}
+	if ttl > 0 {
+		e.expires = s.opts.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version != s.version {
+		return
+	}
+	if el, ok := s.entries[e.shortCode]; ok {
+		s.remove(el)
+	}
+	s.entries[e.shortCode] = s.lru.PushFront(&e)
+	for s.lru.Len() > s.opts.Capacity {
+		s.remove(s.lru.Back())
+		metrics.CacheEvictions.Inc()
+	}
+}
+
+func (s *Store) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*entry).shortCode)
+}
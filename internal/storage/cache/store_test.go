@@ -0,0 +1,269 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/cache"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+)
+
+// countingStore counts URL and info lookups that reach the backend, failing
+// URL lookups with err when it is set.
+type countingStore struct {
+	*memory.MemoryDB
+	lookups     int
+	infoLookups int
+	err         error
+}
+
+func (c *countingStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	c.lookups++
+	if c.err != nil {
+		return "", c.err
+	}
+	return c.MemoryDB.GetOriginalURL(ctx, shortCode)
+}
+
+func (c *countingStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	c.infoLookups++
+	return c.MemoryDB.GetInfo(ctx, shortCode)
+}
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newClock() *fakeClock {
+	return &fakeClock{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func newBackend(urls map[string]string) *countingStore {
+	return &countingStore{MemoryDB: memory.NewWithData(urls)}
+}
+
+func TestCacheStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("serves repeated lookups from the cache", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		store := cache.New(backend, cache.DefaultOptions())
+
+		for range 3 {
+			assertURL(t, store, "abc123", "https://example.com")
+		}
+
+		assertLookups(t, backend.lookups, 1)
+		if got := store.Stats(); got != (cache.Stats{Hits: 2, Misses: 1}) {
+			t.Errorf("got stats %+v", got)
+		}
+	})
+
+	t.Run("evicts the least recently used code", func(t *testing.T) {
+		backend := newBackend(map[string]string{"a": "https://a.com", "b": "https://b.com", "c": "https://c.com"})
+		store := cache.New(backend, cache.Options{Capacity: 2})
+
+		store.GetOriginalURL(ctx, "a")
+		store.GetOriginalURL(ctx, "b")
+		store.GetOriginalURL(ctx, "a") // b is now least recently used
+		store.GetOriginalURL(ctx, "c")
+		backend.lookups = 0
+
+		store.GetOriginalURL(ctx, "a")
+		assertLookups(t, backend.lookups, 0)
+		store.GetOriginalURL(ctx, "b")
+		assertLookups(t, backend.lookups, 1)
+		if got := store.Len(); got != 2 {
+			t.Errorf("got %d entries, want %d", got, 2)
+		}
+	})
+
+	t.Run("expires entries after the TTL", func(t *testing.T) {
+		clock := newClock()
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		store := cache.New(backend, cache.Options{TTL: time.Minute, Now: clock.Now})
+
+		store.GetOriginalURL(ctx, "abc123")
+		clock.Advance(59 * time.Second)
+		store.GetOriginalURL(ctx, "abc123")
+		assertLookups(t, backend.lookups, 1)
+
+		clock.Advance(2 * time.Second)
+		store.GetOriginalURL(ctx, "abc123")
+		assertLookups(t, backend.lookups, 2)
+	})
+
+	t.Run("remembers unknown codes for the negative TTL", func(t *testing.T) {
+		clock := newClock()
+		backend := newBackend(map[string]string{})
+		store := cache.New(backend, cache.Options{NegativeTTL: 10 * time.Second, Now: clock.Now})
+
+		for range 2 {
+			if exists, _ := store.Exists(ctx, "xyz123"); exists {
+				t.Fatal("unknown code should not exist")
+			}
+		}
+		assertLookups(t, backend.lookups, 1)
+
+		clock.Advance(11 * time.Second)
+		store.Exists(ctx, "xyz123")
+		assertLookups(t, backend.lookups, 2)
+	})
+
+	t.Run("does not cache unknown codes without a negative TTL", func(t *testing.T) {
+		backend := newBackend(map[string]string{})
+		store := cache.New(backend, cache.Options{})
+
+		store.Exists(ctx, "xyz123")
+		store.Exists(ctx, "xyz123")
+		assertLookups(t, backend.lookups, 2)
+	})
+
+	t.Run("does not cache backend failures", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		backend.err = errors.New("connection refused")
+		store := cache.New(backend, cache.DefaultOptions())
+
+		if _, err := store.GetOriginalURL(ctx, "abc123"); !errors.Is(err, backend.err) {
+			t.Fatalf("got error %v, want %v", err, backend.err)
+		}
+		backend.err = nil
+
+		assertURL(t, store, "abc123", "https://example.com")
+		assertLookups(t, backend.lookups, 2)
+	})
+
+	t.Run("saving a code invalidates its negative entry", func(t *testing.T) {
+		backend := newBackend(map[string]string{})
+		store := cache.New(backend, cache.DefaultOptions())
+
+		if exists, _ := store.Exists(ctx, "abc123"); exists {
+			t.Fatal("code should not exist yet")
+		}
+		if err := store.Save(ctx, "abc123", "https://example.com"); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+
+		assertURL(t, store, "abc123", "https://example.com")
+	})
+
+	t.Run("deleting a code drops its cached URL", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		store := cache.New(backend, cache.DefaultOptions())
+		assertURL(t, store, "abc123", "https://example.com")
+
+		if err := store.Delete(ctx, "abc123"); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+
+		if _, err := store.GetOriginalURL(ctx, "abc123"); !errors.Is(err, storage.ErrShortCodeNotFound) {
+			t.Errorf("got error %v, want %v", err, storage.ErrShortCodeNotFound)
+		}
+	})
+
+	t.Run("updating a code drops its cached URL", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		store := cache.New(backend, cache.DefaultOptions())
+		assertURL(t, store, "abc123", "https://example.com")
+
+		if err := store.UpdateURL(ctx, "abc123", "https://example.org"); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+
+		assertURL(t, store, "abc123", "https://example.org")
+	})
+
+	t.Run("caches the info along with the URL", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		backend.SaveInfo(ctx, "abc123", link.Info{Title: "Example"})
+		store := cache.New(backend, cache.DefaultOptions())
+
+		assertURL(t, store, "abc123", "https://example.com")
+		for range 2 {
+			assertInfo(t, store, "abc123", link.Info{Title: "Example"})
+		}
+		assertLookups(t, backend.lookups, 1)
+		if backend.infoLookups != 1 {
+			t.Errorf("got %d backend info lookups, want 1", backend.infoLookups)
+		}
+	})
+
+	t.Run("saving info drops the cached entry", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		store := cache.New(backend, cache.DefaultOptions())
+		assertInfo(t, store, "abc123", link.Info{})
+
+		if err := store.SaveInfo(ctx, "abc123", link.Info{PasswordHash: "pbkdf2$hash"}); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+
+		assertInfo(t, store, "abc123", link.Info{PasswordHash: "pbkdf2$hash"})
+	})
+
+	t.Run("counts clicks into the cached info", func(t *testing.T) {
+		backend := newBackend(map[string]string{"abc123": "https://example.com"})
+		backend.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 1})
+		store := cache.New(backend, cache.DefaultOptions())
+		assertInfo(t, store, "abc123", link.Info{MaxClicks: 1})
+
+		if err := store.IncrementClicks(ctx, "abc123"); err != nil {
+			t.Fatalf("failed to count click: %v", err)
+		}
+
+		assertInfo(t, store, "abc123", link.Info{MaxClicks: 1, Clicks: 1})
+		if backend.infoLookups != 1 {
+			t.Errorf("got %d backend info lookups, want 1", backend.infoLookups)
+		}
+	})
+
+	t.Run("invalidate forces a reload from the backend", func(t *testing.T) {
+		urls := map[string]string{"abc123": "https://example.com"}
+		backend := newBackend(urls)
+		store := cache.New(backend, cache.DefaultOptions())
+		store.GetOriginalURL(ctx, "abc123")
+
+		// updated behind the cache's back
+		backend.MemoryDB = memory.NewWithData(map[string]string{"abc123": "https://example.org"})
+		store.Invalidate("abc123")
+
+		assertURL(t, store, "abc123", "https://example.org")
+	})
+
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore { return cache.New(memory.New(), cache.DefaultOptions()) },
+	}.Test(t)
+}
+
+func assertURL(t testing.TB, store storage.URLStore, shortCode, want string) {
+	t.Helper()
+	got, err := store.GetOriginalURL(context.Background(), shortCode)
+	if err != nil {
+		t.Fatalf("short code %q should be found: %v", shortCode, err)
+	}
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func assertInfo(t testing.TB, store storage.URLStore, shortCode string, want link.Info) {
+	t.Helper()
+	got, err := store.GetInfo(context.Background(), shortCode)
+	if err != nil {
+		t.Fatalf("info for %q should be found: %v", shortCode, err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func assertLookups(t testing.TB, got, want int) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got %d backend lookups, want %d", got, want)
+	}
+}
@@ -1,40 +1,640 @@
 package storage
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+// Size of the dataset saved by the large dataset test, shrunk under -short.
+const (
+	contractDatasetSize      = 1000
+	contractShortDatasetSize = 100
+	contractWorkers          = 20
+)
 
 type URLStoreContract struct {
 	NewStore func() URLStore
+	// Reopen returns a new store over the data written through store, as if
+	// the process had restarted. Leave nil for backends that keep nothing.
+	Reopen func(store URLStore) URLStore
 }
 
 // Now we can enforce our contract on the interface and test across multiple implementations
 // to make sure behaviour is consistent
 
 func (u URLStoreContract) Test(t *testing.T) {
+	ctx := context.Background()
+
 	t.Run("can save, check for existence and get original URL", func(t *testing.T) {
 		// setup
 		store := u.NewStore()
 		shortCode, originalUrl := "abc123", "https://example.com"
 
 		// execute - storing data
-		err := store.Save(shortCode, originalUrl)
+		err := store.Save(ctx, shortCode, originalUrl)
 
 		if err != nil {
 			t.Fatalf("failed to save: %v", err)
 		}
 
 		// assert
-		exists := store.Exists(shortCode)
+		exists, err := store.Exists(ctx, shortCode)
+		if err != nil {
+			t.Fatalf("failed to check existence: %v", err)
+		}
 		if !exists {
 			t.Fatalf("could not find short code %q", shortCode)
 		}
 
-		gotUrl, found := store.GetOriginalURL(shortCode)
+		gotUrl, err := store.GetOriginalURL(ctx, shortCode)
 
-		if !found {
-			t.Error("original url should be found")
+		if err != nil {
+			t.Errorf("original url should be found: %v", err)
 		}
 		if gotUrl != originalUrl {
 			t.Errorf("got %q, want %q", gotUrl, originalUrl)
 		}
 	})
+
+	t.Run("rejects a duplicate short code and keeps the first URL", func(t *testing.T) {
+		store := u.NewStore()
+		info := link.Info{Title: "First"}
+
+		mustSave(t, store, "abc123", "https://example.com")
+		if err := store.SaveInfo(ctx, "abc123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+
+		assertErrorIs(t, store.Save(ctx, "abc123", "https://example.org"), ErrShortCodeExists)
+		assertURL(t, store, "abc123", "https://example.com")
+		assertInfo(t, store, "abc123", info)
+	})
+
+	t.Run("unknown short code is not found", func(t *testing.T) {
+		store := u.NewStore()
+
+		exists, err := store.Exists(ctx, "xyz123")
+		if err != nil {
+			t.Fatalf("failed to check existence: %v", err)
+		}
+		if exists {
+			t.Error("unknown short code should not exist")
+		}
+
+		_, err = store.GetOriginalURL(ctx, "xyz123")
+		assertErrorIs(t, err, ErrShortCodeNotFound)
+	})
+
+	t.Run("ping succeeds on a healthy store", func(t *testing.T) {
+		store := u.NewStore()
+
+		if err := store.Ping(ctx); err != nil {
+			t.Errorf("ping failed: %v", err)
+		}
+	})
+
+	t.Run("honours a cancelled context", func(t *testing.T) {
+		store := u.NewStore()
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := store.Save(cancelled, "abc123", "https://example.com")
+		assertErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("can save and get link info", func(t *testing.T) {
+		store := u.NewStore()
+		shortCode := "abc123"
+		want := link.Info{Title: "Example", CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), Suspicious: true}
+
+		if err := store.Save(ctx, shortCode, "https://example.com"); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		if err := store.SaveInfo(ctx, shortCode, want); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+
+		assertInfo(t, store, shortCode, want)
+	})
+
+	t.Run("can save rich metadata", func(t *testing.T) {
+		store := u.NewStore()
+		want := link.Info{
+			Title:       "Example",
+			Description: "An example link",
+			Tags:        []string{"docs", "example"},
+			Creator:     "alice",
+			CreatedAt:   time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+			UpdatedAt:   time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC),
+			Metadata:    map[string]string{"campaign": "spring", "owner": "growth team"},
+			Query:       map[string]string{"ref": "short link"},
+			QueryPolicy: link.MergeQuery,
+			UTM:         map[string]string{"campaign": "spring", "source": ""},
+			ForwardPath: true,
+			Rules: []link.Rule{
+				{URL: "https://apps.apple.com/app/id1", Devices: []string{link.DeviceIOS}},
+				{URL: "https://example.fr", Countries: []string{"FR"}, Days: []string{"mon"}, Hours: "09:00-17:00",
+					TimeZone: "Europe/Paris", End: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+			Variants: []link.Variant{
+				{Name: "a", URL: "https://example.com/a", Weight: 3, Clicks: 2},
+				{Name: "b", URL: "https://example.com/b", Weight: 1},
+			},
+			StickyVariants: true,
+			MaxClicks:      100,
+		}
+		mustSave(t, store, "abc123", "https://example.com")
+
+		mustSaveInfo(t, store, "abc123", want)
+		assertInfo(t, store, "abc123", want)
+
+		// replacing the info drops tags and metadata left out of it
+		want = link.Info{Title: "Renamed"}
+		if err := store.SaveInfo(ctx, "abc123", want); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		assertInfo(t, store, "abc123", want)
+	})
+
+	t.Run("returned info is a copy", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		want := link.Info{Tags: []string{"docs"}, Metadata: map[string]string{"k": "v"}}
+		saved := want.Clone()
+		store.SaveInfo(ctx, "abc123", saved)
+
+		saved.Tags[0], saved.Metadata["k"] = "changed", "changed"
+		got, _ := store.GetInfo(ctx, "abc123")
+		got.Tags[0], got.Metadata["k"] = "changed", "changed"
+
+		assertInfo(t, store, "abc123", want)
+	})
+
+	t.Run("lists links by short code and filters by tag", func(t *testing.T) {
+		store := u.NewStore()
+		for code, tags := range map[string][]string{"ccc333": {"docs"}, "aaa111": {"docs", "news"}, "bbb222": nil} {
+			mustSave(t, store, code, "https://example.com/"+code)
+			if err := store.SaveInfo(ctx, code, link.Info{Tags: tags}); err != nil {
+				t.Fatalf("failed to save info: %v", err)
+			}
+		}
+
+		all, err := store.List(ctx, ListFilter{})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		assertCodes(t, all, "aaa111", "bbb222", "ccc333")
+		if all[0].URL != "https://example.com/aaa111" || !all[0].HasTag("news") {
+			t.Errorf("got %+v", all[0])
+		}
+
+		docs, err := store.List(ctx, ListFilter{Tag: "docs"})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		assertCodes(t, docs, "aaa111", "ccc333")
+
+		none, err := store.List(ctx, ListFilter{Tag: "missing"})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		assertCodes(t, none)
+	})
+
+	t.Run("pages through links after a short code", func(t *testing.T) {
+		store := u.NewStore()
+		for _, code := range []string{"ddd444", "aaa111", "ccc333", "bbb222"} {
+			mustSave(t, store, code, "https://example.com/"+code)
+		}
+
+		first, err := store.List(ctx, ListFilter{Limit: 2})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		assertCodes(t, first, "aaa111", "bbb222")
+
+		rest, err := store.List(ctx, ListFilter{After: first[len(first)-1].Code, Limit: 10})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		assertCodes(t, rest, "ccc333", "ddd444")
+	})
+
+	t.Run("keeps trashed links reserved and lists them apart", func(t *testing.T) {
+		store := u.NewStore()
+		deletedAt := time.Date(2025, 1, 4, 3, 4, 5, 0, time.UTC)
+		for _, code := range []string{"aaa111", "bbb222", "ccc333"} {
+			mustSave(t, store, code, "https://example.com/"+code)
+		}
+		if err := store.SaveInfo(ctx, "bbb222", link.Info{Title: "Trashed", DeletedAt: deletedAt}); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		assertInfo(t, store, "bbb222", link.Info{Title: "Trashed", DeletedAt: deletedAt})
+
+		exists, err := store.Exists(ctx, "bbb222")
+		if err != nil || !exists {
+			t.Errorf("a trashed short code must still exist, got %v (%v)", exists, err)
+		}
+		assertErrorIs(t, store.Save(ctx, "bbb222", "https://example.org"), ErrShortCodeExists)
+
+		for filter, want := range map[TrashFilter][]string{
+			IncludeTrash: {"aaa111", "bbb222", "ccc333"},
+			ExcludeTrash: {"aaa111", "ccc333"},
+			OnlyTrash:    {"bbb222"},
+		} {
+			got, err := store.List(ctx, ListFilter{Trash: filter})
+			if err != nil {
+				t.Fatalf("failed to list: %v", err)
+			}
+			assertCodes(t, got, want...)
+		}
+
+		// restoring clears the deletion
+		if err := store.SaveInfo(ctx, "bbb222", link.Info{Title: "Trashed"}); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		assertInfo(t, store, "bbb222", link.Info{Title: "Trashed"})
+	})
+
+	t.Run("updates a destination in place", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		info := link.Info{Title: "Example", MaxClicks: 10}
+		if err := store.SaveInfo(ctx, "abc123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		if err := store.IncrementClicks(ctx, "abc123"); err != nil {
+			t.Fatalf("failed to increment clicks: %v", err)
+		}
+
+		if err := store.UpdateURL(ctx, "abc123", "https://example.org"); err != nil {
+			t.Fatalf("failed to update url: %v", err)
+		}
+		assertURL(t, store, "abc123", "https://example.org")
+		info.Clicks = 1
+		assertInfo(t, store, "abc123", info)
+		assertErrorIs(t, store.UpdateURL(ctx, "xyz123", "https://example.org"), ErrShortCodeNotFound)
+		if exists, _ := store.Exists(ctx, "xyz123"); exists {
+			t.Error("updating an unknown code should not create it")
+		}
+	})
+
+	t.Run("deletes a link and its info", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		store.SaveInfo(ctx, "abc123", link.Info{Title: "Example"})
+
+		if err := store.Delete(ctx, "abc123"); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+
+		_, err := store.GetOriginalURL(ctx, "abc123")
+		assertErrorIs(t, err, ErrShortCodeNotFound)
+		_, err = store.GetInfo(ctx, "abc123")
+		assertErrorIs(t, err, ErrShortCodeNotFound)
+		assertErrorIs(t, store.Delete(ctx, "abc123"), ErrShortCodeNotFound)
+
+		// the short code is free again
+		mustSave(t, store, "abc123", "https://example.org")
+		assertURL(t, store, "abc123", "https://example.org")
+		assertInfo(t, store, "abc123", link.Info{})
+	})
+
+	t.Run("increments click count", func(t *testing.T) {
+		store := u.NewStore()
+		shortCode := "abc123"
+
+		if err := store.Save(ctx, shortCode, "https://example.com"); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		for range 3 {
+			if err := store.IncrementClicks(ctx, shortCode); err != nil {
+				t.Fatalf("failed to increment clicks: %v", err)
+			}
+		}
+
+		got, _ := store.GetInfo(ctx, shortCode)
+		if got.Clicks != 3 {
+			t.Errorf("got %d clicks, want %d", got.Clicks, 3)
+		}
+	})
+
+	t.Run("increments variant click counts", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		info := link.Info{Clicks: 1, Variants: []link.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1, Clicks: 5},
+		}}
+		mustSaveInfo(t, store, "abc123", info)
+
+		for _, variant := range []string{"a", "b", "a", "gone"} {
+			if err := store.IncrementVariantClicks(ctx, "abc123", variant); err != nil {
+				t.Fatalf("failed to increment clicks of %s: %v", variant, err)
+			}
+		}
+		want := info.Clone()
+		want.Clicks, want.Variants[0].Clicks, want.Variants[1].Clicks = 5, 2, 6
+		assertInfo(t, store, "abc123", want)
+	})
+
+	t.Run("saving info keeps the click counters", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		info := link.Info{Variants: []link.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		}}
+		mustSaveInfo(t, store, "abc123", info)
+		// read before the clicks, as an edit racing them would
+		stale, err := store.GetInfo(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("failed to get info: %v", err)
+		}
+		for _, variant := range []string{"a", "b", "b"} {
+			if err := store.IncrementVariantClicks(ctx, "abc123", variant); err != nil {
+				t.Fatalf("failed to increment clicks of %s: %v", variant, err)
+			}
+		}
+
+		// b is renamed c, and c starts from no clicks
+		stale.Title, stale.Variants[1].Name = "Edited", "c"
+		if err := store.SaveInfo(ctx, "abc123", stale); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		want := stale.Clone()
+		want.Clicks, want.Variants[0].Clicks = 3, 1
+		assertInfo(t, store, "abc123", want)
+
+		// a variant dropped and added back does not bring its clicks back
+		if err := store.SaveInfo(ctx, "abc123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		want = info.Clone()
+		want.Clicks, want.Variants[0].Clicks = 3, 1
+		assertInfo(t, store, "abc123", want)
+	})
+
+	t.Run("adds clicks without a limit", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		info := link.Info{MaxClicks: 2, Variants: []link.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		}}
+		if err := store.SaveInfo(ctx, "abc123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+
+		if err := store.AddClicks(ctx, "abc123", 5, map[string]int{"a": 4, "gone": 1}); err != nil {
+			t.Fatalf("failed to add clicks: %v", err)
+		}
+		if err := store.AddClicks(ctx, "abc123", -1, map[string]int{"a": -2, "b": 1}); err != nil {
+			t.Fatalf("failed to add clicks: %v", err)
+		}
+		want := info.Clone()
+		want.Clicks, want.Variants[0].Clicks, want.Variants[1].Clicks = 4, 2, 1
+		assertInfo(t, store, "abc123", want)
+
+		assertErrorIs(t, store.AddClicks(ctx, "xyz123", 1, nil), ErrShortCodeNotFound)
+	})
+
+	t.Run("stops counting clicks at the limit", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+		info := link.Info{MaxClicks: 5, Variants: []link.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		}}
+		if err := store.SaveInfo(ctx, "abc123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+
+		errs := runConcurrently(20, func(i int) error {
+			if i%2 == 0 {
+				return store.IncrementClicks(ctx, "abc123")
+			}
+			return store.IncrementVariantClicks(ctx, "abc123", "a")
+		})
+		allowed := 0
+		for _, err := range errs {
+			if err == nil {
+				allowed++
+			} else if !errors.Is(err, ErrClickLimitReached) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if allowed != 5 {
+			t.Errorf("got %d clicks through, want 5", allowed)
+		}
+		got, _ := store.GetInfo(ctx, "abc123")
+		if got.Clicks != 5 || !got.Exhausted() {
+			t.Errorf("got %d clicks, want 5", got.Clicks)
+		}
+		assertErrorIs(t, store.IncrementClicks(ctx, "abc123"), ErrClickLimitReached)
+	})
+
+	t.Run("round-trips unicode and very long URLs", func(t *testing.T) {
+		store := u.NewStore()
+		urls := map[string]string{
+			"uni123": "https://例子.测试/パス?q=ünïcödé&emoji=🚀#фрагмент",
+			"long12": "https://example.com/" + strings.Repeat("a/b?c=d&", 8*1024),
+		}
+
+		for code, url := range urls {
+			mustSave(t, store, code, url)
+		}
+		for code, url := range urls {
+			assertURL(t, store, code, url)
+		}
+
+		info := link.Info{Title: "Ünïcödé 🚀 título"}
+		if err := store.SaveInfo(ctx, "uni123", info); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		assertInfo(t, store, "uni123", info)
+	})
+
+	t.Run("concurrent saves of distinct codes are all kept", func(t *testing.T) {
+		store := u.NewStore()
+
+		errs := runConcurrently(contractWorkers, func(i int) error {
+			return store.Save(ctx, fmt.Sprintf("code%03d", i), fmt.Sprintf("https://example.com/%d", i))
+		})
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("save %d failed: %v", i, err)
+			}
+		}
+		for i := range contractWorkers {
+			assertURL(t, store, fmt.Sprintf("code%03d", i), fmt.Sprintf("https://example.com/%d", i))
+		}
+	})
+
+	t.Run("exactly one concurrent save of a code wins", func(t *testing.T) {
+		store := u.NewStore()
+
+		errs := runConcurrently(contractWorkers, func(i int) error {
+			return store.Save(ctx, "abc123", fmt.Sprintf("https://example.com/%d", i))
+		})
+
+		winners := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				winners++
+			case !errors.Is(err, ErrShortCodeExists):
+				t.Errorf("got error %v, want %v", err, ErrShortCodeExists)
+			}
+		}
+		if winners != 1 {
+			t.Errorf("got %d successful saves, want 1", winners)
+		}
+	})
+
+	t.Run("concurrent clicks are all counted", func(t *testing.T) {
+		store := u.NewStore()
+		mustSave(t, store, "abc123", "https://example.com")
+
+		for _, err := range runConcurrently(contractWorkers, func(int) error {
+			return store.IncrementClicks(ctx, "abc123")
+		}) {
+			if err != nil {
+				t.Errorf("failed to increment clicks: %v", err)
+			}
+		}
+
+		if got, _ := store.GetInfo(ctx, "abc123"); got.Clicks != contractWorkers {
+			t.Errorf("got %d clicks, want %d", got.Clicks, contractWorkers)
+		}
+	})
+
+	t.Run("handles a large dataset", func(t *testing.T) {
+		store := u.NewStore()
+		size := contractDatasetSize
+		if testing.Short() {
+			size = contractShortDatasetSize
+		}
+
+		for i := range size {
+			mustSave(t, store, fmt.Sprintf("big%05d", i), fmt.Sprintf("https://example.com/page/%d", i))
+		}
+		for i := range size {
+			assertURL(t, store, fmt.Sprintf("big%05d", i), fmt.Sprintf("https://example.com/page/%d", i))
+		}
+	})
+
+	t.Run("persists across reopen", func(t *testing.T) {
+		if u.Reopen == nil {
+			t.Skip("backend does not persist")
+		}
+		store := u.NewStore()
+		info := link.Info{Title: "Example", CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), Clicks: 7}
+		mustSave(t, store, "abc123", "https://example.com")
+		mustSaveInfo(t, store, "abc123", info)
+
+		reopened := u.Reopen(store)
+
+		assertURL(t, reopened, "abc123", "https://example.com")
+		assertInfo(t, reopened, "abc123", info)
+		assertErrorIs(t, reopened.Save(ctx, "abc123", "https://example.org"), ErrShortCodeExists)
+	})
+
+	t.Run("no info for unknown short code", func(t *testing.T) {
+		store := u.NewStore()
+
+		_, err := store.GetInfo(ctx, "xyz123")
+		assertErrorIs(t, err, ErrShortCodeNotFound)
+		assertErrorIs(t, store.SaveInfo(ctx, "xyz123", link.Info{}), ErrShortCodeNotFound)
+		assertErrorIs(t, store.IncrementClicks(ctx, "xyz123"), ErrShortCodeNotFound)
+		assertErrorIs(t, store.IncrementVariantClicks(ctx, "xyz123", "a"), ErrShortCodeNotFound)
+	})
+}
+
+// runConcurrently calls fn with 0..n-1 from n goroutines released together
+// and returns their errors by index.
+func runConcurrently(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func mustSave(t testing.TB, store URLStore, shortCode, originalUrl string) {
+	t.Helper()
+	if err := store.Save(context.Background(), shortCode, originalUrl); err != nil {
+		t.Fatalf("failed to save %q: %v", shortCode, err)
+	}
+}
+
+// mustSaveInfo saves info along with its click counters, which SaveInfo
+// leaves alone.
+func mustSaveInfo(t testing.TB, store URLStore, shortCode string, info link.Info) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.SaveInfo(ctx, shortCode, info); err != nil {
+		t.Fatalf("failed to save info: %v", err)
+	}
+	if err := SetClicks(ctx, store, shortCode, link.Info{}, info); err != nil {
+		t.Fatalf("failed to set clicks: %v", err)
+	}
+}
+
+func assertURL(t testing.TB, store URLStore, shortCode, want string) {
+	t.Helper()
+	got, err := store.GetOriginalURL(context.Background(), shortCode)
+	if err != nil {
+		t.Fatalf("short code %q should be found: %v", shortCode, err)
+	}
+	if got != want {
+		t.Errorf("got %q for %q, want %q", got, shortCode, want)
+	}
+}
+
+func assertInfo(t testing.TB, store URLStore, shortCode string, want link.Info) {
+	t.Helper()
+	got, err := store.GetInfo(context.Background(), shortCode)
+	if err != nil {
+		t.Fatalf("link info for %q should be found: %v", shortCode, err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func assertCodes(t testing.TB, links []link.Link, want ...string) {
+	t.Helper()
+	got := make([]string, len(links))
+	for i, l := range links {
+		got[i] = l.Code
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got links %v, want %v", got, want)
+	}
+}
+
+func assertErrorIs(t testing.TB, got, want error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Errorf("got error %v, want %v", got, want)
+	}
 }
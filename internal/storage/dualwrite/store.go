@@ -0,0 +1,118 @@
+// Package dualwrite mirrors writes to a second backend while a deployment
+// moves to it, see migrate.Copy for bringing the existing links across.
+package dualwrite
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+// Store serves every read from primary and sends every write to primary and
+// then to secondary. Primary stays the source of truth: a write it rejects is
+// not mirrored, and a failed mirror is logged and counted rather than failing
+// the request, leaving the next migration run to repair secondary.
+//
+// A link the migration has not copied yet is missing from secondary, so
+// writes to it there fail with storage.ErrShortCodeNotFound, and a new link
+// the migration copied first is already there. Neither counts as a failure.
+type Store struct {
+	primary   storage.URLStore
+	secondary storage.URLStore
+	logger    *slog.Logger
+}
+
+func New(primary, secondary storage.URLStore, logger *slog.Logger) *Store {
+	return &Store{primary: primary, secondary: secondary, logger: logger}
+}
+
+func (s *Store) Exists(ctx context.Context, shortCode string) (bool, error) {
+	return s.primary.Exists(ctx, shortCode)
+}
+
+func (s *Store) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	return s.primary.GetOriginalURL(ctx, shortCode)
+}
+
+func (s *Store) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	return s.primary.GetInfo(ctx, shortCode)
+}
+
+func (s *Store) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	return s.primary.List(ctx, filter)
+}
+
+// Ping only checks primary, so an unavailable secondary does not take the
+// service out of rotation.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.primary.Ping(ctx)
+}
+
+func (s *Store) Save(ctx context.Context, shortCode, originalUrl string) error {
+	if err := s.primary.Save(ctx, shortCode, originalUrl); err != nil {
+		return err
+	}
+	s.mirror(ctx, "Save", shortCode, s.secondary.Save(ctx, shortCode, originalUrl))
+	return nil
+}
+
+func (s *Store) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	if err := s.primary.UpdateURL(ctx, shortCode, originalUrl); err != nil {
+		return err
+	}
+	s.mirror(ctx, "UpdateURL", shortCode, s.secondary.UpdateURL(ctx, shortCode, originalUrl))
+	return nil
+}
+
+func (s *Store) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	if err := s.primary.SaveInfo(ctx, shortCode, info); err != nil {
+		return err
+	}
+	s.mirror(ctx, "SaveInfo", shortCode, s.secondary.SaveInfo(ctx, shortCode, info))
+	return nil
+}
+
+func (s *Store) IncrementClicks(ctx context.Context, shortCode string) error {
+	if err := s.primary.IncrementClicks(ctx, shortCode); err != nil {
+		return err
+	}
+	s.mirror(ctx, "IncrementClicks", shortCode, s.secondary.IncrementClicks(ctx, shortCode))
+	return nil
+}
+
+func (s *Store) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	if err := s.primary.IncrementVariantClicks(ctx, shortCode, variant); err != nil {
+		return err
+	}
+	s.mirror(ctx, "IncrementVariantClicks", shortCode, s.secondary.IncrementVariantClicks(ctx, shortCode, variant))
+	return nil
+}
+
+func (s *Store) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	if err := s.primary.AddClicks(ctx, shortCode, clicks, variantClicks); err != nil {
+		return err
+	}
+	s.mirror(ctx, "AddClicks", shortCode, s.secondary.AddClicks(ctx, shortCode, clicks, variantClicks))
+	return nil
+}
+
+func (s *Store) Delete(ctx context.Context, shortCode string) error {
+	if err := s.primary.Delete(ctx, shortCode); err != nil {
+		return err
+	}
+	s.mirror(ctx, "Delete", shortCode, s.secondary.Delete(ctx, shortCode))
+	return nil
+}
+
+func (s *Store) mirror(ctx context.Context, op, shortCode string, err error) {
+	if err == nil || errors.Is(err, storage.ErrShortCodeNotFound) || errors.Is(err, storage.ErrShortCodeExists) ||
+		errors.Is(err, storage.ErrClickLimitReached) {
+		return
+	}
+	metrics.DualWriteFailures.Inc(op)
+	s.logger.WarnContext(ctx, "failed to mirror write to secondary storage", "op", op, "short_code", shortCode, "error", err)
+}
@@ -0,0 +1,132 @@
+package dualwrite_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/dualwrite"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+)
+
+// failingStore fails every write with err when it is set.
+type failingStore struct {
+	*memory.MemoryDB
+	err error
+}
+
+func (f *failingStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemoryDB.Save(ctx, shortCode, originalUrl)
+}
+
+func (f *failingStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.MemoryDB.SaveInfo(ctx, shortCode, info)
+}
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestDualWriteStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("mirrors writes to the secondary", func(t *testing.T) {
+		primary, secondary := memory.New(), memory.New()
+		store := dualwrite.New(primary, secondary, discard)
+
+		assertNoErr(t, store.Save(ctx, "abc123", "https://example.com"))
+		assertNoErr(t, store.SaveInfo(ctx, "abc123", link.Info{Title: "Example"}))
+		assertNoErr(t, store.IncrementClicks(ctx, "abc123"))
+
+		for name, backend := range map[string]storage.URLStore{"primary": primary, "secondary": secondary} {
+			info, err := backend.GetInfo(ctx, "abc123")
+			assertNoErr(t, err)
+			if info.Title != "Example" || info.Clicks != 1 {
+				t.Errorf("%s: got %+v", name, info)
+			}
+		}
+
+		assertNoErr(t, store.Delete(ctx, "abc123"))
+		if exists, _ := secondary.Exists(ctx, "abc123"); exists {
+			t.Error("secondary still holds the deleted link")
+		}
+	})
+
+	t.Run("tolerates links the secondary does not hold yet", func(t *testing.T) {
+		primary := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
+		store := dualwrite.New(primary, memory.New(), discard)
+		failures := metrics.DualWriteFailures.Value("IncrementClicks")
+
+		assertNoErr(t, store.IncrementClicks(ctx, "abc123"))
+		assertNoErr(t, store.Delete(ctx, "abc123"))
+		if got := metrics.DualWriteFailures.Value("IncrementClicks") - failures; got != 0 {
+			t.Errorf("got %v failures, want 0", got)
+		}
+	})
+
+	t.Run("a failing secondary does not fail the write", func(t *testing.T) {
+		primary := memory.New()
+		secondary := &failingStore{MemoryDB: memory.New(), err: errors.New("connection refused")}
+		store := dualwrite.New(primary, secondary, discard)
+		failures := metrics.DualWriteFailures.Value("Save")
+
+		assertNoErr(t, store.Save(ctx, "abc123", "https://example.com"))
+		if got := metrics.DualWriteFailures.Value("Save") - failures; got != 1 {
+			t.Errorf("got %v failures, want 1", got)
+		}
+		url, err := primary.GetOriginalURL(ctx, "abc123")
+		assertNoErr(t, err)
+		if url != "https://example.com" {
+			t.Errorf("got %q", url)
+		}
+	})
+
+	t.Run("a secondary link at its click limit is not a failure", func(t *testing.T) {
+		primary := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
+		secondary := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
+		store := dualwrite.New(primary, secondary, discard)
+		assertNoErr(t, store.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 1}))
+		assertNoErr(t, secondary.IncrementClicks(ctx, "abc123"))
+		failures := metrics.DualWriteFailures.Value("IncrementClicks")
+
+		assertNoErr(t, store.IncrementClicks(ctx, "abc123"))
+		if got := metrics.DualWriteFailures.Value("IncrementClicks") - failures; got != 0 {
+			t.Errorf("got %v failures, want 0", got)
+		}
+	})
+
+	t.Run("a write the primary rejects is not mirrored", func(t *testing.T) {
+		primary := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
+		secondary := memory.New()
+		store := dualwrite.New(primary, secondary, discard)
+
+		if err := store.Save(ctx, "abc123", "https://other.example"); !errors.Is(err, storage.ErrShortCodeExists) {
+			t.Errorf("got %v, want %v", err, storage.ErrShortCodeExists)
+		}
+		if exists, _ := secondary.Exists(ctx, "abc123"); exists {
+			t.Error("secondary saved a link the primary rejected")
+		}
+	})
+}
+
+func TestDualWriteContract(t *testing.T) {
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore { return dualwrite.New(memory.New(), memory.New(), discard) },
+	}.Test(t)
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// maxLockBackoff caps the wait between attempts to take a contended lock.
+const maxLockBackoff = 10 * time.Millisecond
+
+// lockFile takes an advisory flock on f, shared for readers and exclusive for
+// writers, retrying until it is free or ctx is done. Processes that do not
+// take the lock are not kept out.
+func lockFile(ctx context.Context, f *os.File, exclusive bool) (func(), error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	fd := int(f.Fd())
+	backoff := 100 * time.Microsecond
+	for {
+		err := syscall.Flock(fd, how|syscall.LOCK_NB)
+		if err == nil {
+			return func() { syscall.Flock(fd, syscall.LOCK_UN) }, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxLockBackoff)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build unix
+
+package file_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/file"
+)
+
+const (
+	writerPathEnv  = "FILE_STORE_WRITER_PATH"
+	writerIDEnv    = "FILE_STORE_WRITER_ID"
+	writers        = 4
+	writesPerProc  = 50
+	sharedLinkCode = "shared"
+)
+
+// TestFileStoreWriterProcess is the body of the writer processes started by
+// TestFileStoreMultiProcess and does nothing when run directly.
+func TestFileStoreWriterProcess(t *testing.T) {
+	path := os.Getenv(writerPathEnv)
+	if path == "" {
+		t.Skip("only runs as a child of TestFileStoreMultiProcess")
+	}
+	ctx := context.Background()
+	store := openStore(t, path)
+	id := os.Getenv(writerIDEnv)
+	for i := range writesPerProc {
+		if err := store.Save(ctx, fmt.Sprintf("p%s-%03d", id, i), "https://example.com/"+id); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		if err := store.IncrementClicks(ctx, sharedLinkCode); err != nil {
+			t.Fatalf("failed to increment clicks: %v", err)
+		}
+	}
+}
+
+func TestFileStoreMultiProcess(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+	observer := openStore(t, path)
+	if err := observer.Save(ctx, sharedLinkCode, "https://example.com"); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	// fill the observer's cache before the other processes write
+	if _, err := observer.GetInfo(ctx, sharedLinkCode); err != nil {
+		t.Fatalf("failed to get info: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for id := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFileStoreWriterProcess$")
+			cmd.Env = append(os.Environ(), writerPathEnv+"="+path, writerIDEnv+"="+strconv.Itoa(id))
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("writer %d failed: %v\n%s", id, err, out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for name, store := range map[string]*file.FileStore{"observer": observer, "fresh": openStore(t, path)} {
+		for id := range writers {
+			for i := range writesPerProc {
+				code := fmt.Sprintf("p%d-%03d", id, i)
+				if exists, err := store.Exists(ctx, code); err != nil || !exists {
+					t.Fatalf("%s: lost %s (%v)", name, code, err)
+				}
+			}
+		}
+		info, err := store.GetInfo(ctx, sharedLinkCode)
+		if err != nil {
+			t.Fatalf("%s: failed to get info: %v", name, err)
+		}
+		if info.Clicks != writers*writesPerProc {
+			t.Errorf("%s: got %d clicks, want %d", name, info.Clicks, writers*writesPerProc)
+		}
+	}
+}
+
+func TestFileStoreConcurrentHandles(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+
+	var wg sync.WaitGroup
+	for id := range writers {
+		store := openStore(t, path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range writesPerProc {
+				if err := store.Save(ctx, fmt.Sprintf("h%d-%03d", id, i), "https://example.com"); err != nil {
+					t.Errorf("failed to save: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	links, err := openStore(t, path).List(ctx, storage.ListFilter{})
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(links) != writers*writesPerProc {
+		t.Errorf("got %d links, want %d", len(links), writers*writesPerProc)
+	}
+}
+
+func openStore(t testing.TB, path string) *file.FileStore {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return file.NewFileStore(f)
+}
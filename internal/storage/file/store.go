@@ -1,72 +1,332 @@
 package file
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"os"
+	"sync"
+	"time"
 
-	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
 )
 
+// FileStore keeps links in a JSON file encoded by link.Encode. Files in the
+// older format of a short code to URL object still load and are rewritten in
+// the current format on the next write.
+//
+// When Database is an *os.File several processes can share it: reads take a
+// shared flock and writes an exclusive one, and every write re-reads the file
+// first so no other process's links are lost. Lookups are served from the
+// last read until the file's size or modification time changes.
 type FileStore struct {
+	mu       sync.Mutex
 	Database io.ReadWriteSeeker
-	urls     handler.URL
+
+	cached   linkSet
+	version  fileVersion
+	loadedAt time.Time
+}
+
+// fileVersion identifies the contents of the file without reading it.
+type fileVersion struct {
+	size    int64
+	modTime time.Time
 }
 
-func (f *FileStore) Exists(shortCode string) (bool, error) {
-	err := f.loadFromCache()
+// racyWindow is how long after a write the modification time is not trusted
+// to tell versions apart, as filesystems with coarse timestamps give two
+// writes in quick succession the same one.
+const racyWindow = time.Second
+
+type linkSet map[string]link.Link
+
+func (f *FileStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	links, err := f.load(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to execute method Exists(%q): %v", shortCode, err)
+		return false, fmt.Errorf("failed to execute method Exists(%q): %w", shortCode, err)
 	}
-	_, exists := f.urls[shortCode]
+	_, exists := links[shortCode]
 	return exists, nil
 }
 
-func (f *FileStore) GetOriginalURL(shortCode string) (string, error) {
-	err := f.loadFromCache()
+func (f *FileStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	links, err := f.load(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get original url from short code %q: %v", shortCode, err)
+		return "", fmt.Errorf("failed to get original url from short code %q: %w", shortCode, err)
+	}
+	l, exists := links[shortCode]
+	if !exists {
+		return "", storage.ErrShortCodeNotFound
 	}
+	return l.URL, nil
+}
 
-	return f.urls[shortCode], nil
+func (f *FileStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	return f.update(ctx, func(links linkSet) error {
+		if _, exists := links[shortCode]; exists {
+			return storage.ErrShortCodeExists
+		}
+		links[shortCode] = link.New(shortCode, originalUrl, link.Info{})
+		return nil
+	})
+}
+
+func (f *FileStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	return f.update(ctx, func(links linkSet) error {
+		l, exists := links[shortCode]
+		if !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		l.URL = originalUrl
+		links[shortCode] = l
+		return nil
+	})
 }
 
-func (f *FileStore) Save(shortCode, originalUrl string) error {
-	urls, err := f.loadFromDisk()
+func (f *FileStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	links, err := f.load(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to loadFromDisk before saving: %v", err)
+		return link.Info{}, fmt.Errorf("failed to get info for short code %q: %w", shortCode, err)
 	}
+	l, exists := links[shortCode]
+	if !exists {
+		return link.Info{}, storage.ErrShortCodeNotFound
+	}
+	return l.Info.Clone(), nil
+}
 
-	urls[shortCode] = originalUrl
+func (f *FileStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	return f.update(ctx, func(links linkSet) error {
+		l, exists := links[shortCode]
+		if !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		l.Info = info.KeepClicks(l.Info)
+		links[shortCode] = l
+		return nil
+	})
+}
 
-	// seek to the beginning and rewrite
-	f.Database.Seek(0, io.SeekStart)
-	err = json.NewEncoder(f.Database).Encode(&urls)
+func (f *FileStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	return f.update(ctx, func(links linkSet) error {
+		l, exists := links[shortCode]
+		if !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		if l.Exhausted() {
+			return storage.ErrClickLimitReached
+		}
+		l.Clicks++
+		links[shortCode] = l
+		return nil
+	})
+}
+
+func (f *FileStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	return f.update(ctx, func(links linkSet) error {
+		l, exists := links[shortCode]
+		if !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		if l.Exhausted() {
+			return storage.ErrClickLimitReached
+		}
+		l.CountVariantClick(variant)
+		links[shortCode] = l
+		return nil
+	})
+}
+
+func (f *FileStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	return f.update(ctx, func(links linkSet) error {
+		l, exists := links[shortCode]
+		if !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		l.AddClicks(clicks, variantClicks)
+		links[shortCode] = l
+		return nil
+	})
+}
 
+func (f *FileStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	links, err := f.load(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to encode: %v", err)
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	var matched []link.Link
+	for _, l := range links {
+		if filter.Matches(l) {
+			l.Info = l.Info.Clone()
+			matched = append(matched, l)
+		}
+	}
+	return filter.Page(matched), nil
+}
+
+// ReadAll reads the file once under a shared lock.
+func (f *FileStore) ReadAll(ctx context.Context, fn func(link.Link) error) error {
+	links, err := f.List(ctx, storage.ListFilter{})
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if err := fn(l); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (f *FileStore) Delete(ctx context.Context, shortCode string) error {
+	return f.update(ctx, func(links linkSet) error {
+		if _, exists := links[shortCode]; !exists {
+			return storage.ErrShortCodeNotFound
+		}
+		delete(links, shortCode)
+		return nil
+	})
+}
+
+// Ping checks the backing file can still be read and decoded.
+func (f *FileStore) Ping(ctx context.Context) error {
+	_, err := f.load(ctx)
 	return err
 }
 
-func (f *FileStore) loadFromDisk() (handler.URL, error) {
-	f.Database.Seek(0, io.SeekStart)
-	urls, err := handler.NewUrls(f.Database)
+// load returns the links in the file, re-reading it only if it changed since
+// the last read. The result is shared and must not be modified.
+func (f *FileStore) load(ctx context.Context) (linkSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	unlock, err := f.lock(ctx, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read urls: %v", err)
+		return nil, err
+	}
+	defer unlock()
+
+	version, ok := f.stat()
+	if ok && f.cached != nil && version == f.version && f.loadedAt.Sub(version.modTime) > racyWindow {
+		return f.cached, nil
 	}
-	return urls, err
+	links, err := f.loadFromDisk()
+	if err != nil {
+		return nil, err
+	}
+	f.remember(links, version, ok)
+	return links, nil
+}
+
+// update applies change to the file contents under the lock and writes them
+// back. It always re-reads the file, as another process may have written it.
+func (f *FileStore) update(ctx context.Context, change func(linkSet) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	unlock, err := f.lock(ctx, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	links, err := f.loadFromDisk()
+	if err != nil {
+		return fmt.Errorf("failed to loadFromDisk before saving: %w", err)
+	}
+	if err := change(links); err != nil {
+		return err
+	}
+	if err := f.writeToDisk(links); err != nil {
+		// the file may be half written, read it again next time
+		f.cached = nil
+		return err
+	}
+	version, ok := f.stat()
+	f.remember(links, version, ok)
+	return nil
 }
 
-// Behaves like a read through cache
-func (f *FileStore) loadFromCache() error {
-	if f.urls == nil {
-		// force load from disk
-		urls, err := f.loadFromDisk()
+func (f *FileStore) lock(ctx context.Context, exclusive bool) (func(), error) {
+	file, ok := f.Database.(*os.File)
+	if !ok {
+		return func() {}, nil
+	}
+	unlock, err := lockFile(ctx, file, exclusive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", file.Name(), err)
+	}
+	return unlock, nil
+}
+
+// stat reports the version of the file, or false if Database cannot say.
+func (f *FileStore) stat() (fileVersion, bool) {
+	statter, ok := f.Database.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return fileVersion{}, false
+	}
+	info, err := statter.Stat()
+	if err != nil {
+		return fileVersion{}, false
+	}
+	return fileVersion{size: info.Size(), modTime: info.ModTime()}, true
+}
+
+func (f *FileStore) remember(links linkSet, version fileVersion, ok bool) {
+	if !ok {
+		f.cached = nil
+		return
+	}
+	f.cached, f.version, f.loadedAt = links, version, time.Now()
+}
+
+func (f *FileStore) loadFromDisk() (linkSet, error) {
+	if _, err := f.Database.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+	decoded, err := link.Decode(f.Database)
+	if err != nil {
+		return nil, err
+	}
+	links := make(linkSet, len(decoded))
+	for _, l := range decoded {
+		links[l.Code] = l
+	}
+	return links, nil
+}
+
+func (f *FileStore) writeToDisk(links linkSet) error {
+	// seek to the beginning and rewrite
+	if _, err := f.Database.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+	all := make([]link.Link, 0, len(links))
+	for _, l := range links {
+		all = append(all, l)
+	}
+	buffered := bufio.NewWriter(f.Database)
+	if err := link.Encode(buffered, all); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
+	// drop leftovers of a longer previous version of the file
+	if t, ok := f.Database.(interface{ Truncate(int64) error }); ok {
+		end, err := f.Database.Seek(0, io.SeekCurrent)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to seek: %w", err)
+		}
+		if err := t.Truncate(end); err != nil {
+			return fmt.Errorf("failed to truncate: %w", err)
 		}
-		f.urls = urls
 	}
 	return nil
 }
@@ -74,3 +334,13 @@ func (f *FileStore) loadFromCache() error {
 func NewFileStore(database io.ReadWriteSeeker) *FileStore {
 	return &FileStore{Database: database}
 }
+
+// Close closes the underlying database if it can be closed.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.Database.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package file_test
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"testing"
 
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
 	"github.com/sotiri-geo/url-shortener/internal/storage/file"
 )
 
@@ -14,6 +17,7 @@ import (
 */
 
 func TestFileStore(t *testing.T) {
+	ctx := context.Background()
 
 	t.Run("read file and check short code exists", func(t *testing.T) {
 		// setup
@@ -25,7 +29,7 @@ func TestFileStore(t *testing.T) {
 		fs := file.NewFileStore(f)
 
 		// execute
-		shortCodeExists, err := fs.Exists(shortCode)
+		shortCodeExists, err := fs.Exists(ctx, shortCode)
 
 		if err != nil {
 			t.Fatalf("failed to execute exists: %v", err)
@@ -44,7 +48,7 @@ func TestFileStore(t *testing.T) {
 		defer cleanDatabase()
 
 		fs := file.NewFileStore(f)
-		gotUrl, err := fs.GetOriginalURL(shortCode)
+		gotUrl, err := fs.GetOriginalURL(ctx, shortCode)
 
 		if err != nil {
 			t.Fatalf("failed to get original url from store: %v", err)
@@ -61,14 +65,14 @@ func TestFileStore(t *testing.T) {
 		tmpFile, cleanDatabase := createTempFile(t, `{"xyz123": "https://google.com"}`)
 		defer cleanDatabase()
 		fs := file.NewFileStore(tmpFile)
-		err := fs.Save(shortCode, originalUrl)
+		err := fs.Save(ctx, shortCode, originalUrl)
 
 		if err != nil {
 			t.Fatalf("failed during save: %v", err)
 		}
 
 		// assert - short code exists
-		shortCodeExists, err := fs.Exists(shortCode)
+		shortCodeExists, err := fs.Exists(ctx, shortCode)
 		if err != nil {
 			t.Fatalf("failed to check for existance: %v", err)
 		}
@@ -77,6 +81,45 @@ func TestFileStore(t *testing.T) {
 		}
 	})
 
+	t.Run("ping reads the backing file", func(t *testing.T) {
+		f, cleanDatabase := createTempFile(t, `{"abc123": "https://example.com"}`)
+		defer cleanDatabase()
+		fs := file.NewFileStore(f)
+
+		if err := fs.Ping(ctx); err != nil {
+			t.Errorf("ping failed: %v", err)
+		}
+	})
+
+	t.Run("ping fails on a corrupted file", func(t *testing.T) {
+		f, cleanDatabase := createTempFile(t, `{"abc123": `)
+		defer cleanDatabase()
+		fs := file.NewFileStore(f)
+
+		if err := fs.Ping(ctx); err == nil {
+			t.Error("ping should fail when the file cannot be decoded")
+		}
+	})
+}
+
+func TestFileStoreRewritesLegacyFiles(t *testing.T) {
+	ctx := context.Background()
+	f, cleanDatabase := createTempFile(t, `{"abc123": "https://example.com"}`)
+	defer cleanDatabase()
+	fs := file.NewFileStore(f)
+
+	if err := fs.Save(ctx, "xyz123", "https://google.com"); err != nil {
+		t.Fatalf("failed during save: %v", err)
+	}
+
+	f.Seek(0, io.SeekStart)
+	links, err := link.Decode(f)
+	if err != nil {
+		t.Fatalf("failed to decode rewritten file: %v", err)
+	}
+	if len(links) != 2 || links[0].Code != "abc123" || links[1].Code != "xyz123" {
+		t.Errorf("got links %+v", links)
+	}
 }
 
 func createTempFile(t testing.TB, initialData string) (io.ReadWriteSeeker, func()) {
@@ -99,3 +142,49 @@ func createTempFile(t testing.TB, initialData string) (io.ReadWriteSeeker, func(
 	}
 	return tmpfile, removeFile
 }
+
+func TestFileStoreSeesNewWrites(t *testing.T) {
+	ctx := context.Background()
+	f, cleanDatabase := createTempFile(t, `{"xyz123": "https://google.com"}`)
+	defer cleanDatabase()
+	reader := file.NewFileStore(f)
+	writer := file.NewFileStore(f)
+
+	// prime any read state before the other store writes
+	if _, err := reader.Exists(ctx, "abc123"); err != nil {
+		t.Fatalf("failed to check for existence: %v", err)
+	}
+	if err := writer.Save(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatalf("failed during save: %v", err)
+	}
+
+	got, err := reader.GetOriginalURL(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("failed to get original url: %v", err)
+	}
+	if got != "https://example.com" {
+		t.Errorf("got %q, want %q", got, "https://example.com")
+	}
+}
+
+func TestFileStoreContract(t *testing.T) {
+	dir := t.TempDir()
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore {
+			f, err := os.CreateTemp(dir, "db")
+			if err != nil {
+				t.Fatalf("could not create temp file: %v", err)
+			}
+			t.Cleanup(func() { f.Close() })
+			return file.NewFileStore(f)
+		},
+		Reopen: func(store storage.URLStore) storage.URLStore {
+			f, err := os.OpenFile(store.(*file.FileStore).Database.(*os.File).Name(), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("could not reopen file: %v", err)
+			}
+			t.Cleanup(func() { f.Close() })
+			return file.NewFileStore(f)
+		},
+	}.Test(t)
+}
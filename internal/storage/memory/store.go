@@ -1,37 +1,198 @@
 package memory
 
-import "errors"
+import (
+	"context"
+	"sync"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
 
 type MemoryDB struct {
+	mu sync.RWMutex
 	// shortCode -> OriginalUrl
 	urls map[string]string
+	// shortCode -> metadata
+	info map[string]link.Info
 }
 
-var ErrShortCodeExists = errors.New("short code already exists in store")
-
 func New() *MemoryDB {
-	return &MemoryDB{urls: make(map[string]string)}
+	return NewWithData(make(map[string]string))
 }
 
 func NewWithData(urls map[string]string) *MemoryDB {
-	return &MemoryDB{urls}
+	return &MemoryDB{urls: urls, info: make(map[string]link.Info)}
 }
 
-func (m *MemoryDB) GetOriginalURL(shortCode string) (string, bool) {
+func (m *MemoryDB) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	original, exists := m.urls[shortCode]
-	return original, exists
+	if !exists {
+		return "", storage.ErrShortCodeNotFound
+	}
+	return original, nil
+}
+
+func (m *MemoryDB) Save(ctx context.Context, shortCode, originalUrl string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; exists {
+		return storage.ErrShortCodeExists
+	}
+	m.urls[shortCode] = originalUrl
+	return nil
 }
 
-func (m *MemoryDB) Save(shortCode, originalUrl string) error {
-	_, exists := m.GetOriginalURL(shortCode)
-	if exists {
-		return ErrShortCodeExists
+func (m *MemoryDB) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
 	}
 	m.urls[shortCode] = originalUrl
 	return nil
 }
 
-func (m *MemoryDB) Exists(shortCode string) bool {
+func (m *MemoryDB) Exists(ctx context.Context, shortCode string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	_, exists := m.urls[shortCode]
-	return exists
+	return exists, nil
+}
+
+func (m *MemoryDB) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *MemoryDB) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	if err := ctx.Err(); err != nil {
+		return link.Info{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return link.Info{}, storage.ErrShortCodeNotFound
+	}
+	return m.info[shortCode].Clone(), nil
+}
+
+func (m *MemoryDB) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	m.info[shortCode] = info.KeepClicks(m.info[shortCode]).Clone()
+	return nil
+}
+
+func (m *MemoryDB) IncrementClicks(ctx context.Context, shortCode string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	info := m.info[shortCode]
+	if info.Exhausted() {
+		return storage.ErrClickLimitReached
+	}
+	info.Clicks++
+	m.info[shortCode] = info
+	return nil
+}
+
+func (m *MemoryDB) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	info := m.info[shortCode]
+	if info.Exhausted() {
+		return storage.ErrClickLimitReached
+	}
+	info.CountVariantClick(variant)
+	m.info[shortCode] = info
+	return nil
+}
+
+func (m *MemoryDB) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	info := m.info[shortCode]
+	info.AddClicks(clicks, variantClicks)
+	m.info[shortCode] = info
+	return nil
+}
+
+func (m *MemoryDB) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var links []link.Link
+	for shortCode, originalUrl := range m.urls {
+		l := link.New(shortCode, originalUrl, m.info[shortCode].Clone())
+		if filter.Matches(l) {
+			links = append(links, l)
+		}
+	}
+	return filter.Page(links), nil
+}
+
+// ReadAll copies the links under the lock, so fn runs without holding it.
+func (m *MemoryDB) ReadAll(ctx context.Context, fn func(link.Link) error) error {
+	links, err := m.List(ctx, storage.ListFilter{})
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDB) Delete(ctx context.Context, shortCode string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.urls[shortCode]; !exists {
+		return storage.ErrShortCodeNotFound
+	}
+	delete(m.urls, shortCode)
+	delete(m.info, shortCode)
+	return nil
 }
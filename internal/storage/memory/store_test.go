@@ -1,6 +1,7 @@
 package memory_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -9,13 +10,15 @@ import (
 )
 
 func TestMemoryDBStore(t *testing.T) {
+	ctx := context.Background()
+
 	t.Run("get original url from short code", func(t *testing.T) {
 		store := memory.NewWithData(map[string]string{"abc123": "https://example.com"})
 
-		got, exists := store.GetOriginalURL("abc123")
+		got, err := store.GetOriginalURL(ctx, "abc123")
 		want := "https://example.com"
-		if !exists {
-			t.Fatal("url should exist in store")
+		if err != nil {
+			t.Fatalf("url should exist in store: %v", err)
 		}
 		if got != want {
 			t.Errorf("got %q, want %q", got, want)
@@ -24,10 +27,10 @@ func TestMemoryDBStore(t *testing.T) {
 	t.Run("url does not exist in store", func(t *testing.T) {
 		store := memory.New()
 
-		_, found := store.GetOriginalURL("abc123")
+		_, err := store.GetOriginalURL(ctx, "abc123")
 
-		if found {
-			t.Fatal("should not find url")
+		if !errors.Is(err, storage.ErrShortCodeNotFound) {
+			t.Fatalf("should not find url: got error %v", err)
 		}
 	})
 
@@ -35,12 +38,12 @@ func TestMemoryDBStore(t *testing.T) {
 		store := memory.New()
 		shortCode := "abc123"
 		want := "https://example.com"
-		store.Save(shortCode, want)
+		store.Save(ctx, shortCode, want)
 
-		got, exists := store.GetOriginalURL(shortCode)
+		got, err := store.GetOriginalURL(ctx, shortCode)
 
-		if !exists {
-			t.Fatalf("short url %q should exist", shortCode)
+		if err != nil {
+			t.Fatalf("short url %q should exist: %v", shortCode, err)
 		}
 
 		if got != want {
@@ -52,16 +55,16 @@ func TestMemoryDBStore(t *testing.T) {
 	t.Run("handles conflicting short code", func(t *testing.T) {
 		store := memory.New()
 		shortCode, originalUrl := "abc123", "https://example.com"
-		store.Save(shortCode, originalUrl)
+		store.Save(ctx, shortCode, originalUrl)
 
 		// should fail
-		err := store.Save(shortCode, originalUrl)
+		err := store.Save(ctx, shortCode, originalUrl)
 
 		if err == nil {
 			t.Fatal("failed to raise conflicting error")
 		}
 		// integrity error
-		if !errors.Is(err, memory.ErrShortCodeExists) {
+		if !errors.Is(err, storage.ErrShortCodeExists) {
 			t.Errorf("short url %q already exists: %v", shortCode, err)
 		}
 	})
@@ -69,16 +72,16 @@ func TestMemoryDBStore(t *testing.T) {
 	t.Run("checks existence of short code", func(t *testing.T) {
 		store := memory.New()
 		shortCode, originalUrl := "abc123", "https://example.com"
-		err := store.Save(shortCode, originalUrl)
+		err := store.Save(ctx, shortCode, originalUrl)
 
 		if err != nil {
 			t.Fatal("should not fail during save")
 		}
 
-		exists := store.Exists(shortCode)
+		exists, err := store.Exists(ctx, shortCode)
 
-		if !exists {
-			t.Error("should exist in store")
+		if err != nil || !exists {
+			t.Errorf("should exist in store: %v", err)
 		}
 	})
 }
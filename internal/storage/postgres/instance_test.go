@@ -0,0 +1,90 @@
+package postgres_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testURL is the database the tests run against: POSTGRES_TEST_URL if set,
+// otherwise a throwaway instance started by TestMain. It stays empty, and
+// the tests needing it skip, only when no PostgreSQL binaries are installed.
+var testURL string
+
+func TestMain(m *testing.M) {
+	testURL = os.Getenv(testURLEnv)
+	if testURL != "" {
+		os.Exit(m.Run())
+	}
+	bin, ok := findBinaries()
+	if !ok {
+		os.Exit(m.Run())
+	}
+	dir, err := os.MkdirTemp("", "shortener-postgres-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create a directory for postgres: %v\n", err)
+		os.Exit(1)
+	}
+	stop, err := startInstance(bin, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		fmt.Fprintf(os.Stderr, "failed to start postgres from %s: %v\n", bin, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	stop()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// findBinaries returns the directory holding initdb and pg_ctl, looking on
+// PATH and then where Debian and Ubuntu install them.
+func findBinaries() (string, bool) {
+	if initdb, err := exec.LookPath("initdb"); err == nil {
+		if _, err := exec.LookPath("pg_ctl"); err == nil {
+			return filepath.Dir(initdb), true
+		}
+	}
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if _, err := os.Stat(filepath.Join(dirs[i], "pg_ctl")); err == nil {
+			return dirs[i], true
+		}
+	}
+	return "", false
+}
+
+// startInstance creates a cluster in dir and starts it listening only on a
+// socket in dir, on a free port so it never clashes with a running server.
+func startInstance(bin, dir string) (func(), error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	data := filepath.Join(dir, "data")
+	initdb := exec.Command(filepath.Join(bin, "initdb"), "-D", data, "-U", "postgres", "--auth=trust", "--no-sync")
+	if out, err := initdb.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+	options := fmt.Sprintf("-k %s -p %d -c listen_addresses='' -c fsync=off", dir, port)
+	start := exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-l", filepath.Join(dir, "log"), "-o", options, "-w", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	testURL = fmt.Sprintf("postgres://postgres@/postgres?host=%s&port=%d", dir, port)
+	return func() {
+		exec.Command(filepath.Join(bin, "pg_ctl"), "-D", data, "-m", "immediate", "-w", "stop").Run()
+	}, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
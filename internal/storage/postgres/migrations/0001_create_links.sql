@@ -0,0 +1,16 @@
+CREATE TABLE links (
+    -- the primary key doubles as the unique constraint that allocates codes
+    short_code    text PRIMARY KEY,
+    original_url  text NOT NULL,
+    title         text NOT NULL DEFAULT '',
+    created_at    timestamptz,
+    clicks        bigint NOT NULL DEFAULT 0,
+    suspicious    boolean NOT NULL DEFAULT false,
+    password_hash text NOT NULL DEFAULT ''
+);
+
+-- listing newest links first
+CREATE INDEX links_created_at_idx ON links (created_at DESC);
+
+-- analytics over the most visited links
+CREATE INDEX links_clicks_idx ON links (clicks DESC);
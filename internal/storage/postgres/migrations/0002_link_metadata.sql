@@ -0,0 +1,9 @@
+ALTER TABLE links
+    ADD COLUMN description text   NOT NULL DEFAULT '',
+    ADD COLUMN tags        text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN creator     text   NOT NULL DEFAULT '',
+    ADD COLUMN updated_at  timestamptz,
+    ADD COLUMN metadata    jsonb  NOT NULL DEFAULT '{}';
+
+-- filtering the link list by tag
+CREATE INDEX links_tags_idx ON links USING gin (tags);
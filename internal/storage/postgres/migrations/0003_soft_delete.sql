@@ -0,0 +1,4 @@
+ALTER TABLE links ADD COLUMN deleted_at timestamptz;
+
+-- listing the trash
+CREATE INDEX links_deleted_at_idx ON links (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE links
+    ADD COLUMN query        jsonb NOT NULL DEFAULT '{}',
+    ADD COLUMN query_policy text  NOT NULL DEFAULT '',
+    ADD COLUMN utm          jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN forward_path boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE links
+    ADD COLUMN variants        jsonb   NOT NULL DEFAULT '[]',
+    ADD COLUMN sticky_variants boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN max_clicks integer NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- paging compares and orders short codes bytewise, which the primary key
+-- index only serves when the column itself uses the "C" collation
+ALTER TABLE links ALTER COLUMN short_code TYPE text COLLATE "C";
+
+-- nothing reads links by creation time or clicks
+DROP INDEX links_created_at_idx;
+DROP INDEX links_clicks_idx;
@@ -0,0 +1,363 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+const (
+	DefaultMaxConns = 10
+	DefaultTimeout  = 5 * time.Second
+)
+
+type Options struct {
+	MaxConns int32
+	Timeout  time.Duration // per query
+}
+
+func DefaultOptions() Options {
+	return Options{MaxConns: DefaultMaxConns, Timeout: DefaultTimeout}
+}
+
+type PostgresStore struct {
+	pool *pgxpool.Pool
+	opts Options
+}
+
+// Open connects a pool to the database at dsn and brings its schema up to date.
+func Open(ctx context.Context, dsn string, opts Options) (*PostgresStore, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse postgres dsn: %v", err)
+	}
+	if opts.MaxConns > 0 {
+		config.MaxConns = opts.MaxConns
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	if err := Migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return New(pool, opts), nil
+}
+
+func New(pool *pgxpool.Pool, opts Options) *PostgresStore {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &PostgresStore{pool, opts}
+}
+
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+func (s *PostgresStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.opts.Timeout)
+}
+
+func (s *PostgresStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var exists bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM links WHERE short_code = $1)`, shortCode).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check short code %q: %w", shortCode, err)
+	}
+	return exists, nil
+}
+
+func (s *PostgresStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tag, err := s.pool.Exec(ctx,
+		`INSERT INTO links (short_code, original_url) VALUES ($1, $2) ON CONFLICT (short_code) DO NOTHING`,
+		shortCode, originalUrl)
+	if err != nil {
+		return fmt.Errorf("failed to save short code %q: %w", shortCode, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrShortCodeExists
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	return s.update(ctx, shortCode, `UPDATE links SET original_url = $2 WHERE short_code = $1`, originalUrl)
+}
+
+func (s *PostgresStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var originalUrl string
+	err := s.pool.QueryRow(ctx, `SELECT original_url FROM links WHERE short_code = $1`, shortCode).Scan(&originalUrl)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", storage.ErrShortCodeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get short code %q: %w", shortCode, err)
+	}
+	return originalUrl, nil
+}
+
+func (s *PostgresStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	l, err := scanLink(s.pool.QueryRow(ctx, `SELECT `+linkColumns+` FROM links WHERE short_code = $1`, shortCode))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return link.Info{}, storage.ErrShortCodeNotFound
+	}
+	if err != nil {
+		return link.Info{}, fmt.Errorf("failed to get info for short code %q: %w", shortCode, err)
+	}
+	return l.Info, nil
+}
+
+// List compares short codes bytewise like the other backends, as the column
+// uses the "C" collation whatever the database's, so each page is read in
+// order from the primary key index.
+func (s *PostgresStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	return s.list(ctx, s.pool, filter)
+}
+
+// readAllPageSize is how many links ReadAll reads per query.
+const readAllPageSize = 500
+
+// ReadAll pages through the links inside one read-only repeatable read
+// transaction, so every page sees the database as it was when the first was
+// read. Each page runs under a timeout of its own.
+func (s *PostgresStore) ReadAll(ctx context.Context, fn func(link.Link) error) error {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin reading links: %w", err)
+	}
+	// read only, so there is nothing to commit
+	defer tx.Rollback(context.Background())
+	filter := storage.ListFilter{Limit: readAllPageSize}
+	for {
+		page, err := s.list(ctx, tx, filter)
+		if err != nil {
+			return err
+		}
+		for _, l := range page {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		if len(page) < filter.Limit {
+			return nil
+		}
+		filter.After = page[len(page)-1].Code
+	}
+}
+
+// querier is a pool or a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func (s *PostgresStore) list(ctx context.Context, q querier, filter storage.ListFilter) ([]link.Link, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var tag *string
+	if filter.Tag != "" {
+		normalised := link.NormaliseTag(filter.Tag)
+		tag = &normalised
+	}
+	var limit *int
+	if filter.Limit > 0 {
+		limit = &filter.Limit
+	}
+	rows, err := q.Query(ctx,
+		`SELECT `+linkColumns+` FROM links
+		WHERE ($1::text IS NULL OR $1 = ANY (tags)) AND short_code > $2
+			AND ($4 = 0 OR ($4 = 1 AND deleted_at IS NULL) OR ($4 = 2 AND deleted_at IS NOT NULL))
+		ORDER BY short_code LIMIT $3`, tag, filter.After, limit, int(filter.Trash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	defer rows.Close()
+	var links []link.Link
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list links: %w", err)
+		}
+		links = append(links, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	return links, nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, shortCode string) error {
+	return s.update(ctx, shortCode, `DELETE FROM links WHERE short_code = $1`)
+}
+
+const linkColumns = `short_code, original_url, title, description, tags, creator, created_at, updated_at,
+	clicks, suspicious, password_hash, metadata, deleted_at, query, query_policy, utm, forward_path, rules,
+	variants, sticky_variants, max_clicks`
+
+func scanLink(row pgx.Row) (link.Link, error) {
+	var l link.Link
+	var createdAt, updatedAt, deletedAt *time.Time
+	var queryPolicy string
+	err := row.Scan(&l.Code, &l.URL, &l.Title, &l.Description, &l.Tags, &l.Creator, &createdAt, &updatedAt,
+		&l.Clicks, &l.Suspicious, &l.PasswordHash, &l.Metadata, &deletedAt, &l.Query, &queryPolicy, &l.UTM, &l.ForwardPath, &l.Rules,
+		&l.Variants, &l.StickyVariants, &l.MaxClicks)
+	if err != nil {
+		return link.Link{}, err
+	}
+	l.QueryPolicy = link.QueryPolicy(queryPolicy)
+	if createdAt != nil {
+		l.CreatedAt = createdAt.UTC()
+	}
+	if updatedAt != nil {
+		l.UpdatedAt = updatedAt.UTC()
+	}
+	if deletedAt != nil {
+		l.DeletedAt = deletedAt.UTC()
+	}
+	// empty columns come back as empty rather than nil
+	if len(l.Tags) == 0 {
+		l.Tags = nil
+	}
+	if len(l.Metadata) == 0 {
+		l.Metadata = nil
+	}
+	if len(l.Query) == 0 {
+		l.Query = nil
+	}
+	if len(l.UTM) == 0 {
+		l.UTM = nil
+	}
+	if len(l.Rules) == 0 {
+		l.Rules = nil
+	}
+	if len(l.Variants) == 0 {
+		l.Variants = nil
+	}
+	return l, nil
+}
+
+func (s *PostgresStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	tags, rules, variants := info.Tags, info.Rules, info.Variants
+	// the columns are NOT NULL
+	if tags == nil {
+		tags = []string{}
+	}
+	if rules == nil {
+		rules = []link.Rule{}
+	}
+	if variants == nil {
+		variants = []link.Variant{}
+	}
+	// clicks are left alone and each variant keeps the clicks of the stored
+	// variant with the same name
+	return s.update(ctx, shortCode,
+		`UPDATE links SET title = $2, description = $3, tags = $4, creator = $5, created_at = $6, updated_at = $7,
+			suspicious = $8, password_hash = $9, metadata = $10, deleted_at = $11,
+			query = $12, query_policy = $13, utm = $14, forward_path = $15, rules = $16,
+			variants = (SELECT coalesce(jsonb_agg(
+				jsonb_set(v, '{clicks}', to_jsonb(coalesce((SELECT (o->>'clicks')::bigint
+					FROM jsonb_array_elements(links.variants) AS o WHERE o->>'name' = v->>'name' LIMIT 1), 0)))
+				ORDER BY i), '[]') FROM jsonb_array_elements($17::jsonb) WITH ORDINALITY AS e(v, i)),
+			sticky_variants = $18, max_clicks = $19 WHERE short_code = $1`,
+		info.Title, info.Description, tags, info.Creator, nullTime(info.CreatedAt), nullTime(info.UpdatedAt),
+		info.Suspicious, info.PasswordHash, emptyMap(info.Metadata), nullTime(info.DeletedAt),
+		emptyMap(info.Query), string(info.QueryPolicy), emptyMap(info.UTM), info.ForwardPath, rules,
+		variants, info.StickyVariants, info.MaxClicks)
+}
+
+func emptyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// clickAllowed is the condition that keeps click counters within max_clicks.
+const clickAllowed = `(max_clicks = 0 OR clicks < max_clicks)`
+
+func (s *PostgresStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	return s.countClick(ctx, shortCode, `UPDATE links SET clicks = clicks + 1 WHERE short_code = $1 AND `+clickAllowed)
+}
+
+// IncrementVariantClicks rewrites the variants array in the same statement
+// that counts the link's click, so concurrent clicks are serialised on the
+// row.
+func (s *PostgresStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	return s.countClick(ctx, shortCode, `UPDATE links SET clicks = clicks + 1,
+		variants = (SELECT coalesce(jsonb_agg(
+			CASE WHEN v->>'name' = $2 THEN jsonb_set(v, '{clicks}', to_jsonb(coalesce((v->>'clicks')::bigint, 0) + 1)) ELSE v END
+			ORDER BY i), '[]') FROM jsonb_array_elements(variants) WITH ORDINALITY AS e(v, i))
+		WHERE short_code = $1 AND `+clickAllowed, variant)
+}
+
+func (s *PostgresStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	if variantClicks == nil {
+		variantClicks = map[string]int{}
+	}
+	return s.update(ctx, shortCode, `UPDATE links SET clicks = clicks + $2,
+		variants = (SELECT coalesce(jsonb_agg(
+			CASE WHEN $3::jsonb ? (v->>'name')
+				THEN jsonb_set(v, '{clicks}', to_jsonb(coalesce((v->>'clicks')::bigint, 0) + ($3::jsonb->>(v->>'name'))::bigint))
+				ELSE v END
+			ORDER BY i), '[]') FROM jsonb_array_elements(variants) WITH ORDINALITY AS e(v, i))
+		WHERE short_code = $1`, clicks, variantClicks)
+}
+
+// countClick runs an update guarded by clickAllowed, telling a link at its
+// limit apart from a missing one when no row changes. The row lock taken by
+// the update keeps concurrent clicks from going over the limit.
+func (s *PostgresStore) countClick(ctx context.Context, shortCode, sql string, args ...any) error {
+	err := s.update(ctx, shortCode, sql, args...)
+	if !errors.Is(err, storage.ErrShortCodeNotFound) {
+		return err
+	}
+	exists, existsErr := s.Exists(ctx, shortCode)
+	if existsErr != nil {
+		return existsErr
+	}
+	if exists {
+		return storage.ErrClickLimitReached
+	}
+	return err
+}
+
+func (s *PostgresStore) update(ctx context.Context, shortCode, sql string, args ...any) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tag, err := s.pool.Exec(ctx, sql, append([]any{shortCode}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to update short code %q: %w", shortCode, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrShortCodeNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.pool.Ping(ctx)
+}
@@ -0,0 +1,554 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+const (
+	DefaultPrefix  = "shortener:"
+	DefaultTimeout = time.Second
+	listBatchSize  = 100
+)
+
+type Options struct {
+	Prefix string // namespaces every key so several services can share a database
+	// TTL expires links natively in Redis, zero keeps them forever
+	TTL     time.Duration
+	Timeout time.Duration // per command
+}
+
+func DefaultOptions() Options {
+	return Options{Prefix: DefaultPrefix, Timeout: DefaultTimeout}
+}
+
+// RedisStore keeps each link as a string key holding the destination, claimed
+// with SET NX so concurrent replicas can never allocate the same short code,
+// and a hash of its metadata alongside it. A sorted set indexes the short
+// codes so they can be listed in order a page at a time.
+type RedisStore struct {
+	client *goredis.Client
+	opts   Options
+}
+
+func New(client *goredis.Client, opts Options) *RedisStore {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &RedisStore{client, opts}
+}
+
+// Open connects to a redis:// or rediss:// URL.
+func Open(url string, opts Options) (*RedisStore, error) {
+	clientOpts, err := goredis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis url: %v", err)
+	}
+	return New(goredis.NewClient(clientOpts), opts), nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) urlKey(shortCode string) string {
+	return s.opts.Prefix + "url:" + shortCode
+}
+
+func (s *RedisStore) infoKey(shortCode string) string {
+	return s.opts.Prefix + "info:" + shortCode
+}
+
+// indexKey is the sorted set of every short code, all scored 0 so they sort
+// lexically. Codes of links that expired are dropped from it by List.
+func (s *RedisStore) indexKey() string {
+	return s.opts.Prefix + "codes"
+}
+
+func (s *RedisStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.opts.Timeout)
+}
+
+func (s *RedisStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	n, err := s.client.Exists(ctx, s.urlKey(shortCode)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check short code %q: %w", shortCode, err)
+	}
+	return n > 0, nil
+}
+
+// The link key is claimed and indexed in one step, ARGV[3] being its TTL in
+// milliseconds or 0 for none.
+var saveScript = goredis.NewScript(`
+local claimed
+if ARGV[3] == "0" then
+  claimed = redis.call("SET", KEYS[1], ARGV[2], "NX")
+else
+  claimed = redis.call("SET", KEYS[1], ARGV[2], "NX", "PX", ARGV[3])
+end
+if not claimed then return 0 end
+redis.call("ZADD", KEYS[2], 0, ARGV[1])
+return 1
+`)
+
+func (s *RedisStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	keys := []string{s.urlKey(shortCode), s.indexKey()}
+	claimed, err := saveScript.Run(ctx, s.client, keys, shortCode, originalUrl, s.opts.TTL.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to save short code %q: %w", shortCode, err)
+	}
+	if claimed == 0 {
+		return storage.ErrShortCodeExists
+	}
+	return nil
+}
+
+// UpdateURL only sets a key that is already there, and keeps its TTL.
+func (s *RedisStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	err := s.client.SetArgs(ctx, s.urlKey(shortCode), originalUrl, goredis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, goredis.Nil) {
+		return storage.ErrShortCodeNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update short code %q: %w", shortCode, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	originalUrl, err := s.client.Get(ctx, s.urlKey(shortCode)).Result()
+	if errors.Is(err, goredis.Nil) {
+		return "", storage.ErrShortCodeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get short code %q: %w", shortCode, err)
+	}
+	return originalUrl, nil
+}
+
+func (s *RedisStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var exists *goredis.IntCmd
+	var fields *goredis.MapStringStringCmd
+	_, err := s.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		exists = p.Exists(ctx, s.urlKey(shortCode))
+		fields = p.HGetAll(ctx, s.infoKey(shortCode))
+		return nil
+	})
+	if err != nil {
+		return link.Info{}, fmt.Errorf("failed to get info for short code %q: %w", shortCode, err)
+	}
+	if exists.Val() == 0 {
+		return link.Info{}, storage.ErrShortCodeNotFound
+	}
+	info, err := decodeInfo(fields.Val())
+	if err != nil {
+		return link.Info{}, fmt.Errorf("failed to decode info for short code %q: %w", shortCode, err)
+	}
+	return info, nil
+}
+
+// Writes to the info hash only happen while the link key exists, and the
+// hash inherits its remaining TTL so both expire together.
+//
+// The click counters survive the rewrite: ARGV[1] is how many variant click
+// fields follow it, each kept at its stored count or started at zero, and
+// the rest of ARGV are the other fields.
+var saveInfoScript = goredis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then return 0 end
+local n = tonumber(ARGV[1])
+local kept = {"clicks", redis.call("HGET", KEYS[2], "clicks") or "0"}
+for i = 2, n + 1 do
+  table.insert(kept, ARGV[i])
+  table.insert(kept, redis.call("HGET", KEYS[2], ARGV[i]) or "0")
+end
+redis.call("DEL", KEYS[2])
+redis.call("HSET", KEYS[2], unpack(ARGV, n + 2))
+redis.call("HSET", KEYS[2], unpack(kept))
+if ttl > 0 then redis.call("PEXPIRE", KEYS[2], ttl) end
+return 1
+`)
+
+// A variant's clicks are counted in a field of their own, named by
+// variantClicksField and passed as ARGV[1], which SaveInfo creates for every
+// variant the link has. Links that have reached their max_clicks are left
+// alone and reported with -1.
+var incrementClicksScript = goredis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then return 0 end
+local limit = tonumber(redis.call("HGET", KEYS[2], "max_clicks") or "0")
+if limit > 0 and tonumber(redis.call("HGET", KEYS[2], "clicks") or "0") >= limit then return -1 end
+redis.call("HINCRBY", KEYS[2], "clicks", 1)
+if ARGV[1] and redis.call("HEXISTS", KEYS[2], ARGV[1]) == 1 then redis.call("HINCRBY", KEYS[2], ARGV[1], 1) end
+if ttl > 0 then redis.call("PEXPIRE", KEYS[2], ttl) end
+return 1
+`)
+
+// ARGV[1] is added to the link's clicks and the rest of ARGV are pairs of a
+// variant click field and what to add to it, skipped for variants the link
+// does not have.
+var addClicksScript = goredis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then return 0 end
+redis.call("HINCRBY", KEYS[2], "clicks", ARGV[1])
+for i = 2, #ARGV, 2 do
+  if redis.call("HEXISTS", KEYS[2], ARGV[i]) == 1 then redis.call("HINCRBY", KEYS[2], ARGV[i], ARGV[i + 1]) end
+end
+if ttl > 0 then redis.call("PEXPIRE", KEYS[2], ttl) end
+return 1
+`)
+
+const variantClicksPrefix = "variant_clicks:"
+
+func variantClicksField(variant string) string {
+	return variantClicksPrefix + variant
+}
+
+func (s *RedisStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	args := []any{len(info.Variants)}
+	for _, v := range info.Variants {
+		args = append(args, variantClicksField(v.Name))
+	}
+	return s.runOnLink(ctx, saveInfoScript, shortCode, append(args, encodeInfo(info)...)...)
+}
+
+func (s *RedisStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.runOnLink(ctx, incrementClicksScript, shortCode)
+}
+
+func (s *RedisStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.runOnLink(ctx, incrementClicksScript, shortCode, variantClicksField(variant))
+}
+
+func (s *RedisStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	args := []any{clicks}
+	for name, n := range variantClicks {
+		args = append(args, variantClicksField(name), n)
+	}
+	return s.runOnLink(ctx, addClicksScript, shortCode, args...)
+}
+
+func (s *RedisStore) runOnLink(ctx context.Context, script *goredis.Script, shortCode string, args ...any) error {
+	done, err := script.Run(ctx, s.client, []string{s.urlKey(shortCode), s.infoKey(shortCode)}, args...).Int()
+	if err != nil {
+		return fmt.Errorf("failed to update short code %q: %w", shortCode, err)
+	}
+	switch done {
+	case 0:
+		return storage.ErrShortCodeNotFound
+	case -1:
+		return storage.ErrClickLimitReached
+	}
+	return nil
+}
+
+// List pages through the index with ZRANGE BYLEX, each page under a timeout
+// of its own, so long listings only take as long as they need to.
+func (s *RedisStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	var links []link.Link
+	after := filter.After
+	for {
+		codes, err := s.indexPage(ctx, after)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list links: %w", err)
+		}
+		page, err := s.getLinks(ctx, codes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list links: %w", err)
+		}
+		for _, l := range page {
+			if !filter.Matches(l) {
+				continue
+			}
+			links = append(links, l)
+			if filter.Limit > 0 && len(links) == filter.Limit {
+				return links, nil
+			}
+		}
+		if len(codes) < listBatchSize {
+			return links, nil
+		}
+		after = codes[len(codes)-1]
+	}
+}
+
+// indexPage returns the next listBatchSize short codes in the index sorting
+// after after.
+func (s *RedisStore) indexPage(ctx context.Context, after string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	start := "-"
+	if after != "" {
+		start = "(" + after
+	}
+	return s.client.ZRangeArgs(ctx, goredis.ZRangeArgs{
+		Key: s.indexKey(), Start: start, Stop: "+", ByLex: true, Count: listBatchSize,
+	}).Result()
+}
+
+// getLinks reads the links at codes, dropping those that expired since they
+// were indexed from the index.
+func (s *RedisStore) getLinks(ctx context.Context, codes []string) ([]link.Link, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	urls := make([]*goredis.StringCmd, len(codes))
+	infos := make([]*goredis.MapStringStringCmd, len(codes))
+	_, err := s.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for i, code := range codes {
+			urls[i] = p.Get(ctx, s.urlKey(code))
+			infos[i] = p.HGetAll(ctx, s.infoKey(code))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, goredis.Nil) {
+		return nil, err
+	}
+	links := make([]link.Link, 0, len(codes))
+	var expired []any
+	for i, code := range codes {
+		if errors.Is(urls[i].Err(), goredis.Nil) {
+			expired = append(expired, code)
+			continue
+		}
+		info, err := decodeInfo(infos[i].Val())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode info for short code %q: %w", code, err)
+		}
+		links = append(links, link.New(code, urls[i].Val(), info))
+	}
+	if len(expired) > 0 {
+		keys := []string{s.indexKey()}
+		for _, code := range expired {
+			keys = append(keys, s.urlKey(code.(string)))
+		}
+		if err := unindexScript.Run(ctx, s.client, keys, expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// KEYS[1] is the index and the rest are the link keys of the codes in ARGV,
+// each dropped from the index unless it was claimed again since it was read.
+var unindexScript = goredis.NewScript(`
+for i = 1, #ARGV do
+  if redis.call("EXISTS", KEYS[i + 1]) == 0 then redis.call("ZREM", KEYS[1], ARGV[i]) end
+end
+return 1
+`)
+
+// EnsureIndex builds the index of short codes from the link keys if it is
+// missing, as it is for links saved by versions that kept none. Each page of
+// the scan runs under a timeout of its own.
+func (s *RedisStore) EnsureIndex(ctx context.Context) error {
+	indexed, err := s.indexed(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the index: %w", err)
+	}
+	if indexed {
+		return nil
+	}
+	var cursor uint64
+	for {
+		if cursor, err = s.indexScanPage(ctx, cursor); err != nil {
+			return fmt.Errorf("failed to build the index: %w", err)
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// indexScanPage adds the link keys of one page of a SCAN from cursor to the
+// index, returning the cursor of the next page.
+func (s *RedisStore) indexScanPage(ctx context.Context, cursor uint64) (uint64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	keys, next, err := s.client.Scan(ctx, cursor, s.urlKey("*"), listBatchSize).Result()
+	if err != nil || len(keys) == 0 {
+		return next, err
+	}
+	members := make([]goredis.Z, len(keys))
+	for i, key := range keys {
+		members[i] = goredis.Z{Member: strings.TrimPrefix(key, s.urlKey(""))}
+	}
+	return next, s.client.ZAdd(ctx, s.indexKey(), members...).Err()
+}
+
+func (s *RedisStore) indexed(ctx context.Context) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	n, err := s.client.Exists(ctx, s.indexKey()).Result()
+	return n > 0, err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, shortCode string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var deleted *goredis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+		deleted = p.Del(ctx, s.urlKey(shortCode))
+		p.Del(ctx, s.infoKey(shortCode))
+		p.ZRem(ctx, s.indexKey(), shortCode)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete short code %q: %w", shortCode, err)
+	}
+	if deleted.Val() == 0 {
+		return storage.ErrShortCodeNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+// encodeInfo leaves out the click counters, which SaveInfo keeps.
+func encodeInfo(info link.Info) []any {
+	fields := []any{
+		"title", info.Title,
+		"description", info.Description,
+		"creator", info.Creator,
+		"suspicious", strconv.FormatBool(info.Suspicious),
+		"password_hash", info.PasswordHash,
+	}
+	if !info.CreatedAt.IsZero() {
+		fields = append(fields, "created_at", info.CreatedAt.Format(time.RFC3339Nano))
+	}
+	if !info.UpdatedAt.IsZero() {
+		fields = append(fields, "updated_at", info.UpdatedAt.Format(time.RFC3339Nano))
+	}
+	if !info.DeletedAt.IsZero() {
+		fields = append(fields, "deleted_at", info.DeletedAt.Format(time.RFC3339Nano))
+	}
+	// a hash cannot nest, so lists and maps are stored as JSON
+	if len(info.Tags) > 0 {
+		tags, _ := json.Marshal(info.Tags)
+		fields = append(fields, "tags", string(tags))
+	}
+	if len(info.Metadata) > 0 {
+		metadata, _ := json.Marshal(info.Metadata)
+		fields = append(fields, "metadata", string(metadata))
+	}
+	if len(info.Query) > 0 {
+		query, _ := json.Marshal(info.Query)
+		fields = append(fields, "query", string(query))
+	}
+	if info.ForwardPath {
+		fields = append(fields, "forward_path", "true")
+	}
+	if info.QueryPolicy != "" {
+		fields = append(fields, "query_policy", string(info.QueryPolicy))
+	}
+	if len(info.UTM) > 0 {
+		utm, _ := json.Marshal(info.UTM)
+		fields = append(fields, "utm", string(utm))
+	}
+	if len(info.Rules) > 0 {
+		rules, _ := json.Marshal(info.Rules)
+		fields = append(fields, "rules", string(rules))
+	}
+	if len(info.Variants) > 0 {
+		// clicks go in fields of their own so they can be counted atomically
+		variants := slices.Clone(info.Variants)
+		for i := range variants {
+			variants[i].Clicks = 0
+		}
+		encoded, _ := json.Marshal(variants)
+		fields = append(fields, "variants", string(encoded))
+	}
+	if info.StickyVariants {
+		fields = append(fields, "sticky_variants", "true")
+	}
+	if info.MaxClicks > 0 {
+		fields = append(fields, "max_clicks", strconv.Itoa(info.MaxClicks))
+	}
+	return fields
+}
+
+// decodeInfo reads back the fields encodeInfo wrote. A field that does not
+// decode is an error rather than left out, as leaving out the password hash,
+// click limit or rules of a link would redirect visitors it should not.
+func decodeInfo(fields map[string]string) (link.Info, error) {
+	info := link.Info{
+		Title:        fields["title"],
+		Description:  fields["description"],
+		Creator:      fields["creator"],
+		PasswordHash: fields["password_hash"],
+		QueryPolicy:  link.QueryPolicy(fields["query_policy"]),
+	}
+	var errs []error
+	decode := func(field string, decode func(value string) error) {
+		if value, ok := fields[field]; ok {
+			if err := decode(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", field, err))
+			}
+		}
+	}
+	decodeInt := func(field string, v *int) {
+		decode(field, func(value string) (err error) { *v, err = strconv.Atoi(value); return err })
+	}
+	decodeBool := func(field string, v *bool) {
+		decode(field, func(value string) (err error) { *v, err = strconv.ParseBool(value); return err })
+	}
+	decodeTime := func(field string, v *time.Time) {
+		decode(field, func(value string) (err error) { *v, err = time.Parse(time.RFC3339Nano, value); return err })
+	}
+	decodeJSON := func(field string, v any) {
+		decode(field, func(value string) error { return json.Unmarshal([]byte(value), v) })
+	}
+
+	decodeInt("clicks", &info.Clicks)
+	decodeInt("max_clicks", &info.MaxClicks)
+	decodeBool("suspicious", &info.Suspicious)
+	decodeBool("forward_path", &info.ForwardPath)
+	decodeBool("sticky_variants", &info.StickyVariants)
+	decodeTime("created_at", &info.CreatedAt)
+	decodeTime("updated_at", &info.UpdatedAt)
+	decodeTime("deleted_at", &info.DeletedAt)
+	decodeJSON("tags", &info.Tags)
+	decodeJSON("metadata", &info.Metadata)
+	decodeJSON("query", &info.Query)
+	decodeJSON("utm", &info.UTM)
+	decodeJSON("rules", &info.Rules)
+	decodeJSON("variants", &info.Variants)
+	for i, v := range info.Variants {
+		decodeInt(variantClicksField(v.Name), &info.Variants[i].Clicks)
+	}
+	return info, errors.Join(errs...)
+}
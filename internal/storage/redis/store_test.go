@@ -0,0 +1,202 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/redis"
+)
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rejects a short code that is already allocated", func(t *testing.T) {
+		store, _ := newStore(t, redis.DefaultOptions())
+
+		if err := store.Save(ctx, "abc123", "https://example.com"); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		err := store.Save(ctx, "abc123", "https://example.org")
+		if !errors.Is(err, storage.ErrShortCodeExists) {
+			t.Fatalf("got error %v, want %v", err, storage.ErrShortCodeExists)
+		}
+
+		got, _ := store.GetOriginalURL(ctx, "abc123")
+		if got != "https://example.com" {
+			t.Errorf("first writer should win: got %q", got)
+		}
+	})
+
+	t.Run("replicas sharing a server see each other's links", func(t *testing.T) {
+		first, server := newStore(t, redis.DefaultOptions())
+		second := redis.New(goredis.NewClient(&goredis.Options{Addr: server.Addr()}), redis.DefaultOptions())
+
+		first.Save(ctx, "abc123", "https://example.com")
+
+		if exists, _ := second.Exists(ctx, "abc123"); !exists {
+			t.Error("link saved by one replica should be visible to the other")
+		}
+		if err := second.Save(ctx, "abc123", "https://example.org"); !errors.Is(err, storage.ErrShortCodeExists) {
+			t.Errorf("got error %v, want %v", err, storage.ErrShortCodeExists)
+		}
+	})
+
+	t.Run("expires links and their info with the TTL", func(t *testing.T) {
+		opts := redis.DefaultOptions()
+		opts.TTL = time.Hour
+		store, server := newStore(t, opts)
+
+		store.Save(ctx, "abc123", "https://example.com")
+		store.SaveInfo(ctx, "abc123", link.Info{Title: "Example"})
+		store.IncrementClicks(ctx, "abc123")
+
+		if ttl := server.TTL("shortener:info:abc123"); ttl <= 0 || ttl > time.Hour {
+			t.Errorf("info should expire with the link, got ttl %v", ttl)
+		}
+
+		server.FastForward(time.Hour + time.Second)
+
+		if exists, _ := store.Exists(ctx, "abc123"); exists {
+			t.Error("link should have expired")
+		}
+		if _, err := store.GetInfo(ctx, "abc123"); !errors.Is(err, storage.ErrShortCodeNotFound) {
+			t.Errorf("info should have expired, got error %v", err)
+		}
+	})
+
+	t.Run("updating a destination keeps the TTL", func(t *testing.T) {
+		opts := redis.DefaultOptions()
+		opts.TTL = time.Hour
+		store, server := newStore(t, opts)
+
+		store.Save(ctx, "abc123", "https://example.com")
+		server.FastForward(30 * time.Minute)
+		if err := store.UpdateURL(ctx, "abc123", "https://example.org"); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+
+		if ttl := server.TTL("shortener:url:abc123"); ttl <= 0 || ttl > 30*time.Minute {
+			t.Errorf("update should keep the remaining ttl, got %v", ttl)
+		}
+	})
+
+	t.Run("drops expired links from the index as it lists", func(t *testing.T) {
+		opts := redis.DefaultOptions()
+		opts.TTL = time.Hour
+		store, server := newStore(t, opts)
+
+		store.Save(ctx, "abc123", "https://example.com")
+		server.FastForward(time.Hour + time.Second)
+		store.Save(ctx, "def456", "https://example.org")
+
+		links, err := store.List(ctx, storage.ListFilter{})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		if len(links) != 1 || links[0].Code != "def456" {
+			t.Errorf("got links %+v, want only def456", links)
+		}
+		if codes, _ := server.ZMembers("shortener:codes"); len(codes) != 1 || codes[0] != "def456" {
+			t.Errorf("got indexed codes %v, want only def456", codes)
+		}
+	})
+
+	t.Run("indexes links saved before the index existed", func(t *testing.T) {
+		store, server := newStore(t, redis.DefaultOptions())
+		for _, code := range []string{"abc123", "def456"} {
+			server.Set("shortener:url:"+code, "https://example.com/"+code)
+		}
+
+		if err := store.EnsureIndex(ctx); err != nil {
+			t.Fatalf("failed to build the index: %v", err)
+		}
+		links, err := store.List(ctx, storage.ListFilter{})
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		if len(links) != 2 || links[0].Code != "abc123" || links[1].Code != "def456" {
+			t.Errorf("got links %+v, want abc123 and def456", links)
+		}
+
+		// an existing index is trusted as it is
+		server.Set("shortener:url:xyz789", "https://example.com/xyz789")
+		if err := store.EnsureIndex(ctx); err != nil {
+			t.Fatalf("failed to check the index: %v", err)
+		}
+		if codes, _ := server.ZMembers("shortener:codes"); len(codes) != 2 {
+			t.Errorf("got indexed codes %v, want the index left alone", codes)
+		}
+	})
+
+	t.Run("fails to read info that does not decode", func(t *testing.T) {
+		store, server := newStore(t, redis.DefaultOptions())
+		if err := store.Save(ctx, "abc123", "https://example.com"); err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+		if err := store.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 1, Rules: []link.Rule{{Countries: []string{"GB"}, URL: "https://example.co.uk"}}}); err != nil {
+			t.Fatalf("failed to save info: %v", err)
+		}
+		server.HSet("shortener:info:abc123", "rules", "{truncated")
+
+		if _, err := store.GetInfo(ctx, "abc123"); err == nil || errors.Is(err, storage.ErrShortCodeNotFound) {
+			t.Errorf("got %v, want a decoding error", err)
+		}
+		if _, err := store.List(ctx, storage.ListFilter{}); err == nil {
+			t.Error("listing should fail on info that does not decode")
+		}
+	})
+
+	t.Run("keys are namespaced by prefix", func(t *testing.T) {
+		opts := redis.DefaultOptions()
+		opts.Prefix = "tenant-a:"
+		store, server := newStore(t, opts)
+
+		store.Save(ctx, "abc123", "https://example.com")
+
+		if got, _ := server.Get("tenant-a:url:abc123"); got != "https://example.com" {
+			t.Errorf("got %q stored under prefixed key", got)
+		}
+	})
+
+	t.Run("ping fails when the server is down", func(t *testing.T) {
+		store, server := newStore(t, redis.DefaultOptions())
+		server.Close()
+
+		if err := store.Ping(ctx); err == nil {
+			t.Error("ping should fail")
+		}
+	})
+}
+
+func TestRedisContract(t *testing.T) {
+	servers := map[storage.URLStore]*miniredis.Miniredis{}
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore {
+			store, server := newStore(t, redis.DefaultOptions())
+			servers[store] = server
+			return store
+		},
+		Reopen: func(store storage.URLStore) storage.URLStore {
+			client := goredis.NewClient(&goredis.Options{Addr: servers[store].Addr()})
+			t.Cleanup(func() { client.Close() })
+			return redis.New(client, redis.DefaultOptions())
+		},
+	}.Test(t)
+}
+
+func newStore(t testing.TB, opts redis.Options) (*redis.RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store, err := redis.Open("redis://"+server.Addr(), opts)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
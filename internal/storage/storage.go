@@ -1,7 +1,128 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+// Backends wrap these so callers can tell a missing or taken short code apart
+// from the backend failing.
+var (
+	ErrShortCodeNotFound = errors.New("short code not found in store")
+	ErrShortCodeExists   = errors.New("short code already exists in store")
+	// ErrClickLimitReached is returned instead of counting a click the link
+	// has no more of
+	ErrClickLimitReached = errors.New("link reached its click limit")
+)
+
+// ListFilter narrows the links returned by URLStore.List. The zero value
+// matches every link.
+type ListFilter struct {
+	Tag   string      // only links carrying this tag
+	After string      // only short codes sorting after this one, for paging
+	Limit int         // at most this many links, zero for no limit
+	Trash TrashFilter // whether links in the trash are listed
+}
+
+// TrashFilter selects links by whether they are in the trash. The zero value
+// lists every link, as exports and migrations need.
+type TrashFilter int
+
+const (
+	IncludeTrash TrashFilter = iota
+	ExcludeTrash
+	OnlyTrash
+)
+
+// Matches reports whether l passes the tag, trash and paging filters.
+func (f ListFilter) Matches(l link.Link) bool {
+	return (f.Tag == "" || l.HasTag(f.Tag)) && l.Code > f.After && f.Trash.Matches(l)
+}
+
+func (t TrashFilter) Matches(l link.Link) bool {
+	switch t {
+	case ExcludeTrash:
+		return !l.Deleted()
+	case OnlyTrash:
+		return l.Deleted()
+	}
+	return true
+}
+
+// Page sorts links that passed Matches by short code and trims them to the
+// limit, for backends that cannot do either themselves.
+func (f ListFilter) Page(links []link.Link) []link.Link {
+	slices.SortFunc(links, func(a, b link.Link) int { return strings.Compare(a.Code, b.Code) })
+	if f.Limit > 0 && len(links) > f.Limit {
+		links = links[:f.Limit]
+	}
+	return links
+}
+
+// URLStore is implemented by every storage backend. Each call takes the
+// context of the request it serves so cancellation reaches the backend.
 type URLStore interface {
-	Exists(shortCode string) bool
-	Save(shortCode, originalUrl string) error
-	GetOriginalURL(shortCode string) (string, bool)
+	Exists(ctx context.Context, shortCode string) (bool, error)
+	// Save fails with ErrShortCodeExists if the short code is taken
+	Save(ctx context.Context, shortCode, originalUrl string) error
+	// UpdateURL, GetOriginalURL, GetInfo, SaveInfo and the click counters
+	// fail with ErrShortCodeNotFound for unknown short codes
+	//
+	// UpdateURL points a link at originalUrl in place, keeping its info,
+	// clicks and expiry. The code stays taken throughout, unlike deleting
+	// and saving it again.
+	UpdateURL(ctx context.Context, shortCode, originalUrl string) error
+	GetOriginalURL(ctx context.Context, shortCode string) (string, error)
+	GetInfo(ctx context.Context, shortCode string) (link.Info, error)
+	// SaveInfo replaces everything but the click counters, which only the
+	// methods below change. Variants keep the clicks of the stored variant
+	// with the same name, so editing a link never loses a click counted
+	// since it was read.
+	SaveInfo(ctx context.Context, shortCode string, info link.Info) error
+	// Both click counters check link.Info.MaxClicks in the same atomic step
+	// as counting, failing with ErrClickLimitReached once it is reached
+	IncrementClicks(ctx context.Context, shortCode string) error
+	// IncrementVariantClicks counts a click on the link's variant called
+	// variant, which also counts towards the link's clicks
+	IncrementVariantClicks(ctx context.Context, shortCode, variant string) error
+	// AddClicks adds clicks to the link's clicks and variantClicks to its
+	// variants by name, without checking MaxClicks, for copying counters
+	// between stores. Either may be negative; unknown variants are ignored.
+	AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error
+	// Delete removes the link and its info, failing with ErrShortCodeNotFound
+	// for unknown short codes
+	Delete(ctx context.Context, shortCode string) error
+	// List returns the links matching filter ordered by short code
+	List(ctx context.Context, filter ListFilter) ([]link.Link, error)
+	// Ping checks the backend is reachable and readable
+	Ping(ctx context.Context) error
+}
+
+// ConsistentReader is implemented by backends that can read every link as it
+// was at one moment, which snapshots use for point in time backups.
+type ConsistentReader interface {
+	// ReadAll calls fn with every link in short code order, as they all were
+	// at one moment, stopping at the first error fn returns
+	ReadAll(ctx context.Context, fn func(link.Link) error) error
+}
+
+// SetClicks brings the click counters of the link at shortCode from those in
+// current, as last read, to those in want by adding the difference.
+func SetClicks(ctx context.Context, store URLStore, shortCode string, current, want link.Info) error {
+	clicks := want.Clicks - current.Clicks
+	variantClicks := make(map[string]int)
+	for _, v := range want.Variants {
+		previous, _ := current.Variant(v.Name)
+		if delta := v.Clicks - previous.Clicks; delta != 0 {
+			variantClicks[v.Name] = delta
+		}
+	}
+	if clicks == 0 && len(variantClicks) == 0 {
+		return nil
+	}
+	return store.AddClicks(ctx, shortCode, clicks, variantClicks)
 }
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedStore records a span for every call to the wrapped backend, as a
+// child of whatever span the call's context carries.
+type TracedStore struct {
+	store   storage.URLStore
+	backend string
+}
+
+func TraceStore(store storage.URLStore, backend string) *TracedStore {
+	return &TracedStore{store, backend}
+}
+
+func (s *TracedStore) start(ctx context.Context, operation, shortCode string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("storage.backend", s.backend)}
+	if shortCode != "" {
+		attrs = append(attrs, attribute.String("shortener.short_code", shortCode))
+	}
+	return Tracer().Start(ctx, "storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// end marks the span as failed unless err only reports an unknown or taken
+// short code or a used up link.
+func end(span trace.Span, err error) {
+	switch {
+	case errors.Is(err, storage.ErrShortCodeNotFound), errors.Is(err, storage.ErrShortCodeExists),
+		errors.Is(err, storage.ErrClickLimitReached):
+		span.SetAttributes(attribute.String("storage.outcome", err.Error()))
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *TracedStore) Exists(ctx context.Context, shortCode string) (bool, error) {
+	ctx, span := s.start(ctx, "Exists", shortCode)
+	exists, err := s.store.Exists(ctx, shortCode)
+	span.SetAttributes(attribute.Bool("storage.found", exists))
+	end(span, err)
+	return exists, err
+}
+
+func (s *TracedStore) Save(ctx context.Context, shortCode, originalUrl string) error {
+	ctx, span := s.start(ctx, "Save", shortCode)
+	err := s.store.Save(ctx, shortCode, originalUrl)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) UpdateURL(ctx context.Context, shortCode, originalUrl string) error {
+	ctx, span := s.start(ctx, "UpdateURL", shortCode)
+	err := s.store.UpdateURL(ctx, shortCode, originalUrl)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) GetOriginalURL(ctx context.Context, shortCode string) (string, error) {
+	ctx, span := s.start(ctx, "GetOriginalURL", shortCode)
+	originalUrl, err := s.store.GetOriginalURL(ctx, shortCode)
+	end(span, err)
+	return originalUrl, err
+}
+
+func (s *TracedStore) GetInfo(ctx context.Context, shortCode string) (link.Info, error) {
+	ctx, span := s.start(ctx, "GetInfo", shortCode)
+	info, err := s.store.GetInfo(ctx, shortCode)
+	end(span, err)
+	return info, err
+}
+
+func (s *TracedStore) SaveInfo(ctx context.Context, shortCode string, info link.Info) error {
+	ctx, span := s.start(ctx, "SaveInfo", shortCode)
+	err := s.store.SaveInfo(ctx, shortCode, info)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) Ping(ctx context.Context) error {
+	ctx, span := s.start(ctx, "Ping", "")
+	err := s.store.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) IncrementClicks(ctx context.Context, shortCode string) error {
+	ctx, span := s.start(ctx, "IncrementClicks", shortCode)
+	err := s.store.IncrementClicks(ctx, shortCode)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) IncrementVariantClicks(ctx context.Context, shortCode, variant string) error {
+	ctx, span := s.start(ctx, "IncrementVariantClicks", shortCode)
+	err := s.store.IncrementVariantClicks(ctx, shortCode, variant)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) AddClicks(ctx context.Context, shortCode string, clicks int, variantClicks map[string]int) error {
+	ctx, span := s.start(ctx, "AddClicks", shortCode)
+	err := s.store.AddClicks(ctx, shortCode, clicks, variantClicks)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) Delete(ctx context.Context, shortCode string) error {
+	ctx, span := s.start(ctx, "Delete", shortCode)
+	err := s.store.Delete(ctx, shortCode)
+	end(span, err)
+	return err
+}
+
+func (s *TracedStore) List(ctx context.Context, filter storage.ListFilter) ([]link.Link, error) {
+	ctx, span := s.start(ctx, "List", "")
+	if filter.Tag != "" {
+		span.SetAttributes(attribute.String("shortener.tag", filter.Tag))
+	}
+	links, err := s.store.List(ctx, filter)
+	span.SetAttributes(attribute.Int("storage.results", len(links)))
+	end(span, err)
+	return links, err
+}
@@ -0,0 +1,117 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracedStore(t *testing.T) {
+	exporter := newExporter(t)
+
+	t.Run("records a span per call under the caller's context", func(t *testing.T) {
+		exporter.Reset()
+		ctx, parent := tracing.Tracer().Start(context.Background(), "request")
+		store := tracing.TraceStore(memory.New(), "memory")
+
+		store.Save(ctx, "abc123", "https://example.com")
+		store.GetOriginalURL(ctx, "abc123")
+		parent.End()
+
+		spans := exporter.GetSpans()
+		if len(spans) != 3 {
+			t.Fatalf("got %d spans, want %d", len(spans), 3)
+		}
+		for i, name := range []string{"storage.Save", "storage.GetOriginalURL"} {
+			span := spans[i]
+			if span.Name != name {
+				t.Errorf("got span %q, want %q", span.Name, name)
+			}
+			if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("span %q is not a child of the request span", span.Name)
+			}
+			assertAttribute(t, span, "storage.backend", "memory")
+			assertAttribute(t, span, "shortener.short_code", "abc123")
+		}
+	})
+
+	t.Run("marks failed calls as errors", func(t *testing.T) {
+		exporter.Reset()
+		store := tracing.TraceStore(memory.New(), "memory")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		store.Save(ctx, "abc123", "https://example.com")
+
+		spans := exporter.GetSpans()
+		if got := spans[len(spans)-1].Status.Code; got != codes.Error {
+			t.Errorf("got status %v, want %v", got, codes.Error)
+		}
+	})
+
+	t.Run("a taken short code is not an error", func(t *testing.T) {
+		exporter.Reset()
+		store := tracing.TraceStore(memory.New(), "memory")
+		ctx := context.Background()
+
+		store.Save(ctx, "abc123", "https://example.com")
+		store.Save(ctx, "abc123", "https://example.com")
+
+		spans := exporter.GetSpans()
+		if got := spans[len(spans)-1].Status.Code; got == codes.Error {
+			t.Errorf("got status %v for a collision", got)
+		}
+	})
+
+	t.Run("a used up link is not an error", func(t *testing.T) {
+		exporter.Reset()
+		store := tracing.TraceStore(memory.New(), "memory")
+		ctx := context.Background()
+
+		store.Save(ctx, "abc123", "https://example.com")
+		store.SaveInfo(ctx, "abc123", link.Info{MaxClicks: 1})
+		store.IncrementClicks(ctx, "abc123")
+		store.IncrementClicks(ctx, "abc123")
+
+		spans := exporter.GetSpans()
+		if got := spans[len(spans)-1].Status.Code; got == codes.Error {
+			t.Errorf("got status %v for a used up link", got)
+		}
+	})
+
+	storage.URLStoreContract{
+		NewStore: func() storage.URLStore { return tracing.TraceStore(memory.New(), "memory") },
+	}.Test(t)
+}
+
+func newExporter(t testing.TB) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func assertAttribute(t testing.TB, span tracetest.SpanStub, key, want string) {
+	t.Helper()
+	for _, attr := range span.Attributes {
+		if attr.Key == attribute.Key(key) {
+			if got := attr.Value.AsString(); got != want {
+				t.Errorf("span %q: got %s %q, want %q", span.Name, key, got, want)
+			}
+			return
+		}
+	}
+	t.Errorf("span %q has no attribute %s", span.Name, key)
+}
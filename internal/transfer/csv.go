@@ -0,0 +1,229 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+// Tags are joined with tagSeparator, metadata, query and utm are JSON objects
+// and rules and variants JSON arrays, so a link stays on one row. Columns
+// missing from an imported file are left empty.
+var csvColumns = []string{
+	"code", "url", "title", "description", "tags", "creator", "created_at", "updated_at",
+	"clicks", "suspicious", "password_hash", "metadata", "deleted_at", "query", "query_policy", "utm",
+	"forward_path", "rules", "variants", "sticky_variants", "max_clicks",
+}
+
+const tagSeparator = ";"
+
+type csvEncoder struct {
+	csv           *csv.Writer
+	headerWritten bool
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{csv: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) Encode(l link.Link) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	record := []string{
+		l.Code, l.URL, l.Title, l.Description, strings.Join(l.Tags, tagSeparator), l.Creator,
+		formatTime(l.CreatedAt), formatTime(l.UpdatedAt),
+		strconv.Itoa(l.Clicks), strconv.FormatBool(l.Suspicious), l.PasswordHash, formatMap(l.Metadata), formatTime(l.DeletedAt),
+		formatMap(l.Query), string(l.QueryPolicy), formatMap(l.UTM), strconv.FormatBool(l.ForwardPath),
+		formatList(l.Rules), formatList(l.Variants), strconv.FormatBool(l.StickyVariants), strconv.Itoa(l.MaxClicks),
+	}
+	if err := e.csv.Write(record); err != nil {
+		return fmt.Errorf("failed to write link %q: %w", l.Code, err)
+	}
+	return nil
+}
+
+func (e *csvEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return fmt.Errorf("failed to write links: %w", err)
+	}
+	return nil
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	if err := e.csv.Write(csvColumns); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return nil
+}
+
+type csvDecoder struct {
+	csv     *csv.Reader
+	columns map[string]int
+}
+
+func newCSVDecoder(r io.Reader) *csvDecoder {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return &csvDecoder{csv: reader}
+}
+
+func (d *csvDecoder) Next() (link.Link, error) {
+	if d.columns == nil {
+		if err := d.readHeader(); err != nil {
+			return link.Link{}, err
+		}
+	}
+	record, err := d.csv.Read()
+	if errors.Is(err, io.EOF) {
+		return link.Link{}, io.EOF
+	}
+	if err != nil {
+		return link.Link{}, fmt.Errorf("failed to read link: %w", err)
+	}
+
+	field := func(name string) string {
+		if i, ok := d.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	l := link.New(field("code"), field("url"), link.Info{
+		Title:        field("title"),
+		Description:  field("description"),
+		Creator:      field("creator"),
+		PasswordHash: field("password_hash"),
+	})
+	if tags := field("tags"); tags != "" {
+		l.Tags = strings.Split(tags, tagSeparator)
+	}
+	if l.CreatedAt, err = parseTime(field("created_at")); err != nil {
+		return link.Link{}, fmt.Errorf("invalid created_at of %q: %w", l.Code, err)
+	}
+	if l.UpdatedAt, err = parseTime(field("updated_at")); err != nil {
+		return link.Link{}, fmt.Errorf("invalid updated_at of %q: %w", l.Code, err)
+	}
+	if l.DeletedAt, err = parseTime(field("deleted_at")); err != nil {
+		return link.Link{}, fmt.Errorf("invalid deleted_at of %q: %w", l.Code, err)
+	}
+	if clicks := field("clicks"); clicks != "" {
+		if l.Clicks, err = strconv.Atoi(clicks); err != nil {
+			return link.Link{}, fmt.Errorf("invalid clicks of %q: %w", l.Code, err)
+		}
+	}
+	if maxClicks := field("max_clicks"); maxClicks != "" {
+		if l.MaxClicks, err = strconv.Atoi(maxClicks); err != nil {
+			return link.Link{}, fmt.Errorf("invalid max_clicks of %q: %w", l.Code, err)
+		}
+	}
+	if suspicious := field("suspicious"); suspicious != "" {
+		if l.Suspicious, err = strconv.ParseBool(suspicious); err != nil {
+			return link.Link{}, fmt.Errorf("invalid suspicious flag of %q: %w", l.Code, err)
+		}
+	}
+	if forwardPath := field("forward_path"); forwardPath != "" {
+		if l.ForwardPath, err = strconv.ParseBool(forwardPath); err != nil {
+			return link.Link{}, fmt.Errorf("invalid forward_path flag of %q: %w", l.Code, err)
+		}
+	}
+	if err := parseMap(field("metadata"), &l.Metadata); err != nil {
+		return link.Link{}, fmt.Errorf("invalid metadata of %q: %w", l.Code, err)
+	}
+	if err := parseMap(field("query"), &l.Query); err != nil {
+		return link.Link{}, fmt.Errorf("invalid query of %q: %w", l.Code, err)
+	}
+	if err := parseMap(field("utm"), &l.UTM); err != nil {
+		return link.Link{}, fmt.Errorf("invalid utm of %q: %w", l.Code, err)
+	}
+	if rules := field("rules"); rules != "" {
+		if err := json.Unmarshal([]byte(rules), &l.Rules); err != nil {
+			return link.Link{}, fmt.Errorf("invalid rules of %q: %w", l.Code, err)
+		}
+	}
+	if variants := field("variants"); variants != "" {
+		if err := json.Unmarshal([]byte(variants), &l.Variants); err != nil {
+			return link.Link{}, fmt.Errorf("invalid variants of %q: %w", l.Code, err)
+		}
+	}
+	if sticky := field("sticky_variants"); sticky != "" {
+		if l.StickyVariants, err = strconv.ParseBool(sticky); err != nil {
+			return link.Link{}, fmt.Errorf("invalid sticky_variants flag of %q: %w", l.Code, err)
+		}
+	}
+	l.QueryPolicy = link.QueryPolicy(field("query_policy"))
+	return l, nil
+}
+
+func (d *csvDecoder) readHeader() error {
+	header, err := d.csv.Read()
+	if errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	d.columns = make(map[string]int, len(header))
+	for i, name := range header {
+		d.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"code", "url"} {
+		if _, ok := d.columns[required]; !ok {
+			return fmt.Errorf("header is missing the %q column", required)
+		}
+	}
+	return nil
+}
+
+func formatMap(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	// a map of strings always encodes
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func parseMap(s string, m *map[string]string) error {
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), m)
+}
+
+func formatList[T link.Rule | link.Variant](list []T) string {
+	if len(list) == 0 {
+		return ""
+	}
+	// rules and variants hold nothing that fails to encode
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
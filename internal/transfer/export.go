@@ -0,0 +1,37 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+// Links are read from the store a page at a time.
+const exportPageSize = 500
+
+// Export writes every link in store to w in format and returns how many were
+// written. Output already written stays written if it fails part way.
+func Export(ctx context.Context, store storage.URLStore, w io.Writer, format Format) (int, error) {
+	encoder := newEncoder(w, format)
+	filter := storage.ListFilter{Limit: exportPageSize}
+	exported := 0
+	for {
+		page, err := store.List(ctx, filter)
+		if err != nil {
+			return exported, fmt.Errorf("failed to export links: %w", err)
+		}
+		for _, l := range page {
+			if err := encoder.Encode(l); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		if len(page) < filter.Limit {
+			break
+		}
+		filter.After = page[len(page)-1].Code
+	}
+	return exported, encoder.Close()
+}
@@ -0,0 +1,105 @@
+// Package transfer exports links from any storage.URLStore and imports them
+// back, streaming records rather than holding the whole database in memory.
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+)
+
+type Format string
+
+const (
+	// JSON is the array written by link.Encoder, which also reads the legacy
+	// short code to URL object
+	JSON Format = "json"
+	// NDJSON is one link object per line
+	NDJSON Format = "ndjson"
+	// CSV has a header row naming the columns, see csvColumns
+	CSV Format = "csv"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSON, NDJSON, CSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, want json, ndjson or csv", s)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case NDJSON:
+		return "application/x-ndjson"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
+
+type encoder interface {
+	Encode(l link.Link) error
+	// Close finishes the output without closing the underlying writer
+	Close() error
+}
+
+type decoder interface {
+	// Next returns io.EOF once there are no links left
+	Next() (link.Link, error)
+}
+
+func newEncoder(w io.Writer, f Format) encoder {
+	switch f {
+	case NDJSON:
+		return &ndjsonEncoder{json.NewEncoder(w)}
+	case CSV:
+		return newCSVEncoder(w)
+	}
+	return link.NewEncoder(w)
+}
+
+func newDecoder(r io.Reader, f Format) decoder {
+	switch f {
+	case NDJSON:
+		return &ndjsonDecoder{json.NewDecoder(r)}
+	case CSV:
+		return newCSVDecoder(r)
+	}
+	return link.NewDecoder(r)
+}
+
+type ndjsonEncoder struct {
+	json *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(l link.Link) error {
+	if err := e.json.Encode(l); err != nil {
+		return fmt.Errorf("failed to write link %q: %w", l.Code, err)
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+type ndjsonDecoder struct {
+	json *json.Decoder
+}
+
+func (d *ndjsonDecoder) Next() (link.Link, error) {
+	var l link.Link
+	err := d.json.Decode(&l)
+	if errors.Is(err, io.EOF) {
+		return link.Link{}, io.EOF
+	}
+	if err != nil {
+		return link.Link{}, fmt.Errorf("failed to read link: %w", err)
+	}
+	return l, nil
+}
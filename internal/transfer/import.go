@@ -0,0 +1,294 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+)
+
+// Strategy decides what happens to an imported link whose short code is
+// already taken.
+type Strategy string
+
+const (
+	// Skip keeps the existing link and drops the imported one
+	Skip Strategy = "skip"
+	// Overwrite replaces the existing link and its info
+	Overwrite Strategy = "overwrite"
+	// Rename saves the imported link under the first free code-N
+	Rename Strategy = "rename"
+)
+
+const (
+	// maxRenameAttempts bounds the search for a free code-N
+	maxRenameAttempts = 100
+	// maxReportErrors bounds how many rejected records a report lists
+	maxReportErrors = 100
+)
+
+// ErrInvalidInput wraps errors reading the records to import, as opposed to
+// errors saving them.
+var ErrInvalidInput = errors.New("invalid import input")
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch strategy := Strategy(strings.ToLower(s)); strategy {
+	case Skip, Overwrite, Rename:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q, want skip, overwrite or rename", s)
+}
+
+type Options struct {
+	Format Format
+	// Strategy defaults to Skip
+	Strategy Strategy
+	// DryRun reports what an import would do without writing to the store
+	DryRun bool
+	// Changed, if set, is called with every link the import writes and the
+	// link it replaced, nil unless it overwrote one
+	Changed func(ctx context.Context, before *link.Link, after link.Link)
+}
+
+// Report counts what happened to each imported record. Failed records were
+// rejected as invalid, the first few of them are listed in Errors.
+type Report struct {
+	Total       int               `json:"total"`
+	Created     int               `json:"created"`
+	Skipped     int               `json:"skipped"`
+	Overwritten int               `json:"overwritten"`
+	Renamed     int               `json:"renamed"`
+	Failed      int               `json:"failed"`
+	Renames     map[string]string `json:"renames,omitempty"`
+	Errors      []RecordError     `json:"errors,omitempty"`
+	DryRun      bool              `json:"dry_run"`
+}
+
+// RecordError is why the record at position Record, counting from 1, was
+// rejected.
+type RecordError struct {
+	Record int    `json:"record"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error"`
+}
+
+// Import reads links from r and saves them to store, resolving conflicting
+// short codes with opts.Strategy. Invalid records are counted in the report
+// and skipped, while a failing store or unreadable input stops the import and
+// is returned along with the report so far.
+func Import(ctx context.Context, store storage.URLStore, r io.Reader, opts Options) (Report, error) {
+	im := &importer{
+		store:   store,
+		opts:    opts,
+		claimed: make(map[string]bool),
+		report:  Report{DryRun: opts.DryRun},
+	}
+	decoder := newDecoder(r, opts.Format)
+	for {
+		l, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return im.report, nil
+		}
+		if err != nil {
+			return im.report, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		im.report.Total++
+		if err := validate(&l); err != nil {
+			im.fail(l.Code, err)
+			continue
+		}
+		if err := im.importLink(ctx, l); err != nil {
+			return im.report, fmt.Errorf("failed to import %q: %w", l.Code, err)
+		}
+	}
+}
+
+type importer struct {
+	store storage.URLStore
+	opts  Options
+	// claimed holds the codes a dry run would have created, since nothing is
+	// written for Exists to find
+	claimed map[string]bool
+	report  Report
+}
+
+func (im *importer) importLink(ctx context.Context, l link.Link) error {
+	created, err := im.create(ctx, l.Code, l)
+	if err != nil || created {
+		if created {
+			im.report.Created++
+		}
+		return err
+	}
+
+	switch im.opts.Strategy {
+	case Overwrite:
+		if !im.opts.DryRun {
+			if err := im.overwrite(ctx, l); err != nil {
+				return err
+			}
+		}
+		im.report.Overwritten++
+	case Rename:
+		for i := 1; i <= maxRenameAttempts; i++ {
+			code := fmt.Sprintf("%s-%d", l.Code, i)
+			if created, err = im.create(ctx, code, l); err != nil {
+				return err
+			}
+			if created {
+				im.report.Renamed++
+				if im.report.Renames == nil {
+					im.report.Renames = make(map[string]string)
+				}
+				im.report.Renames[l.Code] = code
+				return nil
+			}
+		}
+		im.fail(l.Code, fmt.Errorf("no free code after %d renames", maxRenameAttempts))
+	default:
+		im.report.Skipped++
+	}
+	return nil
+}
+
+func (im *importer) overwrite(ctx context.Context, l link.Link) error {
+	var before *link.Link
+	if im.opts.Changed != nil {
+		existing, err := getLink(ctx, im.store, l.Code)
+		if err != nil && !errors.Is(err, storage.ErrShortCodeNotFound) {
+			return err
+		}
+		if err == nil {
+			before = &existing
+		}
+	}
+	// updated in place, so the code is never free for another link to claim
+	err := im.store.UpdateURL(ctx, l.Code, l.URL)
+	if err == nil {
+		err = im.replaceInfo(ctx, l)
+	}
+	if errors.Is(err, storage.ErrShortCodeNotFound) {
+		// gone since create found it taken, such as by expiring
+		err = im.write(ctx, l.Code, l)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrShortCodeExists) {
+			return fmt.Errorf("short code was claimed again while overwriting: %w", err)
+		}
+		return err
+	}
+	im.changed(ctx, before, l.Code, l)
+	return nil
+}
+
+// create saves l under code, reporting false if the code is already taken.
+func (im *importer) create(ctx context.Context, code string, l link.Link) (bool, error) {
+	if im.opts.DryRun {
+		if im.claimed[code] {
+			return false, nil
+		}
+		exists, err := im.store.Exists(ctx, code)
+		if err != nil || exists {
+			return false, err
+		}
+		im.claimed[code] = true
+		return true, nil
+	}
+
+	err := im.write(ctx, code, l)
+	if errors.Is(err, storage.ErrShortCodeExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	im.changed(ctx, nil, code, l)
+	return true, nil
+}
+
+func (im *importer) write(ctx context.Context, code string, l link.Link) error {
+	if err := im.store.Save(ctx, code, l.URL); err != nil {
+		return err
+	}
+	if err := im.store.SaveInfo(ctx, code, l.Info); err != nil {
+		return err
+	}
+	return storage.SetClicks(ctx, im.store, code, link.Info{}, l.Info)
+}
+
+// replaceInfo gives the link at l.Code the info of l, click counters
+// included, which SaveInfo leaves as they are.
+func (im *importer) replaceInfo(ctx context.Context, l link.Link) error {
+	current, err := im.store.GetInfo(ctx, l.Code)
+	if err != nil && !errors.Is(err, storage.ErrShortCodeNotFound) {
+		return err
+	}
+	if err := im.store.SaveInfo(ctx, l.Code, l.Info); err != nil {
+		return err
+	}
+	return storage.SetClicks(ctx, im.store, l.Code, current, l.Info)
+}
+
+func (im *importer) changed(ctx context.Context, before *link.Link, code string, l link.Link) {
+	if im.opts.Changed != nil {
+		l.Code = code
+		im.opts.Changed(ctx, before, l)
+	}
+}
+
+func getLink(ctx context.Context, store storage.URLStore, code string) (link.Link, error) {
+	url, err := store.GetOriginalURL(ctx, code)
+	if err != nil {
+		return link.Link{}, err
+	}
+	info, err := store.GetInfo(ctx, code)
+	if err != nil && !errors.Is(err, storage.ErrShortCodeNotFound) {
+		return link.Link{}, err
+	}
+	return link.New(code, url, info), nil
+}
+
+func (im *importer) fail(code string, err error) {
+	im.report.Failed++
+	if len(im.report.Errors) < maxReportErrors {
+		im.report.Errors = append(im.report.Errors, RecordError{Record: im.report.Total, Code: code, Error: err.Error()})
+	}
+}
+
+// validate checks l and normalises its tags and UTM tags.
+func validate(l *link.Link) error {
+	if err := link.ValidateCode(l.Code); err != nil {
+		return err
+	}
+	if l.URL == "" {
+		return errors.New("url must not be empty")
+	}
+	if l.MaxClicks < 0 {
+		return errors.New("max_clicks must not be negative")
+	}
+	if l.QueryPolicy != "" {
+		policy, err := link.ParseQueryPolicy(string(l.QueryPolicy))
+		if err != nil {
+			return err
+		}
+		l.QueryPolicy = policy
+	}
+	utm, err := link.NormaliseUTM(l.UTM)
+	if err != nil {
+		return err
+	}
+	rules, err := link.NormaliseRules(l.Rules)
+	if err != nil {
+		return err
+	}
+	variants, err := link.NormaliseVariants(l.Variants)
+	if err != nil {
+		return err
+	}
+	l.Tags, l.UTM, l.Rules, l.Variants = link.NormaliseTags(l.Tags), utm, rules, variants
+	return nil
+}
@@ -0,0 +1,283 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+	"github.com/sotiri-geo/url-shortener/internal/transfer"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	info := link.Info{
+		Title:        "Example, with a comma",
+		Description:  "An \"example\" link",
+		Tags:         []string{"docs", "example"},
+		Creator:      "alice",
+		CreatedAt:    time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+		UpdatedAt:    time.Date(2025, 1, 3, 3, 4, 5, 0, time.UTC),
+		Clicks:       4,
+		Suspicious:   true,
+		PasswordHash: "pbkdf2$hash",
+		Metadata:     map[string]string{"campaign": "spring"},
+		DeletedAt:    time.Date(2025, 1, 4, 3, 4, 5, 0, time.UTC),
+		Query:        map[string]string{"ref": "a&b"},
+		QueryPolicy:  link.OverrideQuery,
+		UTM:          map[string]string{"source": "newsletter"},
+		ForwardPath:  true,
+		Rules:        []link.Rule{{URL: "https://example.fr", Languages: []string{"fr"}, Hours: "22:00-06:00"}},
+		Variants: []link.Variant{
+			{Name: "control", URL: "https://example.com/a", Weight: 1, Clicks: 3},
+			{Name: "new", URL: "https://example.com/b", Weight: 1, Clicks: 1},
+		},
+		StickyVariants: true,
+		MaxClicks:      10,
+	}
+
+	for _, format := range []transfer.Format{transfer.JSON, transfer.NDJSON, transfer.CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			source := memory.New()
+			mustSave(t, source, "abc123", "https://example.com", info)
+			mustSave(t, source, "def456", "https://example.org", link.Info{})
+
+			var buf bytes.Buffer
+			exported, err := transfer.Export(ctx, source, &buf, format)
+			assertNoErr(t, err)
+			if exported != 2 {
+				t.Errorf("exported %d links, want 2", exported)
+			}
+
+			target := memory.New()
+			report, err := transfer.Import(ctx, target, &buf, transfer.Options{Format: format})
+			assertNoErr(t, err)
+			assertReport(t, report, transfer.Report{Total: 2, Created: 2})
+			assertLink(t, target, "abc123", "https://example.com", info)
+			assertLink(t, target, "def456", "https://example.org", link.Info{})
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pages through every link", func(t *testing.T) {
+		store := memory.New()
+		for i := range 1200 {
+			mustSave(t, store, fmt.Sprintf("code%04d", i), "https://example.com", link.Info{})
+		}
+
+		var buf bytes.Buffer
+		exported, err := transfer.Export(ctx, store, &buf, transfer.NDJSON)
+		assertNoErr(t, err)
+		if exported != 1200 {
+			t.Errorf("exported %d links, want 1200", exported)
+		}
+		if lines := strings.Count(buf.String(), "\n"); lines != 1200 {
+			t.Errorf("got %d lines, want 1200", lines)
+		}
+	})
+
+	t.Run("writes a CSV header for an empty store", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := transfer.Export(ctx, memory.New(), &buf, transfer.CSV)
+		assertNoErr(t, err)
+		if !strings.HasPrefix(buf.String(), "code,url,") {
+			t.Errorf("got %q, want a header row", buf.String())
+		}
+	})
+
+	t.Run("writes an empty JSON array for an empty store", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := transfer.Export(ctx, memory.New(), &buf, transfer.JSON)
+		assertNoErr(t, err)
+		if got := buf.String(); got != "[]\n" {
+			t.Errorf("got %q, want an empty array", got)
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	input := `{"code":"abc123","url":"https://new.example","title":"New"}
+{"code":"def456","url":"https://example.org"}
+`
+	newStore := func(t *testing.T) storage.URLStore {
+		store := memory.New()
+		mustSave(t, store, "abc123", "https://old.example", link.Info{Title: "Old"})
+		return store
+	}
+
+	t.Run("skip keeps the existing link", func(t *testing.T) {
+		store := newStore(t)
+		report, err := transfer.Import(ctx, store, strings.NewReader(input), transfer.Options{Format: transfer.NDJSON, Strategy: transfer.Skip})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 2, Created: 1, Skipped: 1})
+		assertLink(t, store, "abc123", "https://old.example", link.Info{Title: "Old"})
+	})
+
+	t.Run("overwrite replaces the existing link", func(t *testing.T) {
+		store := newStore(t)
+		report, err := transfer.Import(ctx, store, strings.NewReader(input), transfer.Options{Format: transfer.NDJSON, Strategy: transfer.Overwrite})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 2, Created: 1, Overwritten: 1})
+		assertLink(t, store, "abc123", "https://new.example", link.Info{Title: "New"})
+	})
+
+	t.Run("reports every link it writes", func(t *testing.T) {
+		var changes []string
+		changed := func(ctx context.Context, before *link.Link, after link.Link) {
+			from := "nothing"
+			if before != nil {
+				from = before.URL
+			}
+			changes = append(changes, after.Code+" from "+from+" to "+after.URL)
+		}
+		_, err := transfer.Import(ctx, newStore(t), strings.NewReader(input), transfer.Options{Format: transfer.NDJSON, Strategy: transfer.Overwrite, Changed: changed})
+		assertNoErr(t, err)
+		want := []string{"abc123 from https://old.example to https://new.example", "def456 from nothing to https://example.org"}
+		if !slices.Equal(changes, want) {
+			t.Errorf("got changes %q, want %q", changes, want)
+		}
+	})
+
+	t.Run("rename saves the imported link under a free code", func(t *testing.T) {
+		store := newStore(t)
+		mustSave(t, store, "abc123-1", "https://taken.example", link.Info{})
+		report, err := transfer.Import(ctx, store, strings.NewReader(input), transfer.Options{Format: transfer.NDJSON, Strategy: transfer.Rename})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 2, Created: 1, Renamed: 1})
+		if got := report.Renames["abc123"]; got != "abc123-2" {
+			t.Errorf("got rename to %q, want abc123-2", got)
+		}
+		assertLink(t, store, "abc123", "https://old.example", link.Info{Title: "Old"})
+		assertLink(t, store, "abc123-2", "https://new.example", link.Info{Title: "New"})
+	})
+
+	t.Run("dry run reports without writing", func(t *testing.T) {
+		store := newStore(t)
+		report, err := transfer.Import(ctx, store, strings.NewReader(input+input), transfer.Options{Format: transfer.NDJSON, Strategy: transfer.Rename, DryRun: true})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 4, Created: 1, Renamed: 3, DryRun: true})
+		if got := report.Renames["def456"]; got != "def456-1" {
+			t.Errorf("got rename to %q, want def456-1", got)
+		}
+		exists, err := store.Exists(ctx, "def456")
+		assertNoErr(t, err)
+		if exists {
+			t.Error("dry run saved def456")
+		}
+	})
+
+	t.Run("reports invalid records and carries on", func(t *testing.T) {
+		store := memory.New()
+		csv := "code,url,clicks\n,https://example.com,0\nabc123,,0\nbad/code,https://example.com,0\ndef456,https://example.org,3\nmetrics,https://example.com,0\nabc+,https://example.com,0\n"
+		report, err := transfer.Import(ctx, store, strings.NewReader(csv), transfer.Options{Format: transfer.CSV})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 6, Created: 1, Failed: 5})
+		if len(report.Errors) != 5 || report.Errors[1].Record != 2 || report.Errors[1].Code != "abc123" {
+			t.Errorf("got errors %+v", report.Errors)
+		}
+		assertLink(t, store, "def456", "https://example.org", link.Info{Clicks: 3})
+	})
+
+	t.Run("normalises tags", func(t *testing.T) {
+		store := memory.New()
+		_, err := transfer.Import(ctx, store, strings.NewReader(`[{"code":"abc123","url":"https://example.com","tags":["News"," docs","news"]}]`), transfer.Options{Format: transfer.JSON})
+		assertNoErr(t, err)
+		assertLink(t, store, "abc123", "https://example.com", link.Info{Tags: []string{"docs", "news"}})
+	})
+
+	t.Run("reads the legacy JSON format", func(t *testing.T) {
+		store := memory.New()
+		report, err := transfer.Import(ctx, store, strings.NewReader(`{"abc123": "https://example.com"}`), transfer.Options{Format: transfer.JSON})
+		assertNoErr(t, err)
+		assertReport(t, report, transfer.Report{Total: 1, Created: 1})
+	})
+
+	t.Run("rejects a CSV file without a url column", func(t *testing.T) {
+		_, err := transfer.Import(ctx, memory.New(), strings.NewReader("code,title\nabc123,Example\n"), transfer.Options{Format: transfer.CSV})
+		if !errors.Is(err, transfer.ErrInvalidInput) {
+			t.Errorf("got %v, want %v", err, transfer.ErrInvalidInput)
+		}
+	})
+
+	t.Run("stops on malformed input", func(t *testing.T) {
+		input := `{"code":"abc123","url":"https://example.com"}` + "\n{not json\n"
+		report, err := transfer.Import(ctx, memory.New(), strings.NewReader(input), transfer.Options{Format: transfer.NDJSON})
+		if !errors.Is(err, transfer.ErrInvalidInput) {
+			t.Errorf("got %v, want %v", err, transfer.ErrInvalidInput)
+		}
+		assertReport(t, report, transfer.Report{Total: 1, Created: 1})
+	})
+
+	t.Run("stops when the store fails", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := transfer.Import(ctx, memory.New(), strings.NewReader(input), transfer.Options{Format: transfer.NDJSON})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	if got, err := transfer.ParseFormat("CSV"); err != nil || got != transfer.CSV {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := transfer.ParseFormat("xml"); err == nil {
+		t.Error("expected an error for xml")
+	}
+	if got, err := transfer.ParseStrategy("Rename"); err != nil || got != transfer.Rename {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := transfer.ParseStrategy("merge"); err == nil {
+		t.Error("expected an error for merge")
+	}
+}
+
+func mustSave(t testing.TB, store storage.URLStore, code, url string, info link.Info) {
+	t.Helper()
+	ctx := context.Background()
+	assertNoErr(t, store.Save(ctx, code, url))
+	assertNoErr(t, store.SaveInfo(ctx, code, info))
+	assertNoErr(t, storage.SetClicks(ctx, store, code, link.Info{}, info))
+}
+
+func assertLink(t testing.TB, store storage.URLStore, code, wantURL string, wantInfo link.Info) {
+	t.Helper()
+	ctx := context.Background()
+	url, err := store.GetOriginalURL(ctx, code)
+	assertNoErr(t, err)
+	if url != wantURL {
+		t.Errorf("%s: got url %q, want %q", code, url, wantURL)
+	}
+	info, err := store.GetInfo(ctx, code)
+	assertNoErr(t, err)
+	if !info.Equal(wantInfo) {
+		t.Errorf("%s: got info %+v, want %+v", code, info, wantInfo)
+	}
+}
+
+func assertReport(t testing.TB, got, want transfer.Report) {
+	t.Helper()
+	got.Renames, got.Errors = nil, nil
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got report %+v, want %+v", got, want)
+	}
+}
+
+func assertNoErr(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -1,20 +1,357 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/sotiri-geo/url-shortener/internal/audit"
 	"github.com/sotiri-geo/url-shortener/internal/generator"
+	"github.com/sotiri-geo/url-shortener/internal/geoip"
 	"github.com/sotiri-geo/url-shortener/internal/handler"
+	"github.com/sotiri-geo/url-shortener/internal/link"
+	"github.com/sotiri-geo/url-shortener/internal/logging"
+	"github.com/sotiri-geo/url-shortener/internal/metrics"
+	"github.com/sotiri-geo/url-shortener/internal/snapshot"
+	"github.com/sotiri-geo/url-shortener/internal/storage"
+	"github.com/sotiri-geo/url-shortener/internal/storage/cache"
+	"github.com/sotiri-geo/url-shortener/internal/storage/dualwrite"
+	"github.com/sotiri-geo/url-shortener/internal/storage/file"
 	"github.com/sotiri-geo/url-shortener/internal/storage/memory"
+	"github.com/sotiri-geo/url-shortener/internal/storage/postgres"
+	"github.com/sotiri-geo/url-shortener/internal/storage/redis"
+	"github.com/sotiri-geo/url-shortener/internal/tracing"
+)
+
+const (
+	addr = ":3000"
+	// time given to in-flight requests to finish once the server stops accepting new ones
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
-	store := memory.New()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	os.Exit(run())
+}
+
+// run serves until the server fails or is told to stop, and returns the
+// exit code. Exiting is left to main so the deferred cleanups run first.
+func run() int {
+	// LOG_LEVEL is one of debug, info, warn or error
+	level, err := logging.ParseLevel(envOr("LOG_LEVEL", "info"))
+	if err != nil {
+		slog.Error("invalid LOG_LEVEL", "error", err)
+		return 1
+	}
+	logger := logging.New(os.Stdout, level)
+	slog.SetDefault(logger)
+
+	// LOG_REDIRECT_SAMPLE_RATE is the fraction of successful redirects written to the access log
+	sampleRate, err := strconv.ParseFloat(envOr("LOG_REDIRECT_SAMPLE_RATE", "1"), 64)
+	if err != nil || sampleRate < 0 || sampleRate > 1 {
+		logger.Error("LOG_REDIRECT_SAMPLE_RATE must be between 0 and 1", "value", os.Getenv("LOG_REDIRECT_SAMPLE_RATE"))
+		return 1
+	}
+
+	// SHUTDOWN_DRAIN_DELAY is how long readiness reports unready before the server stops, e.g. 5s
+	drainDelay, err := time.ParseDuration(envOr("SHUTDOWN_DRAIN_DELAY", "5s"))
+	if err != nil {
+		logger.Error("invalid SHUTDOWN_DRAIN_DELAY", "error", err)
+		return 1
+	}
+
+	// OTEL_EXPORTER_OTLP_ENDPOINT enables exporting traces, e.g. http://localhost:4318
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		return 1
+	}
+
+	// CACHE_SIZE, CACHE_TTL and CACHE_NEGATIVE_TTL tune the read-through cache, CACHE_SIZE=0 disables it.
+	// CACHE_TTL is how long other replicas may serve a link after it changes
+	cacheOpts, err := cacheOptions()
+	if err != nil {
+		logger.Error("invalid cache configuration", "error", err)
+		return 1
+	}
+
+	// SNAPSHOT_DIR enables snapshots of the storage every SNAPSHOT_INTERVAL, keeping
+	// the newest SNAPSHOT_KEEP and dropping those older than SNAPSHOT_MAX_AGE
+	snapshotOpts, snapshotInterval, err := snapshotOptions()
+	if err != nil {
+		logger.Error("invalid snapshot configuration", "error", err)
+		return 1
+	}
+	// AUDIT_LOG is the file the trail of link changes is appended to
+	auditSink, closeAudit, err := openAuditSink()
+	if err != nil {
+		logger.Error("failed to open audit log", "error", err)
+		return 1
+	}
+	defer closeAudit()
+	if auditSink == audit.Discard {
+		logger.Warn("AUDIT_LOG is not set, link changes are not audited")
+	}
+	// UTM_TAGS, like "source=shortener,medium=link", tags every link that does not set its own
+	utmTags, err := link.ParseUTM(os.Getenv("UTM_TAGS"))
+	if err != nil {
+		logger.Error("invalid UTM_TAGS", "error", err)
+		return 1
+	}
+	// GEOIP_DB is a start,end,country CSV file, like DB-IP Lite's, for routing rules on countries
+	var geoIP *geoip.DB
+	if path := os.Getenv("GEOIP_DB"); path != "" {
+		if geoIP, err = geoip.Open(path); err != nil {
+			logger.Error("failed to load GEOIP_DB", "error", err)
+			return 1
+		}
+		logger.Info("loaded GeoIP database", "path", path, "ranges", geoIP.Len())
+	}
+	// BASE_URL is what public short URLs start with, e.g. https://sho.rt
+	baseURL, err := handler.ParseBaseURL(envOr("BASE_URL", handler.DefaultBaseURL))
+	if err != nil {
+		logger.Error("invalid BASE_URL", "error", err)
+		return 1
+	}
+	// TRUSTED_PROXIES lists the proxies, as addresses or CIDR ranges, whose X-Forwarded-For is believed
+	trustedProxies, err := handler.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		logger.Error("invalid TRUSTED_PROXIES", "error", err)
+		return 1
+	}
+	trashRetention, err := time.ParseDuration(envOr("TRASH_RETENTION", handler.DefaultTrashRetention.String()))
+	if err != nil || trashRetention <= 0 {
+		logger.Error("TRASH_RETENTION must be a positive duration", "value", os.Getenv("TRASH_RETENTION"))
+		return 1
+	}
+
+	// STORAGE_BACKEND is memory, file (reading FILE_PATH), redis (connecting to REDIS_URL) or postgres (connecting to DATABASE_URL)
+	backendName := envOr("STORAGE_BACKEND", "memory")
+	backend, err := openBackend(backendName, "")
+	if err != nil {
+		logger.Error("failed to open storage", "backend", backendName, "error", err)
+		return 1
+	}
+	// snapshots read the primary backend directly, as the wrappers would hide
+	// the point in time reads of those that have them
+	primary := backend
+	backend = metrics.InstrumentStore(backend, backendName)
+	// DUAL_WRITE_BACKEND mirrors every write to a second backend, at DUAL_WRITE_URL if set, while migrating to it
+	if secondaryName := os.Getenv("DUAL_WRITE_BACKEND"); secondaryName != "" {
+		secondary, err := openBackend(secondaryName, os.Getenv("DUAL_WRITE_URL"))
+		if err != nil {
+			logger.Error("failed to open dual-write storage", "backend", secondaryName, "error", err)
+			return 1
+		}
+		logger.Info("dual-writing to secondary storage", "backend", secondaryName)
+		backend = dualwrite.New(backend, metrics.InstrumentStore(secondary, secondaryName), logger)
+	}
+	if cacheOpts.Capacity > 0 {
+		backend = cache.New(backend, cacheOpts)
+	}
+	store := tracing.TraceStore(logging.LogStore(backend, backendName, logger), backendName)
 	gen := generator.New(generator.RandomGenSize)
-	shortener := handler.NewShortener(store, gen)
+	shortener := handler.NewShortener(store, gen).WithAudit(auditSink)
+	redirector := handler.NewRedirector(store).WithUTM(utmTags).WithGeoIP(geoIP)
+	qrCode := handler.NewQRCode(store).WithBaseURL(baseURL)
+	links := handler.NewLinks(store).WithBaseURL(baseURL)
+	readiness := handler.NewReadiness()
+	readiness.AddCheck("storage", handler.StoreCheck(store))
+
 	// Create handle func and register route
-	http.HandleFunc("/health", handler.HealthCheck)
-	http.Handle("/shortener", shortener)
-	log.Fatal(http.ListenAndServe(":3000", nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handler.HealthCheck)
+	mux.HandleFunc("/livez", handler.HealthCheck)
+	mux.Handle("/readyz", readiness)
+	mux.Handle("/metrics", metrics.Default)
+	mux.Handle("/shortener", instrument(logger, "shortener", 1, shortener))
+	mux.Handle(handler.QRPathPrefix, instrument(logger, "qr", 1, qrCode))
+	mux.Handle(handler.LinksPath+"/", instrument(logger, "links", 1, links))
+	// ADMIN_TOKEN enables the link list and the import, export, trash and history endpoints for callers presenting it as a bearer token
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle(handler.LinksPath, instrument(logger, "links", 1, handler.RequireToken(adminToken, links)))
+		trash := instrument(logger, "trash", 1, handler.RequireToken(adminToken, handler.NewTrash(store, trashRetention).WithAudit(auditSink).WithBaseURL(baseURL)))
+		history := instrument(logger, "history", 1, handler.RequireToken(adminToken, handler.NewHistory(store, auditSink).WithBaseURL(baseURL)))
+		mux.Handle(handler.ExportPath, instrument(logger, "export", 1, handler.RequireToken(adminToken, handler.NewExport(store))))
+		mux.Handle(handler.ImportPath, instrument(logger, "import", 1, handler.RequireToken(adminToken, handler.NewImport(store).WithAudit(auditSink))))
+		mux.Handle(handler.TrashPath, trash)
+		mux.Handle(handler.TrashPath+"/", trash)
+		mux.Handle(handler.HistoryPath+"/", history)
+	} else {
+		logger.Info("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+	mux.Handle("/", instrument(logger, "redirector", sampleRate, redirector))
+	server := &http.Server{Addr: addr, Handler: handler.TrustProxies(trustedProxies, mux)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if snapshotOpts.Dir != "" {
+		logger.Info("taking snapshots", "dir", snapshotOpts.Dir, "interval", snapshotInterval)
+		go snapshot.Run(ctx, primary, snapshotOpts, snapshotInterval, logger)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		logger.Info("shutting down", "drain_delay", drainDelay)
+		readiness.Shutdown()
+		time.Sleep(drainDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("graceful shutdown failed", "error", err)
+			exitCode = 1
+		}
+		cancel()
+
+		// capture the writes made since the last scheduled snapshot
+		if snapshotOpts.Dir != "" {
+			if _, err := snapshot.Take(context.Background(), primary, snapshotOpts); err != nil {
+				logger.Error("failed to take final snapshot", "error", err)
+			}
+		}
+	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error("failed to flush traces", "error", err)
+	}
+	return exitCode
+}
+
+// instrument wraps a handler with the access log, tracing and metrics middleware.
+func instrument(logger *slog.Logger, name string, sampleRate float64, h http.Handler) http.Handler {
+	return handler.AccessLog(logger, sampleRate, handler.WithTracing(name, handler.WithMetrics(name, h)))
+}
+
+// openBackend opens the named backend at location, a Redis or PostgreSQL URL
+// or a file path. An empty location falls back to the backend's environment
+// variable.
+func openBackend(name, location string) (storage.URLStore, error) {
+	switch name {
+	case "memory":
+		return memory.New(), nil
+	case "file":
+		f, err := os.OpenFile(backendLocation(name, location), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return file.NewFileStore(f), nil
+	case "redis":
+		opts := redis.DefaultOptions()
+		// REDIS_TTL expires links after the given duration, e.g. 720h
+		ttl, err := time.ParseDuration(envOr("REDIS_TTL", "0s"))
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_TTL: %v", err)
+		}
+		opts.TTL = ttl
+		store, err := redis.Open(backendLocation(name, location), opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.EnsureIndex(context.Background()); err != nil {
+			store.Close()
+			return nil, err
+		}
+		return store, nil
+	case "postgres":
+		opts := postgres.DefaultOptions()
+		// POSTGRES_MAX_CONNS caps the connection pool
+		maxConns, err := strconv.ParseInt(envOr("POSTGRES_MAX_CONNS", strconv.Itoa(postgres.DefaultMaxConns)), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("POSTGRES_MAX_CONNS: %v", err)
+		}
+		opts.MaxConns = int32(maxConns)
+		return postgres.Open(context.Background(), backendLocation(name, location), opts)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", name)
+}
+
+// backendLocation is where the named backend keeps its links: location if
+// set, otherwise the one its environment variable gives.
+func backendLocation(name, location string) string {
+	switch name {
+	case "file":
+		// FILE_PATH is the JSON file holding the links, created if missing
+		return orDefault(location, envOr("FILE_PATH", "links.json"))
+	case "redis":
+		return orDefault(location, envOr("REDIS_URL", "redis://localhost:6379/0"))
+	case "postgres":
+		return orDefault(location, envOr("DATABASE_URL", "postgres://localhost:5432/shortener"))
+	}
+	return location
+}
+
+func cacheOptions() (cache.Options, error) {
+	opts := cache.DefaultOptions()
+	var err error
+	if opts.Capacity, err = strconv.Atoi(envOr("CACHE_SIZE", strconv.Itoa(opts.Capacity))); err != nil {
+		return opts, fmt.Errorf("CACHE_SIZE: %v", err)
+	}
+	if opts.TTL, err = time.ParseDuration(envOr("CACHE_TTL", opts.TTL.String())); err != nil {
+		return opts, fmt.Errorf("CACHE_TTL: %v", err)
+	}
+	if opts.NegativeTTL, err = time.ParseDuration(envOr("CACHE_NEGATIVE_TTL", opts.NegativeTTL.String())); err != nil {
+		return opts, fmt.Errorf("CACHE_NEGATIVE_TTL: %v", err)
+	}
+	return opts, nil
+}
+
+func snapshotOptions() (snapshot.Options, time.Duration, error) {
+	opts := snapshot.Options{Dir: os.Getenv("SNAPSHOT_DIR")}
+	interval, err := time.ParseDuration(envOr("SNAPSHOT_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		return opts, 0, fmt.Errorf("SNAPSHOT_INTERVAL must be a positive duration, got %q", os.Getenv("SNAPSHOT_INTERVAL"))
+	}
+	if opts.Keep, err = strconv.Atoi(envOr("SNAPSHOT_KEEP", "24")); err != nil {
+		return opts, 0, fmt.Errorf("SNAPSHOT_KEEP: %v", err)
+	}
+	if opts.MaxAge, err = time.ParseDuration(envOr("SNAPSHOT_MAX_AGE", "0s")); err != nil {
+		return opts, 0, fmt.Errorf("SNAPSHOT_MAX_AGE: %v", err)
+	}
+	return opts, interval, nil
+}
+
+// openAuditSink opens the audit log at AUDIT_LOG, or returns audit.Discard
+// when it is not set, along with a function closing it.
+func openAuditSink() (audit.Sink, func(), error) {
+	path := os.Getenv("AUDIT_LOG")
+	if path == "" {
+		return audit.Discard, func() {}, nil
+	}
+	sink, err := audit.OpenFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sink, func() { sink.Close() }, nil
+}
+
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func orDefault(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
 }